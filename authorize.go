@@ -0,0 +1,160 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+var errInsufficientScope = errors.New("token is missing required scope")
+
+const (
+	credentialSession = "session"
+	credentialAPIKey  = "api_key"
+	credentialOAuth   = "oauth"
+)
+
+type principal struct {
+	UserID uuid.UUID
+	Scopes []string
+	// Credential is which kind of token authenticated the request.
+	Credential string
+	// Role is the user's stored role for sessions, read on every request so
+	// a demotion applies to tokens already issued. Other credentials never
+	// act with more than user privileges.
+	Role string
+}
+
+var errAccountDeleted = errors.New("account has been deleted")
+
+// authenticate resolves the bearer credential on the request, which may be
+// a JWT access token, a personal API key or an access token issued to an
+// OAuth client. Credentials of accounts that are deleted, or waiting out
+// the deletion grace period, don't authenticate.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	p, err := cfg.authenticateCredential(r)
+	if err != nil {
+		return principal{}, err
+	}
+	access, err := cfg.dbQueries.GetUserAccess(r.Context(), p.UserID)
+	if err == sql.ErrNoRows || (err == nil && !access.Active) {
+		return principal{}, errAccountDeleted
+	}
+	if err != nil {
+		return principal{}, err
+	}
+	// the role in a session JWT is only what it was when the token was
+	// issued
+	if p.Credential == credentialSession {
+		p.Role = access.Role
+	}
+	return p, nil
+}
+
+func (cfg *apiConfig) authenticateCredential(r *http.Request) (principal, error) {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return principal{}, err
+	}
+	if auth.IsOAuthAccessToken(token) {
+		return cfg.authenticateOAuthToken(r, token)
+	}
+	if !auth.IsAPIKey(token) {
+		claims, err := auth.ParseJwt(token, cfg.jwtKey)
+		if err != nil {
+			return principal{}, err
+		}
+		userId, err := claims.UserID()
+		if err != nil {
+			return principal{}, err
+		}
+		return principal{UserID: userId, Scopes: claims.Scopes, Credential: credentialSession}, nil
+	}
+
+	key, err := cfg.dbQueries.GetApiKeyByHash(r.Context(), auth.HashToken(token))
+	if err == sql.ErrNoRows {
+		return principal{}, errors.New("invalid api key")
+	}
+	if err != nil {
+		return principal{}, err
+	}
+	if key.RevokedAt.Valid {
+		return principal{}, errors.New("api key revoked")
+	}
+	if key.ExpiresAt.Valid && time.Now().After(key.ExpiresAt.Time) {
+		return principal{}, errors.New("api key expired")
+	}
+	cfg.dbQueries.TouchApiKey(r.Context(), key.ID)
+	return principal{UserID: key.UserID, Scopes: key.Scopes, Credential: credentialAPIKey, Role: auth.RoleUser}, nil
+}
+
+func (cfg *apiConfig) authenticateOAuthToken(r *http.Request, token string) (principal, error) {
+	oauthToken, err := cfg.dbQueries.GetOAuthToken(r.Context(), auth.HashToken(token))
+	if err == sql.ErrNoRows {
+		return principal{}, errors.New("invalid access token")
+	}
+	if err != nil {
+		return principal{}, err
+	}
+	if oauthToken.Kind != oauthTokenAccess || oauthToken.RevokedAt.Valid || time.Now().After(oauthToken.ExpiresAt) {
+		return principal{}, errors.New("invalid access token")
+	}
+	return principal{UserID: oauthToken.UserID, Scopes: oauthToken.Scopes, Credential: credentialOAuth, Role: auth.RoleUser}, nil
+}
+
+// authorize authenticates the request and checks that the credential
+// carries the scope the handler requires.
+func (cfg *apiConfig) authorize(r *http.Request, scope string) (principal, error) {
+	p, err := cfg.authenticate(r)
+	if err != nil {
+		return principal{}, err
+	}
+	if !auth.HasScope(p.Scopes, scope) {
+		return principal{}, errInsufficientScope
+	}
+	return p, nil
+}
+
+// viewer identifies the caller on routes that anonymous readers can also
+// use. A request without an Authorization header is anonymous; a header
+// that doesn't authenticate is still an error.
+func (cfg *apiConfig) viewer(r *http.Request) (uuid.NullUUID, error) {
+	if r.Header.Get("Authorization") == "" {
+		return uuid.NullUUID{}, nil
+	}
+	p, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		return uuid.NullUUID{}, err
+	}
+	return uuid.NullUUID{UUID: p.UserID, Valid: true}, nil
+}
+
+// middlewareRequireRole only lets through requests authenticated by a
+// session whose role is at least role.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if err != nil {
+			responsdWithError(w, 401, err.Error())
+			return
+		}
+		if !auth.RoleAtLeast(p.Role, role) {
+			responsdWithError(w, 403, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// respondWithAuthError maps an authorize error to 401 or 403.
+func respondWithAuthError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInsufficientScope) {
+		responsdWithError(w, 403, err.Error())
+		return
+	}
+	responsdWithError(w, 401, err.Error())
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestAuthenticateSession(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	userId := user.ID
+	req := newRequest(t, "GET", "/api/users/me", sessionToken(t, cfg, userId, []string{auth.ScopeRead}), nil)
+
+	p, err := cfg.authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UserID != userId || p.Credential != credentialSession || p.Role != auth.RoleUser {
+		t.Errorf("unexpected principal %+v", p)
+	}
+	if !slices.Equal(p.Scopes, []string{auth.ScopeRead}) {
+		t.Errorf("scopes = %v", p.Scopes)
+	}
+}
+
+func TestAuthenticateRejects(t *testing.T) {
+	cfg := testConfig(t)
+	userId := uuid.New()
+	otherKey, err := auth.MakeJWT(userId, auth.RoleUser, "some-other-key", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := auth.MakeJWT(userId, auth.RoleUser, cfg.jwtKey, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := auth.MakeMFAChallengeJWT(userId, cfg.jwtKey, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"missing":       "",
+		"wrong key":     otherKey,
+		"expired":       expired,
+		"mfa challenge": challenge,
+	}
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := cfg.authenticate(newRequest(t, "GET", "/", token, nil)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestAuthorizeChecksScope(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	token := sessionToken(t, cfg, user.ID, []string{auth.ScopeChirpsWrite})
+
+	if _, err := cfg.authorize(newRequest(t, "GET", "/", token, nil), auth.ScopeRead); err != nil {
+		t.Errorf("write scope should allow read: %v", err)
+	}
+	_, err := cfg.authorize(newRequest(t, "GET", "/", token, nil), auth.ScopeProfileWrite)
+	if !errors.Is(err, errInsufficientScope) {
+		t.Fatalf("err = %v, want errInsufficientScope", err)
+	}
+	rec := httptest.NewRecorder()
+	respondWithAuthError(rec, err)
+	if rec.Code != 403 {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestViewerAnonymous(t *testing.T) {
+	cfg := testConfig(t)
+	viewer, err := cfg.viewer(newRequest(t, "GET", "/api/chirps", "", nil))
+	if err != nil || viewer.Valid {
+		t.Errorf("viewer = %v, %v; want anonymous", viewer, err)
+	}
+	if _, err := cfg.viewer(newRequest(t, "GET", "/api/chirps", "garbage", nil)); err == nil {
+		t.Error("a bad token should not fall back to anonymous")
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	raw := createTestAPIKey(t, cfg, user.ID, []string{auth.ScopeChirpsWrite})
+
+	p, err := cfg.authenticate(newRequest(t, "GET", "/", raw, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UserID != user.ID || p.Credential != credentialAPIKey || p.Role != auth.RoleUser {
+		t.Errorf("unexpected principal %+v", p)
+	}
+	if _, err := cfg.authorize(newRequest(t, "GET", "/", raw, nil), auth.ScopeProfileWrite); !errors.Is(err, errInsufficientScope) {
+		t.Errorf("err = %v, want errInsufficientScope", err)
+	}
+
+	if _, err := cfg.authenticate(newRequest(t, "GET", "/", raw+"0", nil)); err == nil {
+		t.Error("unknown key should not authenticate")
+	}
+
+	expiredRaw, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.dbQueries.CreateApiKey(context.Background(), database.CreateApiKeyParams{
+		Name:      "expired",
+		HashedKey: auth.HashToken(expiredRaw),
+		Scopes:    []string{auth.ScopeRead},
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true},
+		UserID:    user.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.authenticate(newRequest(t, "GET", "/", expiredRaw, nil)); err == nil {
+		t.Error("expired key should not authenticate")
+	}
+
+	key, err := cfg.dbQueries.GetApiKeyByHash(context.Background(), auth.HashToken(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.dbQueries.RevokeApiKey(context.Background(), database.RevokeApiKeyParams{ID: key.ID, UserID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.authenticate(newRequest(t, "GET", "/", raw, nil)); err == nil {
+		t.Error("revoked key should not authenticate")
+	}
+}
+
+func TestAuthenticateRejectsDeletedAccount(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	raw := createTestAPIKey(t, cfg, user.ID, []string{auth.ScopeRead})
+
+	if _, err := cfg.dbQueries.MarkUserDeleted(context.Background(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	for name, credential := range map[string]string{"session": token, "api key": raw} {
+		if _, err := cfg.authenticate(newRequest(t, "GET", "/", credential, nil)); !errors.Is(err, errAccountDeleted) {
+			t.Errorf("%s: err = %v, want errAccountDeleted", name, err)
+		}
+	}
+	if _, err := cfg.authenticate(newRequest(t, "GET", "/", sessionToken(t, cfg, uuid.New(), auth.AllScopes), nil)); !errors.Is(err, errAccountDeleted) {
+		t.Errorf("purged user: err = %v, want errAccountDeleted", err)
+	}
+
+	if _, err := cfg.dbQueries.RestoreUser(context.Background(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.authenticate(newRequest(t, "GET", "/", token, nil)); err != nil {
+		t.Errorf("restored account: %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// validateEmail accepts a bare address like user@example.com and rejects
+// display names, missing domains and anything net/mail can't parse.
+func validateEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return false
+	}
+	at := strings.LastIndex(email, "@")
+	return at > 0 && strings.Contains(email[at+1:], ".")
+}
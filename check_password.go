@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Moee1149/chirpy/internal/password"
+)
+
+// checkPasswordPolicy responds with a 400 listing every broken rule and
+// returns false if the password isn't acceptable.
+func (cfg *apiConfig) checkPasswordPolicy(w http.ResponseWriter, pw, email string) bool {
+	violations, err := cfg.passwordPolicy.Validate(pw, email)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return false
+	}
+	if len(violations) == 0 {
+		return true
+	}
+	type errResponse struct {
+		Error      string               `json:"error"`
+		Violations []password.Violation `json:"violations"`
+	}
+	respondWithJSON(w, 400, errResponse{
+		Error:      "password does not meet the password policy",
+		Violations: violations,
+	})
+	return false
+}
@@ -0,0 +1,65 @@
+// Command argon2bench measures Argon2id on the current host and recommends
+// the strongest parameters that still hash within a target duration.
+//
+//	go run ./cmd/argon2bench -target 250ms -max-memory 262144
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+)
+
+func measure(params *argon2id.Params, rounds int) time.Duration {
+	var total time.Duration
+	for range rounds {
+		start := time.Now()
+		if _, err := argon2id.CreateHash("correct horse battery staple", params); err != nil {
+			log.Fatalf("Error hashing: %v", err)
+		}
+		total += time.Since(start)
+	}
+	return total / time.Duration(rounds)
+}
+
+func main() {
+	target := flag.Duration("target", 250*time.Millisecond, "longest acceptable time to hash one password")
+	maxMemory := flag.Uint("max-memory", 256*1024, "upper bound on memory per hash in KiB")
+	parallelism := flag.Uint("parallelism", uint(min(runtime.NumCPU(), 4)), "threads per hash")
+	rounds := flag.Int("rounds", 3, "hashes to average per measurement")
+	flag.Parse()
+
+	params := *argon2id.DefaultParams
+	params.Parallelism = uint8(*parallelism)
+	params.Iterations = 1
+	params.Memory = 16 * 1024
+
+	// memory is the main defence against GPU cracking so grow it first,
+	// then spend whatever time budget is left on extra iterations
+	best := params
+	for ; params.Memory <= uint32(*maxMemory); params.Memory *= 2 {
+		d := measure(&params, *rounds)
+		fmt.Printf("memory=%6d KiB iterations=%d parallelism=%d: %v\n", params.Memory, params.Iterations, params.Parallelism, d)
+		if d > *target {
+			break
+		}
+		best = params
+	}
+	params = best
+	for {
+		params.Iterations++
+		d := measure(&params, *rounds)
+		fmt.Printf("memory=%6d KiB iterations=%d parallelism=%d: %v\n", params.Memory, params.Iterations, params.Parallelism, d)
+		if d > *target {
+			break
+		}
+		best = params
+	}
+
+	fmt.Printf("\nRecommended settings for a %v target:\n", *target)
+	fmt.Printf("ARGON2_MEMORY=%d\nARGON2_ITERATIONS=%d\nARGON2_PARALLELISM=%d\n", best.Memory, best.Iterations, best.Parallelism)
+}
@@ -0,0 +1,76 @@
+// Command create-admin bootstraps the first Chirpy administrator. It
+// promotes an existing account, or creates one when -password is given.
+//
+//	go run ./cmd/create-admin -email admin@example.com -password '...'
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/password"
+	"github.com/joho/godotenv"
+	_ "github.com/lib/pq"
+)
+
+func main() {
+	email := flag.String("email", "", "email of the account to make admin")
+	pw := flag.String("password", "", "password for the account if it doesn't exist yet")
+	flag.Parse()
+	if *email == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	godotenv.Load()
+	db, err := sql.Open("postgres", os.Getenv("DB_URL"))
+	if err != nil {
+		log.Fatalf("Error Connection Database: %v", err)
+	}
+	defer db.Close()
+	queries := database.New(db)
+	ctx := context.Background()
+
+	user, err := queries.GetUserByEmail(ctx, *email)
+	if err == sql.ErrNoRows {
+		if *pw == "" {
+			log.Fatalf("no user with email %s, pass -password to create one", *email)
+		}
+		violations, err := password.DefaultPolicy.Validate(*pw, *email)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range violations {
+			log.Fatalf("password rejected: %s", v.Message)
+		}
+		hash, err := auth.HashPassword(*pw)
+		if err != nil {
+			log.Fatalf("Error hashing password: %v", err)
+		}
+		user, err = queries.CreateUser(ctx, database.CreateUserParams{
+			Email:          *email,
+			HashedPassword: hash,
+		})
+		if err != nil {
+			log.Fatalf("Error creating user: %v", err)
+		}
+		fmt.Printf("Created user %s\n", user.ID)
+	} else if err != nil {
+		log.Fatalf("Error looking up user: %v", err)
+	}
+
+	user, err = queries.SetUserRole(ctx, database.SetUserRoleParams{
+		Role: auth.RoleAdmin,
+		ID:   user.ID,
+	})
+	if err != nil {
+		log.Fatalf("Error setting role: %v", err)
+	}
+	fmt.Printf("%s is now an admin\n", user.Email)
+}
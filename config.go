@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/alexedwards/argon2id"
+)
+
+// argon2ParamsFromEnv starts from argon2id.DefaultParams and overrides any
+// of ARGON2_MEMORY (KiB), ARGON2_ITERATIONS and ARGON2_PARALLELISM that are set.
+func argon2ParamsFromEnv() (*argon2id.Params, error) {
+	params := *argon2id.DefaultParams
+	if v := os.Getenv("ARGON2_MEMORY"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARGON2_MEMORY: %w", err)
+		}
+		params.Memory = uint32(n)
+	}
+	if v := os.Getenv("ARGON2_ITERATIONS"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARGON2_ITERATIONS: %w", err)
+		}
+		params.Iterations = uint32(n)
+	}
+	if v := os.Getenv("ARGON2_PARALLELISM"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARGON2_PARALLELISM: %w", err)
+		}
+		params.Parallelism = uint8(n)
+	}
+	if params.Memory < 8*uint32(params.Parallelism) || params.Iterations == 0 || params.Parallelism == 0 {
+		return nil, fmt.Errorf("argon2 parameters out of range: %+v", params)
+	}
+	return &params, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	tierFree = "free"
+	tierRed  = "red"
+)
+
+// entitlements are the limits a tier lives with. Handlers ask for the
+// caller's entitlements instead of checking is_chirpy_red themselves, so a
+// new perk is a new field here.
+type entitlements struct {
+	MaxChirpLength int
+	// DailyChirpQuota caps chirps over any 24 hours
+	DailyChirpQuota      int
+	RefreshTokenLifetime time.Duration
+	// MaxPageSize is the largest limit accepted on paged lists
+	MaxPageSize int
+}
+
+var tierEntitlements = map[string]entitlements{
+	tierFree: {
+		MaxChirpLength:       140,
+		DailyChirpQuota:      100,
+		RefreshTokenLifetime: 60 * 24 * time.Hour,
+		MaxPageSize:          100,
+	},
+	tierRed: {
+		MaxChirpLength:       500,
+		DailyChirpQuota:      1000,
+		RefreshTokenLifetime: 180 * 24 * time.Hour,
+		MaxPageSize:          500,
+	},
+}
+
+func userTier(user database.User) string {
+	if user.IsChirpyRed {
+		return tierRed
+	}
+	return tierFree
+}
+
+func entitlementsOf(user database.User) entitlements {
+	return tierEntitlements[userTier(user)]
+}
+
+// entitlementsFor looks the user up when the handler doesn't already have
+// them loaded.
+func (cfg *apiConfig) entitlementsFor(ctx context.Context, userId uuid.UUID) (entitlements, error) {
+	user, err := cfg.dbQueries.GetUserById(ctx, userId)
+	if err != nil {
+		return entitlements{}, err
+	}
+	return entitlementsOf(user), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/database"
+)
+
+func TestTierEntitlements(t *testing.T) {
+	free, red := tierEntitlements[tierFree], tierEntitlements[tierRed]
+	for tier, limits := range tierEntitlements {
+		if limits.MaxChirpLength < 1 || limits.DailyChirpQuota < 1 || limits.RefreshTokenLifetime <= 0 || limits.MaxPageSize < 1 {
+			t.Errorf("%s tier has a limit that allows nothing: %+v", tier, limits)
+		}
+		if limits.MaxPageSize < defaultChirpPageSize || limits.MaxPageSize < defaultDeliveryPageSize {
+			t.Errorf("%s tier can't ask for the default page size", tier)
+		}
+	}
+	if red.MaxChirpLength <= free.MaxChirpLength || red.DailyChirpQuota <= free.DailyChirpQuota ||
+		red.RefreshTokenLifetime <= free.RefreshTokenLifetime || red.MaxPageSize <= free.MaxPageSize {
+		t.Errorf("red %+v should beat free %+v on every limit", red, free)
+	}
+}
+
+func TestEntitlementsOf(t *testing.T) {
+	if got := entitlementsOf(database.User{}); got != tierEntitlements[tierFree] {
+		t.Errorf("free user got %+v", got)
+	}
+	if got := entitlementsOf(database.User{IsChirpyRed: true}); got != tierEntitlements[tierRed] {
+		t.Errorf("Chirpy Red user got %+v", got)
+	}
+}
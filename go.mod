@@ -8,10 +8,13 @@ require github.com/lib/pq v1.10.9
 
 require github.com/joho/godotenv v1.5.1
 
-require github.com/alexedwards/argon2id v1.0.0
+require (
+	github.com/alexedwards/argon2id v1.0.0
+	github.com/golang-jwt/jwt/v5 v5.3.0
+	github.com/gorilla/websocket v1.5.3
+)
 
 require (
-	github.com/golang-jwt/jwt/v5 v5.3.0 // indirect
 	golang.org/x/crypto v0.14.0 // indirect
 	golang.org/x/sys v0.13.0 // indirect
 )
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	exportPending = "pending"
+	exportReady   = "ready"
+	exportFailed  = "failed"
+)
+
+// handleDeleteAccount schedules the caller's account for removal. The row
+// stays around for cfg.deletionGracePeriod so the user can change their
+// mind by logging in again, after which purgeDeletedAccounts removes it and
+// the foreign keys cascade through chirps and tokens.
+func (cfg *apiConfig) handleDeleteAccount(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	if caller.Credential != credentialSession {
+		responsdWithError(w, 403, "accounts can only be deleted from a logged in session")
+		return
+	}
+	type parameters struct {
+		Password string `json:"password"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	user, err := cfg.dbQueries.GetUserById(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if !cfg.checkCurrentPassword(w, r, user, params.Password) {
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	user, err = qtx.MarkUserDeleted(r.Context(), user.ID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if err := revokeAllUserCredentials(r.Context(), qtx, user.ID); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	resp := struct {
+		Message   string `json:"message"`
+		DeletesAt string `json:"deletes_at"`
+	}{
+		Message:   "account scheduled for deletion, log in again before it is removed to cancel",
+		DeletesAt: user.DeletedAt.Time.Add(cfg.deletionGracePeriod).UTC().Format(time.RFC3339),
+	}
+	respondWithJSON(w, 202, resp)
+}
+
+// revokeAllUserCredentials ends every session, personal API key and OAuth
+// grant the user has, for when the password they were obtained with no
+// longer stands.
+func revokeAllUserCredentials(ctx context.Context, q *database.Queries, userId uuid.UUID) error {
+	if err := q.RevokeAllUserTokens(ctx, userId); err != nil {
+		return err
+	}
+	if err := q.RevokeAllUserApiKeys(ctx, userId); err != nil {
+		return err
+	}
+	return q.RevokeAllUserOAuthTokens(ctx, userId)
+}
+
+// purgeDeletedAccounts hard deletes accounts whose grace period has passed,
+// along with their export archives. It runs until ctx is cancelled.
+func (cfg *apiConfig) purgeDeletedAccounts(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := cfg.purgeDeletedAccountsOnce(ctx); err != nil {
+			log.Printf("Error purging deleted accounts: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) purgeDeletedAccountsOnce(ctx context.Context) error {
+	cutoff := sql.NullTime{Time: time.Now().Add(-cfg.deletionGracePeriod), Valid: true}
+	rows, err := cfg.dbQueries.PurgeDeletedUsers(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+	purged := map[uuid.UUID]bool{}
+	for _, row := range rows {
+		purged[row.UserID] = true
+		if row.FilePath == "" {
+			continue
+		}
+		if err := os.Remove(row.FilePath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing data export %s: %v", row.FilePath, err)
+		}
+	}
+	if len(purged) > 0 {
+		log.Printf("Purged %d deleted accounts", len(purged))
+	}
+	return nil
+}
+
+type dataExportSchema struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func toDataExportSchema(export database.DataExport) dataExportSchema {
+	return dataExportSchema{
+		ID:        export.ID.String(),
+		Status:    export.Status,
+		Error:     export.Error,
+		CreatedAt: export.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt: export.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+func (cfg *apiConfig) handleCreateDataExport(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	export, err := cfg.dbQueries.CreateDataExport(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	// the archive is built after the response is sent, so it must not use
+	// the request context
+	go cfg.buildDataExport(context.Background(), export)
+	w.Header().Set("Location", "/api/users/me/exports/"+export.ID.String())
+	respondWithJSON(w, 202, toDataExportSchema(export))
+}
+
+func (cfg *apiConfig) handleGetDataExport(w http.ResponseWriter, r *http.Request) {
+	export, ok := cfg.loadDataExport(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, toDataExportSchema(export))
+}
+
+func (cfg *apiConfig) handleDownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	export, ok := cfg.loadDataExport(w, r)
+	if !ok {
+		return
+	}
+	if export.Status != exportReady {
+		responsdWithError(w, 409, "export is not ready")
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"chirpy-export-%s.zip\"", export.ID))
+	http.ServeFile(w, r, export.FilePath)
+}
+
+func (cfg *apiConfig) loadDataExport(w http.ResponseWriter, r *http.Request) (database.DataExport, bool) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return database.DataExport{}, false
+	}
+	exportId, err := uuid.Parse(r.PathValue("exportID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid export_id format")
+		return database.DataExport{}, false
+	}
+	export, err := cfg.dbQueries.GetDataExport(r.Context(), database.GetDataExportParams{
+		ID:     exportId,
+		UserID: caller.UserID,
+	})
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "Export not found")
+		return database.DataExport{}, false
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return database.DataExport{}, false
+	}
+	return export, true
+}
+
+func (cfg *apiConfig) buildDataExport(ctx context.Context, export database.DataExport) {
+	path := filepath.Join(cfg.exportDir, export.ID.String()+".zip")
+	params := database.CompleteDataExportParams{
+		Status:   exportReady,
+		FilePath: path,
+		ID:       export.ID,
+	}
+	if err := cfg.writeDataExport(ctx, export.UserID, path); err != nil {
+		log.Printf("Error building data export %s: %v", export.ID, err)
+		os.Remove(path)
+		params.Status = exportFailed
+		params.FilePath = ""
+		params.Error = "export failed, please try again"
+	}
+	if err := cfg.dbQueries.CompleteDataExport(ctx, params); err != nil {
+		log.Printf("Error saving data export %s: %v", export.ID, err)
+	}
+}
+
+// writeDataExport writes a zip holding the user's profile, chirps and
+// sessions as JSON. Secrets such as password hashes and token values are
+// left out.
+func (cfg *apiConfig) writeDataExport(ctx context.Context, userId uuid.UUID, path string) error {
+	user, err := cfg.dbQueries.GetUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+	chirps, err := cfg.dbQueries.GetChirpsByAuthorId(ctx, database.GetChirpsByAuthorIdParams{UserID: userId})
+	if err != nil {
+		return err
+	}
+	tokens, err := cfg.dbQueries.GetRefreshTokensByUserId(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	chirpList := make([]chirpSchema, 0, len(chirps))
+	for _, chirp := range chirps {
+		chirpList = append(chirpList, toChirpSchema(chirp))
+	}
+	type session struct {
+		CreatedAt string  `json:"created_at"`
+		ExpiresAt string  `json:"expires_at"`
+		RevokedAt *string `json:"revoked_at"`
+	}
+	sessions := make([]session, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, session{
+			CreatedAt: token.CreatedAt.UTC().Format(time.RFC3339),
+			ExpiresAt: token.ExpiresAt.UTC().Format(time.RFC3339),
+			RevokedAt: nullTimeString(token.RevokedAt),
+		})
+	}
+
+	if err := os.MkdirAll(cfg.exportDir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	files := []struct {
+		name string
+		data any
+	}{
+		{"profile.json", toUserSchema(user)},
+		{"chirps.json", chirpList},
+		{"sessions.json", sessions},
+	}
+	for _, file := range files {
+		fw, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(file.data); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/database"
+)
+
+func TestPurgeDeletedAccountsRemovesExports(t *testing.T) {
+	cfg := testDBConfig(t)
+	ctx := context.Background()
+	user, _ := createTestUser(t, cfg)
+	kept, _ := createTestUser(t, cfg)
+
+	export, err := cfg.dbQueries.CreateDataExport(ctx, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.buildDataExport(ctx, export)
+	path := filepath.Join(cfg.exportDir, export.ID.String()+".zip")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("export not written: %v", err)
+	}
+
+	if _, err := cfg.dbQueries.MarkUserDeleted(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	// a negative grace period makes the account due straight away
+	cfg.deletionGracePeriod = -time.Minute
+	if err := cfg.purgeDeletedAccountsOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cfg.dbQueries.GetUserById(ctx, user.ID); err == nil {
+		t.Error("deleted user was not purged")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("export archive still on disk: %v", err)
+	}
+	if _, err := cfg.dbQueries.GetDataExport(ctx, database.GetDataExportParams{ID: export.ID, UserID: user.ID}); err == nil {
+		t.Error("export row survived the purge")
+	}
+	if _, err := cfg.dbQueries.GetUserById(ctx, kept.ID); err != nil {
+		t.Errorf("active user was purged: %v", err)
+	}
+}
+
+func TestDeleteAccountPasswordIsThrottled(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	remove := func(password string) int {
+		return do(t, cfg.handleDeleteAccount, "DELETE", "/api/users/me", token, map[string]string{"password": password}).Code
+	}
+
+	for i := range cfg.accountLimiter.Threshold {
+		if code := remove("guess"); code != 401 {
+			t.Fatalf("guess %d: status = %d, want 401", i, code)
+		}
+	}
+	if code := remove("correct horse battery"); code != 429 {
+		t.Errorf("status = %d, want 429 once the account is locked", code)
+	}
+	got, err := cfg.dbQueries.GetUserById(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DeletedAt.Valid {
+		t.Error("account was deleted while locked")
+	}
+}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handleMetrics(w http.ResponseWriter, r *http.Request) {
@@ -36,6 +39,82 @@ func (cfg *apiConfig) handleReset(platform string) http.HandlerFunc {
 	}
 }
 
+func (cfg *apiConfig) handleUnlockAccount(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if params.Email == "" && params.IP == "" {
+		responsdWithError(w, 400, "missing email or ip field")
+		return
+	}
+	if params.Email != "" {
+		if err := cfg.accountLimiter.Reset(r.Context(), accountThrottleKey(params.Email)); err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		// second factor failures are counted by user id rather than email
+		user, err := cfg.dbQueries.GetUserByEmail(r.Context(), params.Email)
+		if err != nil && err != sql.ErrNoRows {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		if err == nil {
+			if err := cfg.accountLimiter.Reset(r.Context(), mfaThrottleKey(user.ID)); err != nil {
+				responsdWithError(w, 500, "Internal Server Error")
+				return
+			}
+		}
+	}
+	if params.IP != "" {
+		if err := cfg.ipLimiter.Reset(r.Context(), ipThrottleKey(params.IP)); err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handleSetUserRole(w http.ResponseWriter, r *http.Request) {
+	userId, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid user_id format")
+		return
+	}
+	type parameters struct {
+		Role string `json:"role"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if !auth.IsValidRole(params.Role) {
+		responsdWithError(w, 400, "role must be one of user, moderator or admin")
+		return
+	}
+	user, err := cfg.dbQueries.SetUserRole(r.Context(), database.SetUserRoleParams{
+		Role: params.Role,
+		ID:   userId,
+	})
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "User Not Found")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, 200, toUserSchema(user))
+}
+
 func (cfg *apiConfig) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -50,11 +129,24 @@ func (cfg *apiConfig) handleRefreshToken(w http.ResponseWriter, r *http.Request)
 		responsdWithError(w, 500, "Internal Server Error")
 		return
 	}
+	if refresh_token.RevokedAt.Valid {
+		responsdWithError(w, 401, "Unauthorized: token revoked")
+		return
+	}
 	if time.Now().After(refresh_token.ExpiresAt) {
 		responsdWithError(w, 401, "Unauthorized: token expired")
 		return
 	}
-	token, err := auth.MakeJWT(refresh_token.UserID, cfg.jwtKey, 3600*time.Second)
+	user, err := cfg.dbQueries.GetUserById(r.Context(), refresh_token.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if user.DeletedAt.Valid {
+		responsdWithError(w, 401, "Unauthorized: account deleted")
+		return
+	}
+	token, err := auth.MakeJWT(user.ID, user.Role, cfg.jwtKey, 3600*time.Second)
 	if err != nil {
 		responsdWithError(w, 500, "Internal Server Error")
 		return
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// roleToken gives userId role and returns a session token issued with it.
+func roleToken(t *testing.T, cfg *apiConfig, userId uuid.UUID, role string) string {
+	t.Helper()
+	if _, err := cfg.dbQueries.SetUserRole(context.Background(), database.SetUserRoleParams{ID: userId, Role: role}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeScopedJWT(userId, role, cfg.jwtKey, time.Hour, auth.AllScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRequireAdmin(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, userToken := createTestUser(t, cfg)
+	moderator, _ := createTestUser(t, cfg)
+	admin, _ := createTestUser(t, cfg)
+	handler := cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	call := func(token string) int {
+		return serve(handler.ServeHTTP, newRequest(t, "GET", "/admin/metrics", token, nil)).Code
+	}
+
+	// an admin claim that the stored role doesn't back up
+	staleToken, err := auth.MakeScopedJWT(user.ID, auth.RoleAdmin, cfg.jwtKey, time.Hour, auth.AllScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		token string
+		want  int
+	}{
+		"anonymous":        {"", 401},
+		"user session":     {userToken, 403},
+		"moderator":        {roleToken(t, cfg, moderator.ID, auth.RoleModerator), 403},
+		"admin session":    {roleToken(t, cfg, admin.ID, auth.RoleAdmin), 204},
+		"admin's API key":  {createTestAPIKey(t, cfg, admin.ID, auth.AllScopes), 403},
+		"stale role claim": {staleToken, 403},
+	}
+	for name, tc := range cases {
+		if got := call(tc.token); got != tc.want {
+			t.Errorf("%s: status %d, want %d", name, got, tc.want)
+		}
+	}
+}
+
+func TestDemotedAdminLosesAccess(t *testing.T) {
+	cfg := testDBConfig(t)
+	admin, _ := createTestUser(t, cfg)
+	token := roleToken(t, cfg, admin.ID, auth.RoleAdmin)
+	handler := cfg.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	call := func() int {
+		return serve(handler.ServeHTTP, newRequest(t, "GET", "/admin/metrics", token, nil)).Code
+	}
+
+	if code := call(); code != 204 {
+		t.Fatalf("status %d before the demotion, want 204", code)
+	}
+	if _, err := cfg.dbQueries.SetUserRole(context.Background(), database.SetUserRoleParams{ID: admin.ID, Role: auth.RoleUser}); err != nil {
+		t.Fatal(err)
+	}
+	if code := call(); code != 403 {
+		t.Errorf("status %d with the token issued before the demotion, want 403", code)
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	setRole := func(userId, role string) (int, string) {
+		t.Helper()
+		req := newRequest(t, "PUT", "/admin/users/"+userId+"/role", "", map[string]string{"role": role})
+		req.SetPathValue("userID", userId)
+		rec := serve(cfg.handleSetUserRole, req)
+		if rec.Code != 200 {
+			return rec.Code, ""
+		}
+		var resp struct {
+			Role string `json:"role"`
+		}
+		decodeJSON(t, rec, &resp)
+		return rec.Code, resp.Role
+	}
+
+	if code, role := setRole(user.ID.String(), auth.RoleModerator); code != 200 || role != auth.RoleModerator {
+		t.Errorf("promoting: status %d, role %q", code, role)
+	}
+	if code, _ := setRole(user.ID.String(), "superuser"); code != 400 {
+		t.Errorf("unknown role: status %d, want 400", code)
+	}
+	if code, _ := setRole(uuid.NewString(), auth.RoleAdmin); code != 404 {
+		t.Errorf("unknown user: status %d, want 404", code)
+	}
+	if code, _ := setRole("nope", auth.RoleAdmin); code != 400 {
+		t.Errorf("bad id: status %d, want 400", code)
+	}
+}
+
+func TestModeratorDeletesChirp(t *testing.T) {
+	cfg := testDBConfig(t)
+	_, authorToken := createTestUser(t, cfg)
+	_, otherToken := createTestUser(t, cfg)
+	mod, _ := createTestUser(t, cfg)
+	moderator := roleToken(t, cfg, mod.ID, auth.RoleModerator)
+
+	post := func() string {
+		rec := do(t, cfg.handleCreateChirps, "POST", "/api/chirps", authorToken, map[string]string{"body": "hello"})
+		if rec.Code != 201 {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var chirp chirpSchema
+		decodeJSON(t, rec, &chirp)
+		return chirp.ID
+	}
+	remove := func(chirpId, token string) int {
+		req := newRequest(t, "DELETE", "/api/chirps/"+chirpId, token, nil)
+		req.SetPathValue("chirpID", chirpId)
+		return serve(cfg.handleDeleteChirps, req).Code
+	}
+
+	chirpId := post()
+	if code := remove(chirpId, otherToken); code != 403 {
+		t.Errorf("another user deleting: status %d, want 403", code)
+	}
+	if code := remove(chirpId, moderator); code != 204 {
+		t.Errorf("moderator deleting: status %d, want 204", code)
+	}
+	if code := remove(chirpId, authorToken); code != 404 {
+		t.Errorf("deleting again: status %d, want 404", code)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Blocks work in both directions: neither side sees the other's chirps.
+// Mutes only hide the muted user's chirps from the muter's timeline.
+// The filtering itself lives in the chirp queries.
+
+type relationSchema struct {
+	UserID    string `json:"user_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+// relationTarget reads the {"user_id": ...} body shared by the block and
+// mute endpoints and makes sure it names another, existing user.
+func (cfg *apiConfig) relationTarget(w http.ResponseWriter, r *http.Request, caller principal) (uuid.UUID, bool) {
+	type parameters struct {
+		UserID string `json:"user_id"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return uuid.Nil, false
+	}
+	targetId, err := uuid.Parse(params.UserID)
+	if err != nil {
+		responsdWithError(w, 400, "Invalid user_id format")
+		return uuid.Nil, false
+	}
+	if targetId == caller.UserID {
+		responsdWithError(w, 400, "cannot target yourself")
+		return uuid.Nil, false
+	}
+	target, err := cfg.dbQueries.GetUserById(r.Context(), targetId)
+	if err == sql.ErrNoRows || (err == nil && target.DeletedAt.Valid) {
+		responsdWithError(w, 404, "User Not Found")
+		return uuid.Nil, false
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return uuid.Nil, false
+	}
+	return targetId, true
+}
+
+// respondAffected answers 204 when the statement touched a row and 404
+// otherwise.
+func respondAffected(w http.ResponseWriter, result sql.Result, err error, notFound string) {
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if rowsAffected == 0 {
+		responsdWithError(w, 404, notFound)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handleBlockUser(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	targetId, ok := cfg.relationTarget(w, r, caller)
+	if !ok {
+		return
+	}
+	err = cfg.dbQueries.BlockUser(r.Context(), database.BlockUserParams{
+		BlockerID: caller.UserID,
+		BlockedID: targetId,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handleListBlocks(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	blocks, err := cfg.dbQueries.ListBlockedUsers(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting blocks: %v", err))
+		return
+	}
+	resp := make([]relationSchema, 0, len(blocks))
+	for _, block := range blocks {
+		resp = append(resp, relationSchema{
+			UserID:    block.BlockedID.String(),
+			CreatedAt: block.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handleUnblockUser(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	targetId, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid user_id format")
+		return
+	}
+	result, err := cfg.dbQueries.UnblockUser(r.Context(), database.UnblockUserParams{
+		BlockerID: caller.UserID,
+		BlockedID: targetId,
+	})
+	respondAffected(w, result, err, "Block not found")
+}
+
+func (cfg *apiConfig) handleMuteUser(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	targetId, ok := cfg.relationTarget(w, r, caller)
+	if !ok {
+		return
+	}
+	err = cfg.dbQueries.MuteUser(r.Context(), database.MuteUserParams{
+		MuterID: caller.UserID,
+		MutedID: targetId,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *apiConfig) handleListMutes(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	mutes, err := cfg.dbQueries.ListMutedUsers(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting mutes: %v", err))
+		return
+	}
+	resp := make([]relationSchema, 0, len(mutes))
+	for _, mute := range mutes {
+		resp = append(resp, relationSchema{
+			UserID:    mute.MutedID.String(),
+			CreatedAt: mute.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handleUnmuteUser(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	targetId, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid user_id format")
+		return
+	}
+	result, err := cfg.dbQueries.UnmuteUser(r.Context(), database.UnmuteUserParams{
+		MuterID: caller.UserID,
+		MutedID: targetId,
+	})
+	respondAffected(w, result, err, "Mute not found")
+}
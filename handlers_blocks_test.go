@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func chirpAuthors(chirps []database.Chirp) map[uuid.UUID]bool {
+	authors := map[uuid.UUID]bool{}
+	for _, chirp := range chirps {
+		authors[chirp.UserID] = true
+	}
+	return authors
+}
+
+func TestBlockHidesChirpsBothWays(t *testing.T) {
+	cfg := testDBConfig(t)
+	alice, aliceToken := createTestUser(t, cfg)
+	bob, bobToken := createTestUser(t, cfg)
+	postChirp(t, cfg, aliceToken, "from alice")
+	postChirp(t, cfg, bobToken, "from bob")
+
+	block := func(target string) int {
+		return do(t, cfg.handleBlockUser, "POST", "/api/users/me/blocks", aliceToken, map[string]string{"user_id": target}).Code
+	}
+	if code := block(bob.ID.String()); code != 204 {
+		t.Fatalf("block: status %d", code)
+	}
+	if code := block(alice.ID.String()); code != 400 {
+		t.Errorf("blocking yourself: status %d, want 400", code)
+	}
+	if code := block(uuid.NewString()); code != 404 {
+		t.Errorf("blocking an unknown user: status %d, want 404", code)
+	}
+
+	if authors := chirpAuthors(listChirps(t, cfg, aliceToken, "")); authors[bob.ID] || !authors[alice.ID] {
+		t.Errorf("alice sees chirps by %v", authors)
+	}
+	if authors := chirpAuthors(listChirps(t, cfg, bobToken, "")); authors[alice.ID] || !authors[bob.ID] {
+		t.Errorf("bob sees chirps by %v", authors)
+	}
+	if chirps := listChirps(t, cfg, bobToken, "author_id="+alice.ID.String()); len(chirps) != 0 {
+		t.Errorf("bob can list alice's chirps by author: %d", len(chirps))
+	}
+
+	rec := do(t, cfg.handleListBlocks, "GET", "/api/users/me/blocks", aliceToken, nil)
+	var blocks []relationSchema
+	decodeJSON(t, rec, &blocks)
+	if len(blocks) != 1 || blocks[0].UserID != bob.ID.String() {
+		t.Errorf("blocks = %+v", blocks)
+	}
+
+	unblock := func() int {
+		req := newRequest(t, "DELETE", "/api/users/me/blocks/"+bob.ID.String(), aliceToken, nil)
+		req.SetPathValue("userID", bob.ID.String())
+		return serve(cfg.handleUnblockUser, req).Code
+	}
+	if code := unblock(); code != 204 {
+		t.Fatalf("unblock: status %d", code)
+	}
+	if code := unblock(); code != 404 {
+		t.Errorf("unblocking twice: status %d, want 404", code)
+	}
+	if authors := chirpAuthors(listChirps(t, cfg, bobToken, "")); !authors[alice.ID] {
+		t.Error("bob still can't see alice after the unblock")
+	}
+}
+
+func TestMuteOnlyHidesTimeline(t *testing.T) {
+	cfg := testDBConfig(t)
+	alice, aliceToken := createTestUser(t, cfg)
+	bob, bobToken := createTestUser(t, cfg)
+	postChirp(t, cfg, aliceToken, "from alice")
+	postChirp(t, cfg, bobToken, "from bob")
+
+	rec := do(t, cfg.handleMuteUser, "POST", "/api/users/me/mutes", aliceToken, map[string]string{"user_id": bob.ID.String()})
+	if rec.Code != 204 {
+		t.Fatalf("mute: status %d: %s", rec.Code, rec.Body)
+	}
+	if authors := chirpAuthors(listChirps(t, cfg, aliceToken, "")); authors[bob.ID] {
+		t.Error("muted chirps still on alice's timeline")
+	}
+	if chirps := listChirps(t, cfg, aliceToken, "author_id="+bob.ID.String()); len(chirps) != 1 {
+		t.Errorf("alice should still see bob's chirps on his page, got %d", len(chirps))
+	}
+	if authors := chirpAuthors(listChirps(t, cfg, bobToken, "")); !authors[alice.ID] {
+		t.Error("muting hid alice from bob")
+	}
+
+	rec = do(t, cfg.handleListMutes, "GET", "/api/users/me/mutes", aliceToken, nil)
+	var mutes []relationSchema
+	decodeJSON(t, rec, &mutes)
+	if len(mutes) != 1 || mutes[0].UserID != bob.ID.String() {
+		t.Errorf("mutes = %+v", mutes)
+	}
+}
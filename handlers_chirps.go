@@ -5,28 +5,44 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"sort"
+	"strconv"
+	"time"
 
 	"github.com/Moee1149/chirpy/internal/auth"
 	"github.com/Moee1149/chirpy/internal/database"
 	"github.com/google/uuid"
 )
 
+const defaultChirpPageSize = 50
+
+func toChirpSchema(chirp database.Chirp) chirpSchema {
+	return chirpSchema{
+		ID:        chirp.ID.String(),
+		CreateAt:  chirp.CreatedAt.UTC().Format("2006-01-0215:04:05Z"),
+		UpdatedAt: chirp.UpdatedAt.UTC().Format("2006-01-0215:04:05Z"),
+		Body:      chirp.Body,
+		UserId:    chirp.UserID.String(),
+	}
+}
+
 func (cfg *apiConfig) handleCreateChirps(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	caller, err := cfg.authorize(r, auth.ScopeChirpsWrite)
 	if err != nil {
-		responsdWithError(w, 400, err.Error())
+		respondWithAuthError(w, err)
 		return
 	}
-
-	_, err = auth.ValidateJwt(token, cfg.jwtKey)
+	user, err := cfg.dbQueries.GetUserById(r.Context(), caller.UserID)
 	if err != nil {
-		responsdWithError(w, 401, "Invalid Token")
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if cfg.requireVerifiedEmail && !user.EmailVerifiedAt.Valid {
+		responsdWithError(w, 403, "verify your email address before posting")
 		return
 	}
+	limits := entitlementsOf(user)
 	type parameters struct {
-		Body    string `json:"body"`
-		User_Id string `json:"user_id"`
+		Body string `json:"body"`
 	}
 
 	params := parameters{}
@@ -35,111 +51,143 @@ func (cfg *apiConfig) handleCreateChirps(w http.ResponseWriter, r *http.Request)
 		respondWithJSON(w, 500, fmt.Sprintf("Error decoding json: %v", err))
 		return
 	}
-	if len(params.Body) > 140 {
+	if len(params.Body) > limits.MaxChirpLength {
 		responsdWithError(w, 400, "The chirpy is too long")
 		return
 	}
 	//check for profane words
 	cleanedBody := validateBadWords(params.Body)
-	userId, err := uuid.Parse(params.User_Id)
-	if err != nil {
-		responsdWithError(w, 400, "Invalid user_id format")
-		return
-	}
 	chirpsParams := database.CreateChirpyParams{
 		Body:   cleanedBody,
-		UserID: userId,
+		UserID: caller.UserID,
 	}
 
-	chirp, err := cfg.dbQueries.CreateChirpy(r.Context(), chirpsParams)
+	// the quota is counted and the chirp inserted under a per-user lock, so
+	// concurrent posts can't all see room for one more
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	if err := qtx.LockChirpQuota(r.Context(), caller.UserID); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	posted, err := qtx.CountChirpsSince(r.Context(), database.CountChirpsSinceParams{
+		UserID:    caller.UserID,
+		CreatedAt: time.Now().Add(-24 * time.Hour),
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if posted >= int64(limits.DailyChirpQuota) {
+		responsdWithError(w, 429, fmt.Sprintf("daily limit of %d chirps reached", limits.DailyChirpQuota))
+		return
+	}
+	chirp, err := qtx.CreateChirpy(r.Context(), chirpsParams)
 	if err != nil {
 		responsdWithError(w, 500, fmt.Sprintf("Error adding chirps: %v", err))
 		return
 	}
-	resp := chirpSchema{
-		ID:        chirp.ID.String(),
-		CreateAt:  chirp.CreatedAt.UTC().Format("2006-01-0215:04:05Z"),
-		UpdatedAt: chirp.UpdatedAt.UTC().Format("2006-01-0215:04:05Z"),
-		Body:      chirp.Body,
-		UserId:    chirp.UserID.String(),
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
 	}
-	respondWithJSON(w, 201, resp)
+	cfg.publishChirpEvent(r.Context(), eventChirpCreated, chirp.UserID, toChirpSchema(chirp))
+	cfg.emitWebhook(r.Context(), webhookChirpCreated, chirp.UserID, toChirpSchema(chirp))
+	respondWithJSON(w, 201, toChirpSchema(chirp))
 }
 
+// handleGetChirps lists chirps, optionally by one author, a page at a
+// time: oldest first, or newest first with ?sort=desc, continuing after the
+// chirp given as ?after=. Chirpy Red viewers may ask for bigger pages.
 func (cfg *apiConfig) handleGetChirps(w http.ResponseWriter, r *http.Request) {
-	author_id := r.URL.Query().Get("author_id")
-	sort_by := r.URL.Query().Get("sort")
-	if author_id != "" {
+	viewer, err := cfg.viewer(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	params := database.ListChirpsParams{
+		ViewerID:   viewer,
+		Descending: query.Get("sort") == "desc",
+	}
+	if author_id := query.Get("author_id"); author_id != "" {
 		user_id, err := uuid.Parse(author_id)
 		if err != nil {
 			responsdWithError(w, 400, err.Error())
 			return
 		}
-		chirps, err := cfg.dbQueries.GetChirpsByAuthorId(r.Context(), user_id)
+		params.AuthorID = uuid.NullUUID{UUID: user_id, Valid: true}
+	}
+	if raw := query.Get("after"); raw != "" {
+		id, err := uuid.Parse(raw)
 		if err != nil {
-			responsdWithError(w, 500, fmt.Sprintf("Error getting chirps: %v", err))
+			responsdWithError(w, 400, "Invalid after format")
 			return
 		}
-		if sort_by == "desc" {
-			sort.Slice(chirps, func(i, j int) bool {
-				return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
-			})
-		} else {
-			sort.Slice(chirps, func(i, j int) bool {
-				return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
-			})
-		}
-		respondWithJSON(w, 200, chirps)
-	} else {
-		chirps, err := cfg.dbQueries.GetAllChirps(r.Context())
+		params.After = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	limits := tierEntitlements[tierFree]
+	if viewer.Valid {
+		limits, err = cfg.entitlementsFor(r.Context(), viewer.UUID)
 		if err != nil {
-			responsdWithError(w, 500, fmt.Sprintf("Error getting chirps: %v", err))
+			responsdWithError(w, 500, "Internal Server Error")
 			return
 		}
-		if sort_by == "desc" {
-			sort.Slice(chirps, func(i, j int) bool {
-				return chirps[i].CreatedAt.After(chirps[j].CreatedAt)
-			})
-		} else {
-			sort.Slice(chirps, func(i, j int) bool {
-				return chirps[i].CreatedAt.Before(chirps[j].CreatedAt)
-			})
+	}
+	limit := defaultChirpPageSize
+	if raw := query.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > limits.MaxPageSize {
+			responsdWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", limits.MaxPageSize))
+			return
 		}
-		respondWithJSON(w, 200, chirps)
 	}
+	params.Limit = int32(limit)
+	chirps, err := cfg.dbQueries.ListChirps(r.Context(), params)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting chirps: %v", err))
+		return
+	}
+	if chirps == nil {
+		chirps = []database.Chirp{}
+	}
+	cfg.recordImpressions(r, viewer, chirps...)
+	respondWithJSON(w, 200, chirps)
 }
 
 func (cfg *apiConfig) handleGetChirpsById(w http.ResponseWriter, r *http.Request) {
+	viewer, err := cfg.viewer(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
 	pathValue := r.PathValue("chirpID")
 	chirpId, err := uuid.Parse(pathValue)
 	if err != nil {
 		responsdWithError(w, 400, "Invalid user_id format")
 		return
 	}
-	chirp, err := cfg.dbQueries.GetChirpsById(r.Context(), chirpId)
+	chirp, err := cfg.dbQueries.GetChirpsById(r.Context(), database.GetChirpsByIdParams{
+		ID:       chirpId,
+		ViewerID: viewer,
+	})
 	if err != nil {
 		responsdWithError(w, 400, fmt.Sprintf("Error getting chirps: %v", err))
 		return
 	}
-	resp := chirpSchema{
-		ID:        chirp.ID.String(),
-		CreateAt:  chirp.CreatedAt.UTC().Format("2006-01-0215:04:05Z"),
-		UpdatedAt: chirp.UpdatedAt.UTC().Format("2006-01-0215:04:05Z"),
-		Body:      chirp.Body,
-		UserId:    chirp.UserID.String(),
-	}
-	respondWithJSON(w, 200, resp)
+	cfg.recordImpressions(r, viewer, chirp)
+	respondWithJSON(w, 200, toChirpSchema(chirp))
 }
 
 func (cfg *apiConfig) handleDeleteChirps(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		responsdWithError(w, 401, err.Error())
-		return
-	}
-	user_id, err := auth.ValidateJwt(token, cfg.jwtKey)
+	caller, err := cfg.authorize(r, auth.ScopeChirpsWrite)
 	if err != nil {
-		responsdWithError(w, 401, err.Error())
+		respondWithAuthError(w, err)
 		return
 	}
 	pathValue := r.PathValue("chirpID")
@@ -148,7 +196,7 @@ func (cfg *apiConfig) handleDeleteChirps(w http.ResponseWriter, r *http.Request)
 		responsdWithError(w, 400, "Invalid chirp_id format")
 		return
 	}
-	chirp, err := cfg.dbQueries.GetChirpsById(r.Context(), chirpId)
+	chirp, err := cfg.dbQueries.GetChirpsById(r.Context(), database.GetChirpsByIdParams{ID: chirpId})
 	if err != nil {
 		if err == sql.ErrNoRows {
 			responsdWithError(w, 404, "Chirp not found")
@@ -157,11 +205,16 @@ func (cfg *apiConfig) handleDeleteChirps(w http.ResponseWriter, r *http.Request)
 		responsdWithError(w, 500, "An Unknow error occured")
 		return
 	}
-	params := database.DeleteChirpByIdParams{
-		ID:     chirp.ID,
-		UserID: user_id,
+	var result sql.Result
+	if chirp.UserID != caller.UserID && auth.RoleAtLeast(caller.Role, auth.RoleModerator) {
+		result, err = cfg.dbQueries.DeleteChirpByIdAsModerator(r.Context(), chirp.ID)
+	} else {
+		params := database.DeleteChirpByIdParams{
+			ID:     chirp.ID,
+			UserID: caller.UserID,
+		}
+		result, err = cfg.dbQueries.DeleteChirpById(r.Context(), params)
 	}
-	result, err := cfg.dbQueries.DeleteChirpById(r.Context(), params)
 	if err != nil {
 		responsdWithError(w, 500, "Internal Server Error")
 		return
@@ -175,6 +228,19 @@ func (cfg *apiConfig) handleDeleteChirps(w http.ResponseWriter, r *http.Request)
 		responsdWithError(w, 403, "")
 		return
 	}
+	deleted := chirpDeletedPayload{
+		ID:     chirp.ID.String(),
+		UserId: chirp.UserID.String(),
+	}
+	cfg.publishChirpEvent(r.Context(), eventChirpDeleted, chirp.UserID, deleted)
+	cfg.emitWebhook(r.Context(), webhookChirpDeleted, chirp.UserID, deleted)
+	if chirp.UserID != caller.UserID {
+		cfg.notify(r.Context(), chirp.UserID, notifyChirpDeleted, struct {
+			ChirpID string `json:"chirp_id"`
+		}{
+			ChirpID: chirp.ID.String(),
+		})
+	}
 	resp := struct {
 		MESSAGE string `json:"message"`
 	}{
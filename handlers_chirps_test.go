@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/database"
+)
+
+// withChirpQuota lowers the free tier's daily quota for one test.
+func withChirpQuota(t *testing.T, quota int) {
+	t.Helper()
+	free := tierEntitlements[tierFree]
+	t.Cleanup(func() { tierEntitlements[tierFree] = free })
+	limited := free
+	limited.DailyChirpQuota = quota
+	tierEntitlements[tierFree] = limited
+}
+
+func postChirp(t *testing.T, cfg *apiConfig, token, body string) int {
+	t.Helper()
+	return do(t, cfg.handleCreateChirps, "POST", "/api/chirps", token, map[string]string{"body": body}).Code
+}
+
+func listChirps(t *testing.T, cfg *apiConfig, token, query string) []database.Chirp {
+	t.Helper()
+	rec := do(t, cfg.handleGetChirps, "GET", "/api/chirps?"+query, token, nil)
+	if rec.Code != 200 {
+		t.Fatalf("GET /api/chirps?%s: status %d: %s", query, rec.Code, rec.Body)
+	}
+	var chirps []database.Chirp
+	decodeJSON(t, rec, &chirps)
+	return chirps
+}
+
+func TestCreateChirpEnforcesQuota(t *testing.T) {
+	cfg := testDBConfig(t)
+	withChirpQuota(t, 2)
+	_, token := createTestUser(t, cfg)
+	for i := 0; i < 2; i++ {
+		if code := postChirp(t, cfg, token, "hello"); code != 201 {
+			t.Fatalf("chirp %d: status %d", i, code)
+		}
+	}
+	if code := postChirp(t, cfg, token, "one too many"); code != 429 {
+		t.Errorf("status %d, want 429 past the quota", code)
+	}
+}
+
+func TestCreateChirpQuotaConcurrent(t *testing.T) {
+	cfg := testDBConfig(t)
+	withChirpQuota(t, 3)
+	_, token := createTestUser(t, cfg)
+
+	const posts = 10
+	codes := make(chan int, posts)
+	var wg sync.WaitGroup
+	for i := 0; i < posts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := newRequest(t, "POST", "/api/chirps", token, map[string]string{"body": "racing"})
+			codes <- serve(cfg.handleCreateChirps, req).Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	created := 0
+	for code := range codes {
+		if code == 201 {
+			created++
+		} else if code != 429 {
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if created != 3 {
+		t.Errorf("%d chirps created, want the quota of 3", created)
+	}
+}
+
+func TestGetChirpsPaginates(t *testing.T) {
+	cfg := testDBConfig(t)
+	author, token := createTestUser(t, cfg)
+	for i := 0; i < 5; i++ {
+		if code := postChirp(t, cfg, token, fmt.Sprintf("chirp %d", i)); code != 201 {
+			t.Fatalf("chirp %d: status %d", i, code)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	authorQuery := "author_id=" + author.ID.String()
+
+	var asc []string
+	for after := ""; ; {
+		query := authorQuery + "&limit=2"
+		if after != "" {
+			query += "&after=" + after
+		}
+		page := listChirps(t, cfg, "", query)
+		if len(page) > 2 {
+			t.Fatalf("page of %d, want at most 2", len(page))
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, chirp := range page {
+			asc = append(asc, chirp.Body)
+		}
+		after = page[len(page)-1].ID.String()
+	}
+	want := []string{"chirp 0", "chirp 1", "chirp 2", "chirp 3", "chirp 4"}
+	if fmt.Sprint(asc) != fmt.Sprint(want) {
+		t.Errorf("paged oldest first: %v, want %v", asc, want)
+	}
+
+	desc := listChirps(t, cfg, "", authorQuery+"&sort=desc&limit=2")
+	if len(desc) != 2 || desc[0].Body != "chirp 4" || desc[1].Body != "chirp 3" {
+		t.Fatalf("newest first: %+v", desc)
+	}
+	next := listChirps(t, cfg, "", authorQuery+"&sort=desc&limit=2&after="+desc[1].ID.String())
+	if len(next) != 2 || next[0].Body != "chirp 2" || next[1].Body != "chirp 1" {
+		t.Errorf("second newest-first page: %+v", next)
+	}
+
+	if all := listChirps(t, cfg, "", ""); len(all) != 5 {
+		t.Errorf("unfiltered list has %d chirps, want 5", len(all))
+	}
+}
+
+func TestGetChirpsLimitFollowsTier(t *testing.T) {
+	cfg := testDBConfig(t)
+	cfg.polkaSecrets = []string{testPolkaSecret}
+	user, token := createTestUser(t, cfg)
+	free, red := tierEntitlements[tierFree].MaxPageSize, tierEntitlements[tierRed].MaxPageSize
+
+	query := fmt.Sprintf("limit=%d", free+1)
+	if rec := do(t, cfg.handleGetChirps, "GET", "/api/chirps?"+query, "", nil); rec.Code != 400 {
+		t.Errorf("anonymous limit %d: status %d, want 400", free+1, rec.Code)
+	}
+	if rec := do(t, cfg.handleGetChirps, "GET", "/api/chirps?"+query, token, nil); rec.Code != 400 {
+		t.Errorf("free limit %d: status %d, want 400", free+1, rec.Code)
+	}
+
+	body := `{"event":"user.upgraded","data":{"user_id":"` + user.ID.String() + `"}}`
+	if rec := polkaRequest(cfg, body, time.Now()); rec.Code != 204 {
+		t.Fatalf("upgrade: status %d: %s", rec.Code, rec.Body)
+	}
+	listChirps(t, cfg, token, query)
+	if rec := do(t, cfg.handleGetChirps, "GET", fmt.Sprintf("/api/chirps?limit=%d", red+1), token, nil); rec.Code != 400 {
+		t.Errorf("red limit %d: status %d, want 400", red+1, rec.Code)
+	}
+}
+
+func TestGetChirpsRejectsBadPaging(t *testing.T) {
+	cfg := testConfig(t)
+	for _, query := range []string{"limit=0", "limit=abc", fmt.Sprintf("limit=%d", tierEntitlements[tierFree].MaxPageSize+1), "after=nope"} {
+		if rec := do(t, cfg.handleGetChirps, "GET", "/api/chirps?"+query, "", nil); rec.Code != 400 {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
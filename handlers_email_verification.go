@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/mailer"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const emailVerificationDuration = 24 * time.Hour
+
+// sendEmailVerification mails a token proving ownership of email. The
+// address only becomes the user's verified email once the token is
+// confirmed, so on an email change the old address keeps working until then.
+// Tokens sent earlier stop working, only the latest address can be confirmed.
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, userId uuid.UUID, email string) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	if err := qtx.InvalidateEmailVerificationTokens(ctx, userId); err != nil {
+		return err
+	}
+	_, err = qtx.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		HashedToken: auth.HashToken(token),
+		ExpiresAt:   time.Now().Add(emailVerificationDuration),
+		Email:       email,
+		UserID:      userId,
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Use this token to verify your email address, it expires in %v:\n\n%s",
+			emailVerificationDuration, token),
+	})
+}
+
+func (cfg *apiConfig) handleResendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUserById(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if user.EmailVerifiedAt.Valid {
+		responsdWithError(w, 409, "email already verified")
+		return
+	}
+	if err := cfg.sendEmailVerification(r.Context(), user.ID, user.Email); err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error sending verification email: %v", err))
+		return
+	}
+	w.WriteHeader(202)
+}
+
+func (cfg *apiConfig) handleConfirmEmailVerification(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if params.Token == "" {
+		responsdWithError(w, 400, "missing token field")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	verification, err := qtx.UseEmailVerificationToken(r.Context(), auth.HashToken(params.Token))
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 401, "Invalid or expired token")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	previous, err := qtx.GetUserById(r.Context(), verification.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	user, err := qtx.SetUserVerifiedEmail(r.Context(), database.SetUserVerifiedEmailParams{
+		Email: verification.Email,
+		ID:    verification.UserID,
+	})
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		responsdWithError(w, 409, "email already in use")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if previous.Email != user.Email {
+		cfg.notify(r.Context(), user.ID, notifyEmailChanged, struct {
+			OldEmail string `json:"old_email"`
+			NewEmail string `json:"new_email"`
+		}{
+			OldEmail: previous.Email,
+			NewEmail: user.Email,
+		})
+	}
+	respondWithJSON(w, 200, toUserSchema(user))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSignupEmailVerification(t *testing.T) {
+	cfg := testDBConfig(t)
+	mail := cfg.mailer.(*testMailer)
+	email := randomHex(t, 6) + "@example.com"
+	rec := do(t, cfg.handleCreateUser, "POST", "/api/users", "", map[string]string{
+		"email":    email,
+		"password": "correct horse battery",
+	})
+	if rec.Code != 201 {
+		t.Fatalf("signup: status %d: %s", rec.Code, rec.Body)
+	}
+	sent := mail.messages()
+	if len(sent) != 1 || sent[0].To != email {
+		t.Fatalf("sent %+v, want one mail to %s", sent, email)
+	}
+	token := mailedToken(t, sent[0])
+
+	confirm := func() int {
+		return do(t, cfg.handleConfirmEmailVerification, "POST", "/api/email-verification/confirm", "", map[string]string{"token": token}).Code
+	}
+	if code := confirm(); code != 200 {
+		t.Fatalf("confirm: status %d", code)
+	}
+	if code := confirm(); code != 401 {
+		t.Errorf("confirming twice: status %d, want 401", code)
+	}
+}
+
+func TestResendEmailVerification(t *testing.T) {
+	cfg := testDBConfig(t)
+	mail := cfg.mailer.(*testMailer)
+	_, token := createTestUser(t, cfg)
+
+	resend := func() int {
+		return do(t, cfg.handleResendEmailVerification, "POST", "/api/email-verification", token, nil).Code
+	}
+	if code := resend(); code != 202 {
+		t.Fatalf("resend: status %d, want 202", code)
+	}
+	if code := resend(); code != 202 {
+		t.Fatalf("second resend: status %d, want 202", code)
+	}
+	sent := mail.messages()
+	if len(sent) != 2 {
+		t.Fatalf("sent %d mails, want 2", len(sent))
+	}
+	confirm := func(token string) int {
+		return do(t, cfg.handleConfirmEmailVerification, "POST", "/api/email-verification/confirm", "", map[string]string{"token": token}).Code
+	}
+	if code := confirm(mailedToken(t, sent[0])); code != 401 {
+		t.Errorf("superseded token: status %d, want 401", code)
+	}
+	if code := confirm(mailedToken(t, sent[1])); code != 200 {
+		t.Fatalf("latest token: status %d, want 200", code)
+	}
+	if code := resend(); code != 409 {
+		t.Errorf("resend once verified: status %d, want 409", code)
+	}
+}
+
+func TestRequireVerifiedEmailToChirp(t *testing.T) {
+	cfg := testDBConfig(t)
+	cfg.requireVerifiedEmail = true
+	_, token := createTestUser(t, cfg)
+	rec := do(t, cfg.handleCreateChirps, "POST", "/api/chirps", token, map[string]string{"body": "hello"})
+	if rec.Code != 403 {
+		t.Errorf("unverified chirp: status %d, want 403", rec.Code)
+	}
+}
+
+func TestConfirmEmailVerificationNeedsToken(t *testing.T) {
+	cfg := testConfig(t)
+	rec := do(t, cfg.handleConfirmEmailVerification, "POST", "/api/email-verification/confirm", "", map[string]string{})
+	if rec.Code != 400 {
+		t.Errorf("status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type apiKeySchema struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  *string  `json:"expires_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+	RevokedAt  *string  `json:"revoked_at"`
+}
+
+func nullTimeString(t sql.NullTime) *string {
+	if !t.Valid {
+		return nil
+	}
+	s := t.Time.UTC().Format(time.RFC3339)
+	return &s
+}
+
+func toApiKeySchema(key database.ApiKey) apiKeySchema {
+	return apiKeySchema{
+		ID:         key.ID.String(),
+		Name:       key.Name,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt.UTC().Format(time.RFC3339),
+		ExpiresAt:  nullTimeString(key.ExpiresAt),
+		LastUsedAt: nullTimeString(key.LastUsedAt),
+		RevokedAt:  nullTimeString(key.RevokedAt),
+	}
+}
+
+func (cfg *apiConfig) handleCreateApiKey(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	// keys outlive the credential that minted them, so only a login session
+	// may create one; otherwise an OAuth client could keep access after its
+	// grant is revoked
+	if caller.Credential != credentialSession {
+		responsdWithError(w, 403, "api keys can only be created from a login session")
+		return
+	}
+	type parameters struct {
+		Name             string   `json:"name"`
+		Scopes           []string `json:"scopes"`
+		ExpiresInSeconds int      `json:"expires_in_seconds"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if params.Name == "" {
+		responsdWithError(w, 400, "missing name field")
+		return
+	}
+	if len(params.Scopes) == 0 {
+		responsdWithError(w, 400, "missing scopes field")
+		return
+	}
+	for _, scope := range params.Scopes {
+		if !auth.IsValidScope(scope) {
+			responsdWithError(w, 400, fmt.Sprintf("unknown scope %q", scope))
+			return
+		}
+		// a key can never carry more power than the credential that minted it
+		if !auth.HasScope(caller.Scopes, scope) {
+			responsdWithError(w, 403, fmt.Sprintf("cannot grant scope %q", scope))
+			return
+		}
+	}
+	if params.ExpiresInSeconds < 0 {
+		responsdWithError(w, 400, "expires_in_seconds must be positive")
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if params.ExpiresInSeconds > 0 {
+		expiresAt = sql.NullTime{
+			Time:  time.Now().Add(time.Duration(params.ExpiresInSeconds) * time.Second),
+			Valid: true,
+		}
+	}
+
+	rawKey, err := auth.MakeAPIKey()
+	if err != nil {
+		responsdWithError(w, 500, "Error creating api key")
+		return
+	}
+	key, err := cfg.dbQueries.CreateApiKey(r.Context(), database.CreateApiKeyParams{
+		Name:      params.Name,
+		HashedKey: auth.HashToken(rawKey),
+		Scopes:    params.Scopes,
+		ExpiresAt: expiresAt,
+		UserID:    caller.UserID,
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error creating api key: %v", err))
+		return
+	}
+	// the raw key is only ever shown once, we only keep its hash
+	resp := struct {
+		apiKeySchema
+		Key string `json:"key"`
+	}{
+		apiKeySchema: toApiKeySchema(key),
+		Key:          rawKey,
+	}
+	respondWithJSON(w, 201, resp)
+}
+
+func (cfg *apiConfig) handleListApiKeys(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	keys, err := cfg.dbQueries.GetApiKeysByUserId(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting api keys: %v", err))
+		return
+	}
+	resp := make([]apiKeySchema, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, toApiKeySchema(key))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handleRevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	keyId, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid key_id format")
+		return
+	}
+	result, err := cfg.dbQueries.RevokeApiKey(r.Context(), database.RevokeApiKeyParams{
+		ID:     keyId,
+		UserID: caller.UserID,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if rowsAffected == 0 {
+		responsdWithError(w, 404, "Api key not found")
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+)
+
+func TestCreateApiKey(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+
+	rec := do(t, cfg.handleCreateApiKey, "POST", "/api/users/me/keys", token, map[string]any{
+		"name":   "ci",
+		"scopes": []string{auth.ScopeChirpsWrite},
+	})
+	if rec.Code != 201 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Key    string   `json:"key"`
+		Scopes []string `json:"scopes"`
+	}
+	decodeJSON(t, rec, &resp)
+	if !auth.IsAPIKey(resp.Key) {
+		t.Fatalf("key %q is not an api key", resp.Key)
+	}
+	// only the hash is stored
+	stored, err := cfg.dbQueries.GetApiKeyByHash(context.Background(), auth.HashToken(resp.Key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.HashedKey == resp.Key || stored.UserID != user.ID {
+		t.Errorf("unexpected stored key %+v", stored)
+	}
+	p, err := cfg.authenticate(newRequest(t, "GET", "/", resp.Key, nil))
+	if err != nil || p.UserID != user.ID {
+		t.Errorf("new key doesn't authenticate: %+v, %v", p, err)
+	}
+}
+
+func TestCreateApiKeyRequiresSession(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	raw := createTestAPIKey(t, cfg, user.ID, auth.AllScopes)
+
+	rec := do(t, cfg.handleCreateApiKey, "POST", "/api/users/me/keys", raw, map[string]any{
+		"name":   "copy",
+		"scopes": []string{auth.ScopeRead},
+	})
+	if rec.Code != 403 {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestCreateApiKeyCannotWidenScopes(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	token := sessionToken(t, cfg, user.ID, []string{auth.ScopeProfileWrite})
+
+	rec := do(t, cfg.handleCreateApiKey, "POST", "/api/users/me/keys", token, map[string]any{
+		"name":   "wider",
+		"scopes": []string{auth.ScopeChirpsWrite},
+	})
+	if rec.Code != 403 {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+	rec = do(t, cfg.handleCreateApiKey, "POST", "/api/users/me/keys", token, map[string]any{
+		"name":   "bogus",
+		"scopes": []string{"admin"},
+	})
+	if rec.Code != 400 {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
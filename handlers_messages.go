@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxMessageLength            = 1000
+	maxConversationParticipants = 10
+	defaultMessagePageSize      = 50
+)
+
+// Values of users.accept_messages. The setting decides who may start a
+// conversation with a user; conversations they're already in keep working.
+const (
+	acceptMessagesEveryone = "everyone"
+	acceptMessagesNobody   = "nobody"
+)
+
+func isValidAcceptMessages(value string) bool {
+	return value == acceptMessagesEveryone || value == acceptMessagesNobody
+}
+
+type participantSchema struct {
+	UserID     string  `json:"user_id"`
+	JoinedAt   string  `json:"joined_at"`
+	LastReadAt *string `json:"last_read_at"`
+}
+
+type conversationSchema struct {
+	ID           string              `json:"id"`
+	CreatedAt    string              `json:"created_at"`
+	UpdatedAt    string              `json:"updated_at"`
+	CreatedBy    *string             `json:"created_by"`
+	Participants []participantSchema `json:"participants"`
+}
+
+type messageSchema struct {
+	ID             string `json:"id"`
+	ConversationID string `json:"conversation_id"`
+	SenderID       string `json:"sender_id"`
+	Body           string `json:"body"`
+	CreatedAt      string `json:"created_at"`
+}
+
+// nullUUIDString is nil for a creator who has since left Chirpy.
+func nullUUIDString(id uuid.NullUUID) *string {
+	if !id.Valid {
+		return nil
+	}
+	s := id.UUID.String()
+	return &s
+}
+
+// directConversationKey names the one-to-one conversation between two
+// users, the same whichever of them starts it.
+func directConversationKey(a, b uuid.UUID) string {
+	if b.String() < a.String() {
+		a, b = b, a
+	}
+	return a.String() + ":" + b.String()
+}
+
+func toConversationSchema(conversation database.Conversation, participants []database.ConversationParticipant) conversationSchema {
+	resp := conversationSchema{
+		ID:           conversation.ID.String(),
+		CreatedAt:    conversation.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:    conversation.UpdatedAt.UTC().Format(time.RFC3339),
+		CreatedBy:    nullUUIDString(conversation.CreatedBy),
+		Participants: make([]participantSchema, 0, len(participants)),
+	}
+	for _, p := range participants {
+		resp.Participants = append(resp.Participants, participantSchema{
+			UserID:     p.UserID.String(),
+			JoinedAt:   p.JoinedAt.UTC().Format(time.RFC3339),
+			LastReadAt: nullTimeString(p.LastReadAt),
+		})
+	}
+	return resp
+}
+
+func toMessageSchema(message database.Message) messageSchema {
+	return messageSchema{
+		ID:             message.ID.String(),
+		ConversationID: message.ConversationID.String(),
+		SenderID:       message.SenderID.String(),
+		Body:           message.Body,
+		CreatedAt:      message.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func validateMessageBody(body string) string {
+	if body == "" {
+		return "missing body field"
+	}
+	if utf8.RuneCountInString(body) > maxMessageLength {
+		return fmt.Sprintf("body must be at most %d characters", maxMessageLength)
+	}
+	return ""
+}
+
+// conversationForCaller resolves the {conversationID} path value and makes
+// sure the caller takes part in it. Conversations the caller isn't in are
+// reported as missing.
+func (cfg *apiConfig) conversationForCaller(w http.ResponseWriter, r *http.Request, caller principal) (uuid.UUID, bool) {
+	conversationId, err := uuid.Parse(r.PathValue("conversationID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid conversation_id format")
+		return uuid.Nil, false
+	}
+	_, err = cfg.dbQueries.GetConversationParticipant(r.Context(), database.GetConversationParticipantParams{
+		ConversationID: conversationId,
+		UserID:         caller.UserID,
+	})
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "Conversation not found")
+		return uuid.Nil, false
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return uuid.Nil, false
+	}
+	return conversationId, true
+}
+
+func (cfg *apiConfig) handleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeMessagesWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	type parameters struct {
+		ParticipantIDs []string `json:"participant_ids"`
+		Body           string   `json:"body"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if params.Body != "" {
+		if msg := validateMessageBody(params.Body); msg != "" {
+			responsdWithError(w, 400, msg)
+			return
+		}
+	}
+
+	seen := map[uuid.UUID]bool{caller.UserID: true}
+	recipients := []uuid.UUID{}
+	for _, raw := range params.ParticipantIDs {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			responsdWithError(w, 400, "Invalid participant id format")
+			return
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		recipients = append(recipients, id)
+	}
+	if len(recipients) == 0 {
+		responsdWithError(w, 400, "missing participant_ids field")
+		return
+	}
+	if len(recipients)+1 > maxConversationParticipants {
+		responsdWithError(w, 400, fmt.Sprintf("a conversation can have at most %d participants", maxConversationParticipants))
+		return
+	}
+	for _, id := range recipients {
+		user, err := cfg.dbQueries.GetUserById(r.Context(), id)
+		if err == sql.ErrNoRows || (err == nil && user.DeletedAt.Valid) {
+			responsdWithError(w, 404, "User Not Found")
+			return
+		}
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		blocked, err := cfg.dbQueries.IsBlockedEitherWay(r.Context(), database.IsBlockedEitherWayParams{
+			BlockerID: id,
+			BlockedID: caller.UserID,
+		})
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		// a block looks the same as a closed inbox from the outside
+		if blocked || user.AcceptMessages == acceptMessagesNobody {
+			responsdWithError(w, 403, "user does not accept messages")
+			return
+		}
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	status := 201
+	directKey := sql.NullString{}
+	if len(recipients) == 1 {
+		// one-to-one conversations are reused rather than duplicated
+		directKey = sql.NullString{String: directConversationKey(caller.UserID, recipients[0]), Valid: true}
+	}
+	conversation, err := qtx.CreateConversation(r.Context(), database.CreateConversationParams{
+		CreatedBy: uuid.NullUUID{UUID: caller.UserID, Valid: true},
+		DirectKey: directKey,
+	})
+	if err == sql.ErrNoRows && directKey.Valid {
+		// the insert waited out any concurrent request creating the same
+		// pair, so the conversation is committed and visible by now
+		status = 200
+		conversation, err = qtx.FindDirectConversation(r.Context(), directKey)
+	}
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error creating conversation: %v", err))
+		return
+	}
+	if status == 201 {
+		for _, id := range append([]uuid.UUID{caller.UserID}, recipients...) {
+			err := qtx.AddConversationParticipant(r.Context(), database.AddConversationParticipantParams{
+				ConversationID: conversation.ID,
+				UserID:         id,
+			})
+			if err != nil {
+				responsdWithError(w, 500, fmt.Sprintf("Error creating conversation: %v", err))
+				return
+			}
+		}
+	}
+	var message database.Message
+	if params.Body != "" {
+		message, err = cfg.postMessage(r.Context(), qtx, conversation.ID, caller.UserID, params.Body)
+		if err != nil {
+			responsdWithError(w, 500, fmt.Sprintf("Error sending message: %v", err))
+			return
+		}
+	}
+	participants, err := qtx.GetConversationParticipants(r.Context(), conversation.ID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if params.Body != "" {
+		cfg.publishMessage(r.Context(), message, participants)
+	}
+	respondWithJSON(w, status, toConversationSchema(conversation, participants))
+}
+
+// postMessage stores a message, bumps the conversation to the top of
+// everyone's list and marks it read for the sender.
+func (cfg *apiConfig) postMessage(ctx context.Context, qtx *database.Queries, conversationId, senderId uuid.UUID, body string) (database.Message, error) {
+	message, err := qtx.CreateMessage(ctx, database.CreateMessageParams{
+		ConversationID: conversationId,
+		SenderID:       senderId,
+		Body:           body,
+	})
+	if err != nil {
+		return database.Message{}, err
+	}
+	if err := qtx.TouchConversation(ctx, conversationId); err != nil {
+		return database.Message{}, err
+	}
+	err = qtx.MarkConversationRead(ctx, database.MarkConversationReadParams{
+		ReadAt:         sql.NullTime{Time: message.CreatedAt, Valid: true},
+		ConversationID: conversationId,
+		UserID:         senderId,
+	})
+	return message, err
+}
+
+// publishMessage pushes a new message to the live connections of every
+// participant except its sender.
+func (cfg *apiConfig) publishMessage(ctx context.Context, message database.Message, participants []database.ConversationParticipant) {
+	for _, p := range participants {
+		if p.UserID != message.SenderID {
+			cfg.publishUserEvent(ctx, eventMessageCreated, p.UserID, toMessageSchema(message))
+		}
+	}
+}
+
+func (cfg *apiConfig) handleListConversations(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	conversations, err := cfg.dbQueries.ListConversationsForUser(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting conversations: %v", err))
+		return
+	}
+	type conversationSummary struct {
+		ID          string  `json:"id"`
+		CreatedAt   string  `json:"created_at"`
+		UpdatedAt   string  `json:"updated_at"`
+		CreatedBy   *string `json:"created_by"`
+		UnreadCount int64   `json:"unread_count"`
+	}
+	resp := make([]conversationSummary, 0, len(conversations))
+	for _, c := range conversations {
+		resp = append(resp, conversationSummary{
+			ID:          c.ID.String(),
+			CreatedAt:   c.CreatedAt.UTC().Format(time.RFC3339),
+			UpdatedAt:   c.UpdatedAt.UTC().Format(time.RFC3339),
+			CreatedBy:   nullUUIDString(c.CreatedBy),
+			UnreadCount: c.UnreadCount,
+		})
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	conversationId, ok := cfg.conversationForCaller(w, r, caller)
+	if !ok {
+		return
+	}
+	conversation, err := cfg.dbQueries.GetConversationById(r.Context(), conversationId)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	participants, err := cfg.dbQueries.GetConversationParticipants(r.Context(), conversationId)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, 200, toConversationSchema(conversation, participants))
+}
+
+func (cfg *apiConfig) handleSendMessage(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeMessagesWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	conversationId, ok := cfg.conversationForCaller(w, r, caller)
+	if !ok {
+		return
+	}
+	type parameters struct {
+		Body string `json:"body"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if msg := validateMessageBody(params.Body); msg != "" {
+		responsdWithError(w, 400, msg)
+		return
+	}
+	blocked, err := cfg.dbQueries.HasBlockInConversation(r.Context(), database.HasBlockInConversationParams{
+		UserID:         caller.UserID,
+		ConversationID: conversationId,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if blocked {
+		responsdWithError(w, 403, "user does not accept messages")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	message, err := cfg.postMessage(r.Context(), cfg.dbQueries.WithTx(tx), conversationId, caller.UserID, params.Body)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error sending message: %v", err))
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	participants, err := cfg.dbQueries.GetConversationParticipants(r.Context(), conversationId)
+	if err != nil {
+		log.Printf("Error getting participants of %v: %v", conversationId, err)
+	}
+	cfg.publishMessage(r.Context(), message, participants)
+	respondWithJSON(w, 201, toMessageSchema(message))
+}
+
+// handleListMessages returns messages newest first. Passing the id of the
+// last message received as ?before= fetches the next page.
+func (cfg *apiConfig) handleListMessages(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	conversationId, ok := cfg.conversationForCaller(w, r, caller)
+	if !ok {
+		return
+	}
+	before := uuid.NullUUID{}
+	if raw := r.URL.Query().Get("before"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			responsdWithError(w, 400, "Invalid before format")
+			return
+		}
+		before = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	limits, err := cfg.entitlementsFor(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	limit := defaultMessagePageSize
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > limits.MaxPageSize {
+			responsdWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", limits.MaxPageSize))
+			return
+		}
+	}
+	messages, err := cfg.dbQueries.ListMessages(r.Context(), database.ListMessagesParams{
+		ConversationID: conversationId,
+		Before:         before,
+		Limit:          int32(limit),
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting messages: %v", err))
+		return
+	}
+	resp := make([]messageSchema, 0, len(messages))
+	for _, message := range messages {
+		resp = append(resp, toMessageSchema(message))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handleMarkConversationRead records a read receipt up to message_id, or
+// up to now when no message is given. Receipts never move backwards.
+func (cfg *apiConfig) handleMarkConversationRead(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeMessagesWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	conversationId, ok := cfg.conversationForCaller(w, r, caller)
+	if !ok {
+		return
+	}
+	type parameters struct {
+		MessageID string `json:"message_id"`
+	}
+	params := parameters{}
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&params); err != nil {
+			responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+			return
+		}
+	}
+	readAt := sql.NullTime{}
+	if params.MessageID != "" {
+		messageId, err := uuid.Parse(params.MessageID)
+		if err != nil {
+			responsdWithError(w, 400, "Invalid message_id format")
+			return
+		}
+		message, err := cfg.dbQueries.GetMessageById(r.Context(), database.GetMessageByIdParams{
+			ID:             messageId,
+			ConversationID: conversationId,
+		})
+		if err == sql.ErrNoRows {
+			responsdWithError(w, 404, "Message not found")
+			return
+		}
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		readAt = sql.NullTime{Time: message.CreatedAt, Valid: true}
+	}
+	err = cfg.dbQueries.MarkConversationRead(r.Context(), database.MarkConversationReadParams{
+		ReadAt:         readAt,
+		ConversationID: conversationId,
+		UserID:         caller.UserID,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	w.WriteHeader(204)
+}
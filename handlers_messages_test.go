@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDirectConversationKey(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+	if directConversationKey(a, b) != directConversationKey(b, a) {
+		t.Error("key depends on who starts the conversation")
+	}
+	if directConversationKey(a, b) == directConversationKey(a, uuid.New()) {
+		t.Error("different pairs share a key")
+	}
+}
+
+func createConversation(t *testing.T, cfg *apiConfig, token string, body string, participants ...uuid.UUID) (int, conversationSchema) {
+	t.Helper()
+	ids := make([]string, 0, len(participants))
+	for _, id := range participants {
+		ids = append(ids, id.String())
+	}
+	rec := do(t, cfg.handleCreateConversation, "POST", "/api/conversations", token, map[string]any{
+		"participant_ids": ids,
+		"body":            body,
+	})
+	var resp conversationSchema
+	if rec.Code == 200 || rec.Code == 201 {
+		decodeJSON(t, rec, &resp)
+	}
+	return rec.Code, resp
+}
+
+func TestCreateConversationReusesDirect(t *testing.T) {
+	cfg := testDBConfig(t)
+	alice, aliceToken := createTestUser(t, cfg)
+	bob, bobToken := createTestUser(t, cfg)
+	carol, _ := createTestUser(t, cfg)
+
+	code, first := createConversation(t, cfg, aliceToken, "hi", bob.ID)
+	if code != 201 {
+		t.Fatalf("status = %d, want 201", code)
+	}
+	code, second := createConversation(t, cfg, bobToken, "", alice.ID)
+	if code != 200 || second.ID != first.ID {
+		t.Errorf("status = %d, id = %s; want 200 and %s", code, second.ID, first.ID)
+	}
+	// a group with the same two people and someone else is a new one
+	code, group := createConversation(t, cfg, aliceToken, "", bob.ID, carol.ID)
+	if code != 201 || group.ID == first.ID {
+		t.Errorf("status = %d, id = %s; want a new group", code, group.ID)
+	}
+}
+
+func TestCreateConversationConcurrent(t *testing.T) {
+	cfg := testDBConfig(t)
+	_, aliceToken := createTestUser(t, cfg)
+	bob, _ := createTestUser(t, cfg)
+
+	const n = 8
+	ids := make([]string, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, resp := createConversation(t, cfg, aliceToken, "", bob.ID)
+			if code != 200 && code != 201 {
+				t.Errorf("status = %d", code)
+			}
+			ids[i] = resp.ID
+		}()
+	}
+	wg.Wait()
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("concurrent requests created different conversations: %v", ids)
+		}
+	}
+}
+
+func TestPurgedCreatorKeepsGroupConversation(t *testing.T) {
+	cfg := testDBConfig(t)
+	ctx := context.Background()
+	alice, aliceToken := createTestUser(t, cfg)
+	bob, bobToken := createTestUser(t, cfg)
+	carol, _ := createTestUser(t, cfg)
+
+	code, group := createConversation(t, cfg, aliceToken, "", bob.ID, carol.ID)
+	if code != 201 {
+		t.Fatalf("status = %d, want 201", code)
+	}
+	req := newRequest(t, "POST", "/api/conversations/"+group.ID+"/messages", bobToken, map[string]string{"body": "still here"})
+	req.SetPathValue("conversationID", group.ID)
+	if rec := serve(cfg.handleSendMessage, req); rec.Code != 201 {
+		t.Fatalf("send: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	if _, err := cfg.dbQueries.MarkUserDeleted(ctx, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	cfg.deletionGracePeriod = -time.Minute
+	if err := cfg.purgeDeletedAccountsOnce(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := cfg.dbQueries.GetConversationById(ctx, uuid.MustParse(group.ID))
+	if err != nil {
+		t.Fatalf("conversation went with its creator: %v", err)
+	}
+	if conversation.CreatedBy.Valid {
+		t.Errorf("created_by = %v, want null", conversation.CreatedBy)
+	}
+	req = newRequest(t, "GET", "/api/conversations/"+group.ID+"/messages", bobToken, nil)
+	req.SetPathValue("conversationID", group.ID)
+	rec := serve(cfg.handleListMessages, req)
+	var messages []messageSchema
+	decodeJSON(t, rec, &messages)
+	if len(messages) != 1 || messages[0].Body != "still here" {
+		t.Errorf("messages = %+v", messages)
+	}
+}
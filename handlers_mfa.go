@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	mfaChallengeDuration = 5 * time.Minute
+	recoveryCodeCount    = 10
+)
+
+func (cfg *apiConfig) userHasMFA(ctx context.Context, userId uuid.UUID) (bool, error) {
+	totp, err := cfg.dbQueries.GetUserTotp(ctx, userId)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
+func (cfg *apiConfig) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUserById(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	enabled, err := cfg.userHasMFA(r.Context(), user.ID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if enabled {
+		responsdWithError(w, 409, "two-factor authentication is already enabled")
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	_, err = cfg.dbQueries.UpsertUserTotp(r.Context(), database.UpsertUserTotpParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error enrolling totp: %v", err))
+		return
+	}
+	resp := struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI(secret, "Chirpy", user.Email),
+	}
+	respondWithJSON(w, 201, resp)
+}
+
+func (cfg *apiConfig) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	type parameters struct {
+		Code string `json:"code"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	totp, err := cfg.dbQueries.GetUserTotp(r.Context(), caller.UserID)
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "two-factor enrollment not started")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		responsdWithError(w, 409, "two-factor authentication is already enabled")
+		return
+	}
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now())
+	if !ok {
+		responsdWithError(w, 401, "Invalid code")
+		return
+	}
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	_, err = qtx.ConfirmUserTotp(r.Context(), database.ConfirmUserTotpParams{
+		LastUsedStep: step,
+		UserID:       caller.UserID,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if err := qtx.DeleteRecoveryCodes(r.Context(), caller.UserID); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	for _, code := range codes {
+		err := qtx.InsertRecoveryCode(r.Context(), database.InsertRecoveryCodeParams{
+			HashedCode: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+			UserID:     caller.UserID,
+		})
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	cfg.notify(r.Context(), caller.UserID, notifyMFAEnabled, struct{}{})
+	// recovery codes are only returned here, we keep nothing but their hashes
+	resp := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handleLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MfaToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	userId, err := auth.ValidateMFAChallengeJWT(params.MfaToken, cfg.jwtKey)
+	if err != nil {
+		responsdWithError(w, 401, err.Error())
+		return
+	}
+	accountKey := mfaThrottleKey(userId)
+	if !cfg.checkLoginThrottle(w, r, accountKey) {
+		return
+	}
+
+	switch {
+	case params.Code != "":
+		totp, err := cfg.dbQueries.GetUserTotp(r.Context(), userId)
+		if err != nil || !totp.ConfirmedAt.Valid {
+			cfg.recordLoginFailure(r.Context(), accountKey, clientIP(r))
+			responsdWithError(w, 401, "Invalid code")
+			return
+		}
+		step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now())
+		if !ok {
+			cfg.recordLoginFailure(r.Context(), accountKey, clientIP(r))
+			responsdWithError(w, 401, "Invalid code")
+			return
+		}
+		// only move forward so a code that was already used cannot be replayed
+		result, err := cfg.dbQueries.UpdateTotpLastUsedStep(r.Context(), database.UpdateTotpLastUsedStepParams{
+			LastUsedStep: step,
+			UserID:       userId,
+		})
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			responsdWithError(w, 401, "Code already used")
+			return
+		}
+	case params.RecoveryCode != "":
+		result, err := cfg.dbQueries.UseRecoveryCode(r.Context(), database.UseRecoveryCodeParams{
+			UserID:     userId,
+			HashedCode: auth.HashToken(auth.NormalizeRecoveryCode(params.RecoveryCode)),
+		})
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			cfg.recordLoginFailure(r.Context(), accountKey, clientIP(r))
+			responsdWithError(w, 401, "Invalid recovery code")
+			return
+		}
+	default:
+		responsdWithError(w, 400, "missing code or recovery_code field")
+		return
+	}
+
+	cfg.recordLoginSuccess(r.Context(), accountKey)
+
+	user, err := cfg.dbQueries.GetUserById(r.Context(), userId)
+	if err != nil {
+		responsdWithError(w, 401, "Unauthorized")
+		return
+	}
+	cfg.respondWithSession(w, r, user)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+func totpCode(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTOTPEnrollAndLogin(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+
+	rec := do(t, cfg.handleEnrollTOTP, "POST", "/api/users/me/mfa/totp", token, nil)
+	if rec.Code != 201 {
+		t.Fatalf("enroll: status %d: %s", rec.Code, rec.Body)
+	}
+	var enrolled struct {
+		Secret string `json:"secret"`
+	}
+	decodeJSON(t, rec, &enrolled)
+
+	step := auth.TOTPStep(time.Now())
+	if code := do(t, cfg.handleConfirmTOTP, "POST", "/api/users/me/mfa/totp/confirm", token, map[string]string{"code": "000000x"}).Code; code != 401 {
+		t.Errorf("confirming with a bad code: status %d, want 401", code)
+	}
+	rec = do(t, cfg.handleConfirmTOTP, "POST", "/api/users/me/mfa/totp/confirm", token, map[string]string{"code": totpCode(t, enrolled.Secret, step)})
+	if rec.Code != 200 {
+		t.Fatalf("confirm: status %d: %s", rec.Code, rec.Body)
+	}
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decodeJSON(t, rec, &confirmed)
+	if len(confirmed.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes", len(confirmed.RecoveryCodes))
+	}
+	if code := do(t, cfg.handleEnrollTOTP, "POST", "/api/users/me/mfa/totp", token, nil).Code; code != 409 {
+		t.Errorf("enrolling twice: status %d, want 409", code)
+	}
+
+	login := func() string {
+		t.Helper()
+		rec := do(t, cfg.handleUserLogin, "POST", "/api/login", "", map[string]string{
+			"email":    user.Email,
+			"password": "correct horse battery",
+		})
+		var resp struct {
+			MfaRequired bool   `json:"mfa_required"`
+			MfaToken    string `json:"mfa_token"`
+		}
+		decodeJSON(t, rec, &resp)
+		if rec.Code != 200 || !resp.MfaRequired || resp.MfaToken == "" {
+			t.Fatalf("login: status %d, %+v", rec.Code, resp)
+		}
+		return resp.MfaToken
+	}
+	second := func(mfaToken string, fields map[string]string) int {
+		t.Helper()
+		fields["mfa_token"] = mfaToken
+		return do(t, cfg.handleLoginMFA, "POST", "/api/login/mfa", "", fields).Code
+	}
+
+	mfaToken := login()
+	if code := second(mfaToken, map[string]string{"code": totpCode(t, enrolled.Secret, step)}); code != 401 {
+		t.Errorf("the code used to confirm: status %d, want 401", code)
+	}
+	next := totpCode(t, enrolled.Secret, step+1)
+	if code := second(mfaToken, map[string]string{"code": next}); code != 200 {
+		t.Errorf("fresh code: status %d, want 200", code)
+	}
+	if code := second(login(), map[string]string{"code": next}); code != 401 {
+		t.Errorf("replayed code: status %d, want 401", code)
+	}
+
+	recovery := confirmed.RecoveryCodes[0]
+	if code := second(login(), map[string]string{"recovery_code": recovery}); code != 200 {
+		t.Errorf("recovery code: status %d, want 200", code)
+	}
+	if code := second(login(), map[string]string{"recovery_code": recovery}); code != 401 {
+		t.Errorf("reused recovery code: status %d, want 401", code)
+	}
+	if code := second(login(), map[string]string{}); code != 400 {
+		t.Errorf("no code: status %d, want 400", code)
+	}
+}
+
+func TestLoginMFARejectsAccessToken(t *testing.T) {
+	cfg := testConfig(t)
+	access := sessionToken(t, cfg, uuid.New(), auth.AllScopes)
+	rec := do(t, cfg.handleLoginMFA, "POST", "/api/login/mfa", "", map[string]string{"mfa_token": access, "code": "123456"})
+	if rec.Code != 401 {
+		t.Errorf("status %d, want 401 for a token that isn't an mfa challenge", rec.Code)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const defaultNotificationPageSize = 50
+
+type notificationSchema struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt string          `json:"created_at"`
+	ReadAt    *string         `json:"read_at"`
+}
+
+func toNotificationSchema(n database.Notification) notificationSchema {
+	return notificationSchema{
+		ID:        n.ID.String(),
+		Type:      n.Type,
+		Payload:   n.Payload,
+		CreatedAt: n.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ReadAt:    nullTimeString(n.ReadAt),
+	}
+}
+
+// handleListNotifications returns notifications newest first. Passing the
+// id of the last one received as ?before= fetches the next page and
+// ?unread=true leaves out the ones already read.
+func (cfg *apiConfig) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	query := r.URL.Query()
+	before := uuid.NullUUID{}
+	if raw := query.Get("before"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			responsdWithError(w, 400, "Invalid before format")
+			return
+		}
+		before = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	limits, err := cfg.entitlementsFor(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	limit := defaultNotificationPageSize
+	if raw := query.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > limits.MaxPageSize {
+			responsdWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", limits.MaxPageSize))
+			return
+		}
+	}
+	unreadOnly := false
+	if raw := query.Get("unread"); raw != "" {
+		unreadOnly, err = strconv.ParseBool(raw)
+		if err != nil {
+			responsdWithError(w, 400, "unread must be true or false")
+			return
+		}
+	}
+	notifications, err := cfg.dbQueries.ListNotifications(r.Context(), database.ListNotificationsParams{
+		UserID:     caller.UserID,
+		UnreadOnly: unreadOnly,
+		Before:     before,
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting notifications: %v", err))
+		return
+	}
+	resp := make([]notificationSchema, 0, len(notifications))
+	for _, n := range notifications {
+		resp = append(resp, toNotificationSchema(n))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handleMarkNotificationRead(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	notificationId, err := uuid.Parse(r.PathValue("notificationID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid notification_id format")
+		return
+	}
+	result, err := cfg.dbQueries.MarkNotificationRead(r.Context(), database.MarkNotificationReadParams{
+		ID:     notificationId,
+		UserID: caller.UserID,
+	})
+	respondAffected(w, result, err, "Notification not found")
+}
+
+func (cfg *apiConfig) handleMarkAllNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	count, err := cfg.dbQueries.MarkAllNotificationsRead(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, 200, struct {
+		Marked int64 `json:"marked"`
+	}{Marked: count})
+}
+
+// handleGetNotificationPreferences lists every notification type with
+// whether the caller receives it. Types are on until turned off.
+func (cfg *apiConfig) handleGetNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	prefs, err := cfg.dbQueries.GetNotificationPreferences(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	resp := make(map[string]bool, len(notificationTypes))
+	for _, kind := range notificationTypes {
+		resp[kind] = true
+	}
+	for _, pref := range prefs {
+		if isValidNotificationType(pref.Type) && !isMandatoryNotificationType(pref.Type) {
+			resp[pref.Type] = pref.Enabled
+		}
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handleUpdateNotificationPreferences takes a {"type": enabled} object.
+// Types left out keep their current setting. Mandatory types can't be
+// turned off.
+func (cfg *apiConfig) handleUpdateNotificationPreferences(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	params := map[string]bool{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	for kind := range params {
+		if !isValidNotificationType(kind) {
+			responsdWithError(w, 400, fmt.Sprintf("unknown notification type %q", kind))
+			return
+		}
+		if !params[kind] && isMandatoryNotificationType(kind) {
+			responsdWithError(w, 400, fmt.Sprintf("%q notifications can't be turned off", kind))
+			return
+		}
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	for kind, enabled := range params {
+		err := qtx.SetNotificationPreference(r.Context(), database.SetNotificationPreferenceParams{
+			UserID:  caller.UserID,
+			Type:    kind,
+			Enabled: enabled,
+		})
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	cfg.handleGetNotificationPreferences(w, r)
+}
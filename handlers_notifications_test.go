@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func listNotifications(t *testing.T, cfg *apiConfig, token, query string) []notificationSchema {
+	t.Helper()
+	rec := do(t, cfg.handleListNotifications, "GET", "/api/notifications"+query, token, nil)
+	if rec.Code != 200 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp []notificationSchema
+	decodeJSON(t, rec, &resp)
+	return resp
+}
+
+func TestNotificationPreferencesFilter(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+
+	rec := do(t, cfg.handleUpdateNotificationPreferences, "PUT", "/api/notifications/preferences", token, map[string]bool{
+		notifyChirpDeleted: false,
+	})
+	if rec.Code != 200 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	var prefs map[string]bool
+	decodeJSON(t, rec, &prefs)
+	if prefs[notifyChirpDeleted] || !prefs[notifyPasswordChanged] || len(prefs) != len(notificationTypes) {
+		t.Errorf("preferences = %v", prefs)
+	}
+	if rec := do(t, cfg.handleUpdateNotificationPreferences, "PUT", "/api/notifications/preferences", token, map[string]bool{"bogus": true}); rec.Code != 400 {
+		t.Errorf("unknown type: status = %d, want 400", rec.Code)
+	}
+
+	cfg.notify(context.Background(), user.ID, notifyChirpDeleted, struct{}{})
+	cfg.notify(context.Background(), user.ID, notifyPasswordChanged, struct{}{})
+	got := listNotifications(t, cfg, token, "")
+	if len(got) != 1 || got[0].Type != notifyPasswordChanged {
+		t.Errorf("notifications = %+v, want only %s", got, notifyPasswordChanged)
+	}
+}
+
+func TestSecurityNotificationsAreMandatory(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+
+	for _, kind := range mandatoryNotificationTypes {
+		rec := do(t, cfg.handleUpdateNotificationPreferences, "PUT", "/api/notifications/preferences", token, map[string]bool{kind: false})
+		if rec.Code != 400 {
+			t.Errorf("turning off %s: status = %d, want 400", kind, rec.Code)
+		}
+	}
+
+	// a preference saved before new_login became mandatory
+	err := cfg.dbQueries.SetNotificationPreference(context.Background(), database.SetNotificationPreferenceParams{
+		UserID:  user.ID,
+		Type:    notifyNewLogin,
+		Enabled: false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := do(t, cfg.handleGetNotificationPreferences, "GET", "/api/notifications/preferences", token, nil)
+	var prefs map[string]bool
+	decodeJSON(t, rec, &prefs)
+	if !prefs[notifyNewLogin] {
+		t.Errorf("preferences = %v, want %s shown as on", prefs, notifyNewLogin)
+	}
+	cfg.notify(context.Background(), user.ID, notifyNewLogin, struct{}{})
+	if got := listNotifications(t, cfg, token, ""); len(got) != 1 || got[0].Type != notifyNewLogin {
+		t.Errorf("notifications = %+v, want the %s one", got, notifyNewLogin)
+	}
+}
+
+func TestListNotificationsPagination(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	other, otherToken := createTestUser(t, cfg)
+	for i := range 5 {
+		cfg.notify(context.Background(), user.ID, notifyChirpDeleted, map[string]int{"n": i})
+	}
+	cfg.notify(context.Background(), other.ID, notifyChirpDeleted, struct{}{})
+
+	var all []notificationSchema
+	query := "?limit=2"
+	for {
+		page := listNotifications(t, cfg, token, query)
+		if len(page) == 0 {
+			break
+		}
+		if len(page) > 2 {
+			t.Fatalf("page of %d, want at most 2", len(page))
+		}
+		all = append(all, page...)
+		query = "?limit=2&before=" + page[len(page)-1].ID
+	}
+	if len(all) != 5 {
+		t.Fatalf("got %d notifications across pages, want 5", len(all))
+	}
+	for i, n := range all {
+		if want := fmt.Sprintf(`{"n": %d}`, 4-i); string(n.Payload) != want {
+			t.Errorf("notification %d payload = %s, want %s", i, n.Payload, want)
+		}
+	}
+
+	// a cursor from someone else's list doesn't page through it
+	if got := listNotifications(t, cfg, otherToken, "?before="+all[0].ID); len(got) != 0 {
+		t.Errorf("foreign cursor returned %+v", got)
+	}
+	if rec := do(t, cfg.handleListNotifications, "GET", "/api/notifications?limit=100000", token, nil); rec.Code != 400 {
+		t.Errorf("oversized limit: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestMarkNotificationRead(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	_, otherToken := createTestUser(t, cfg)
+	for range 3 {
+		cfg.notify(context.Background(), user.ID, notifyChirpDeleted, struct{}{})
+	}
+	all := listNotifications(t, cfg, token, "")
+
+	markRead := func(token, id string) int {
+		req := newRequest(t, "POST", "/api/notifications/"+id+"/read", token, nil)
+		req.SetPathValue("notificationID", id)
+		return serve(cfg.handleMarkNotificationRead, req).Code
+	}
+	if code := markRead(otherToken, all[0].ID); code != 404 {
+		t.Errorf("someone else's notification: status = %d, want 404", code)
+	}
+	if code := markRead(token, uuid.NewString()); code != 404 {
+		t.Errorf("unknown notification: status = %d, want 404", code)
+	}
+	if code := markRead(token, all[0].ID); code != 204 {
+		t.Fatalf("status = %d, want 204", code)
+	}
+	unread := listNotifications(t, cfg, token, "?unread=true")
+	if len(unread) != 2 {
+		t.Fatalf("unread = %+v, want 2", unread)
+	}
+	for _, n := range unread {
+		if n.ID == all[0].ID {
+			t.Error("notification marked read is still listed as unread")
+		}
+	}
+
+	rec := do(t, cfg.handleMarkAllNotificationsRead, "POST", "/api/notifications/read", token, nil)
+	var resp struct {
+		Marked int64 `json:"marked"`
+	}
+	decodeJSON(t, rec, &resp)
+	if resp.Marked != 2 {
+		t.Errorf("marked = %d, want 2", resp.Marked)
+	}
+	if unread := listNotifications(t, cfg, token, "?unread=true"); len(unread) != 0 {
+		t.Errorf("unread after marking all = %+v", unread)
+	}
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	oauthTokenAccess  = "access"
+	oauthTokenRefresh = "refresh"
+
+	oauthCodeDuration         = 5 * time.Minute
+	oauthAccessTokenDuration  = time.Hour
+	oauthRefreshTokenDuration = 30 * 24 * time.Hour
+)
+
+// respondWithOAuthError writes an RFC 6749 section 5.2 error response.
+func respondWithOAuthError(w http.ResponseWriter, code int, errCode, description string) {
+	type errResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, code, errResponse{
+		Error:            errCode,
+		ErrorDescription: description,
+	})
+}
+
+// isValidRedirectURI only allows https, and plain http back to the user's
+// own machine for native apps (RFC 8252). Anything else, such as
+// javascript: or data:, would run the code in the page that follows the
+// redirect.
+func isValidRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" || u.Fragment != "" || u.User != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		if u.Hostname() == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(u.Hostname())
+		return ip != nil && ip.IsLoopback()
+	}
+	return false
+}
+
+func (cfg *apiConfig) handleCreateOAuthClient(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		// Public clients such as mobile apps can't keep a secret and rely
+		// on PKCE alone.
+		Public bool `json:"public"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if params.Name == "" {
+		responsdWithError(w, 400, "missing name field")
+		return
+	}
+	if len(params.RedirectURIs) == 0 {
+		responsdWithError(w, 400, "missing redirect_uris field")
+		return
+	}
+	for _, uri := range params.RedirectURIs {
+		if !isValidRedirectURI(uri) {
+			responsdWithError(w, 400, fmt.Sprintf("invalid redirect uri %q, must be https or http on a loopback address", uri))
+			return
+		}
+	}
+	if len(params.Scopes) == 0 {
+		params.Scopes = auth.AllScopes
+	}
+	for _, scope := range params.Scopes {
+		if !auth.IsValidScope(scope) {
+			responsdWithError(w, 400, fmt.Sprintf("unknown scope %q", scope))
+			return
+		}
+	}
+
+	clientId, secret, err := auth.MakeOAuthClientCredentials()
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	hashedSecret := sql.NullString{}
+	if !params.Public {
+		hashedSecret = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+	client, err := cfg.dbQueries.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		ClientID:     clientId,
+		HashedSecret: hashedSecret,
+		Name:         params.Name,
+		RedirectUris: params.RedirectURIs,
+		Scopes:       params.Scopes,
+		OwnerID:      caller.UserID,
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error creating client: %v", err))
+		return
+	}
+	resp := struct {
+		ClientID     string   `json:"client_id"`
+		ClientSecret string   `json:"client_secret,omitempty"`
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+	}{
+		ClientID:     client.ClientID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Scopes:       client.Scopes,
+	}
+	if !params.Public {
+		resp.ClientSecret = secret
+	}
+	respondWithJSON(w, 201, resp)
+}
+
+type authorizationRequest struct {
+	client        database.OauthClient
+	redirectURI   string
+	scopes        []string
+	state         string
+	codeChallenge string
+}
+
+// parseAuthorizationRequest validates the query of an authorization
+// request. Errors about the client or redirect URI must not be redirected
+// back since the redirect target itself can't be trusted.
+func (cfg *apiConfig) parseAuthorizationRequest(r *http.Request, q url.Values) (authorizationRequest, error) {
+	req := authorizationRequest{}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), q.Get("client_id"))
+	if err != nil {
+		return req, fmt.Errorf("unknown client_id")
+	}
+	redirectURI := q.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectUris) == 1 {
+		redirectURI = client.RedirectUris[0]
+	}
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		return req, fmt.Errorf("redirect_uri is not registered for this client")
+	}
+	if q.Get("response_type") != "code" {
+		return req, fmt.Errorf("response_type must be code")
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		return req, fmt.Errorf("PKCE with code_challenge_method S256 is required")
+	}
+	scopes := strings.Fields(q.Get("scope"))
+	if len(scopes) == 0 {
+		scopes = client.Scopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return req, fmt.Errorf("scope %q is not allowed for this client", scope)
+		}
+	}
+	req.client = client
+	req.redirectURI = redirectURI
+	req.scopes = scopes
+	req.state = q.Get("state")
+	req.codeChallenge = q.Get("code_challenge")
+	return req, nil
+}
+
+// handleOAuthAuthorize returns what the client is asking for so the user
+// can be shown a consent screen.
+func (cfg *apiConfig) handleOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	if _, err := cfg.authorize(r, auth.ScopeProfileWrite); err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	req, err := cfg.parseAuthorizationRequest(r, r.URL.Query())
+	if err != nil {
+		responsdWithError(w, 400, err.Error())
+		return
+	}
+	resp := struct {
+		ClientID    string   `json:"client_id"`
+		ClientName  string   `json:"client_name"`
+		RedirectURI string   `json:"redirect_uri"`
+		Scopes      []string `json:"scopes"`
+	}{
+		ClientID:    req.client.ClientID,
+		ClientName:  req.client.Name,
+		RedirectURI: req.redirectURI,
+		Scopes:      req.scopes,
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handleOAuthConsent records the user's decision and hands back the URL to
+// redirect the browser to, carrying either a code or an access_denied error.
+func (cfg *apiConfig) handleOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	// only a first-party session may grant consent, otherwise a client
+	// could approve its own requests with a token it already holds
+	if caller.Credential != credentialSession {
+		responsdWithError(w, 403, "consent must be given from a logged in session")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		responsdWithError(w, 400, err.Error())
+		return
+	}
+	req, err := cfg.parseAuthorizationRequest(r, r.Form)
+	if err != nil {
+		responsdWithError(w, 400, err.Error())
+		return
+	}
+	redirect, err := url.Parse(req.redirectURI)
+	if err != nil {
+		responsdWithError(w, 400, "invalid redirect_uri")
+		return
+	}
+	v := redirect.Query()
+	if req.state != "" {
+		v.Set("state", req.state)
+	}
+
+	if r.Form.Get("approve") != "true" {
+		v.Set("error", "access_denied")
+	} else {
+		code, err := auth.MakeOAuthCode()
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		err = cfg.dbQueries.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+			HashedCode:    auth.HashToken(code),
+			ExpiresAt:     time.Now().Add(oauthCodeDuration),
+			ClientID:      req.client.ClientID,
+			RedirectUri:   req.redirectURI,
+			Scopes:        req.scopes,
+			CodeChallenge: req.codeChallenge,
+			UserID:        caller.UserID,
+		})
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		v.Set("code", code)
+	}
+	redirect.RawQuery = v.Encode()
+	resp := struct {
+		RedirectTo string `json:"redirect_to"`
+	}{
+		RedirectTo: redirect.String(),
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// authenticateOAuthClient checks client credentials sent either with HTTP
+// basic auth or in the form body. Public clients only send client_id.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, bool) {
+	clientId, secret, ok := r.BasicAuth()
+	if ok {
+		clientId, _ = url.QueryUnescape(clientId)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientId = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientId)
+	if err != nil {
+		return database.OauthClient{}, false
+	}
+	if client.HashedSecret.Valid &&
+		subtle.ConstantTimeCompare([]byte(auth.HashToken(secret)), []byte(client.HashedSecret.String)) != 1 {
+		return database.OauthClient{}, false
+	}
+	return client, true
+}
+
+func (cfg *apiConfig) handleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", err.Error())
+		return
+	}
+	client, ok := cfg.authenticateOAuthClient(r)
+	if !ok {
+		respondWithOAuthError(w, 401, "invalid_client", "")
+		return
+	}
+
+	// the refresh token being rotated and the new pair commit together, so
+	// a failure leaves the old token usable and a replay can't mint twice
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	var userId uuid.UUID
+	var scopes []string
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		// the code is used up outside the transaction, a failed exchange
+		// must not leave it redeemable
+		code, err := cfg.dbQueries.UseOAuthAuthorizationCode(r.Context(), auth.HashToken(r.PostForm.Get("code")))
+		if err != nil {
+			respondWithOAuthError(w, 400, "invalid_grant", "invalid or expired code")
+			return
+		}
+		if code.ClientID != client.ClientID || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+			respondWithOAuthError(w, 400, "invalid_grant", "code was not issued to this client")
+			return
+		}
+		if !auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge) {
+			respondWithOAuthError(w, 400, "invalid_grant", "code_verifier does not match")
+			return
+		}
+		userId, scopes = code.UserID, code.Scopes
+	case "refresh_token":
+		// refresh tokens are rotated on every use
+		refresh, err := qtx.UseOAuthRefreshToken(r.Context(), database.UseOAuthRefreshTokenParams{
+			HashedToken: auth.HashToken(r.PostForm.Get("refresh_token")),
+			ClientID:    client.ClientID,
+		})
+		if err == sql.ErrNoRows {
+			respondWithOAuthError(w, 400, "invalid_grant", "invalid refresh token")
+			return
+		}
+		if err != nil {
+			respondWithOAuthError(w, 500, "server_error", "")
+			return
+		}
+		scopes = refresh.Scopes
+		if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+			for _, scope := range requested {
+				if !slices.Contains(refresh.Scopes, scope) {
+					respondWithOAuthError(w, 400, "invalid_scope", "")
+					return
+				}
+			}
+			scopes = requested
+		}
+		userId = refresh.UserID
+	default:
+		respondWithOAuthError(w, 400, "unsupported_grant_type", "")
+		return
+	}
+
+	accessToken, err := auth.MakeOAuthAccessToken()
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	refreshToken, err := auth.MakeOAuthRefreshToken()
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	for _, t := range []struct {
+		token    string
+		kind     string
+		duration time.Duration
+	}{
+		{accessToken, oauthTokenAccess, oauthAccessTokenDuration},
+		{refreshToken, oauthTokenRefresh, oauthRefreshTokenDuration},
+	} {
+		err := qtx.CreateOAuthToken(r.Context(), database.CreateOAuthTokenParams{
+			HashedToken: auth.HashToken(t.token),
+			Kind:        t.kind,
+			ExpiresAt:   time.Now().Add(t.duration),
+			ClientID:    client.ClientID,
+			Scopes:      scopes,
+			UserID:      userId,
+		})
+		if err != nil {
+			respondWithOAuthError(w, 500, "server_error", "")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		respondWithOAuthError(w, 500, "server_error", "")
+		return
+	}
+	resp := struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenDuration.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJSON(w, 200, resp)
+}
+
+// handleOAuthIntrospect implements RFC 7662. Clients may only introspect
+// tokens that were issued to them.
+func (cfg *apiConfig) handleOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", err.Error())
+		return
+	}
+	client, ok := cfg.authenticateOAuthClient(r)
+	if !ok || !client.HashedSecret.Valid {
+		respondWithOAuthError(w, 401, "invalid_client", "")
+		return
+	}
+	type introspection struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Sub       string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		Exp       int64  `json:"exp,omitempty"`
+		Iat       int64  `json:"iat,omitempty"`
+	}
+	token, err := cfg.dbQueries.GetOAuthToken(r.Context(), auth.HashToken(r.PostForm.Get("token")))
+	if err != nil || token.ClientID != client.ClientID || token.RevokedAt.Valid || time.Now().After(token.ExpiresAt) {
+		respondWithJSON(w, 200, introspection{Active: false})
+		return
+	}
+	tokenType := "Bearer"
+	if token.Kind == oauthTokenRefresh {
+		tokenType = "refresh_token"
+	}
+	respondWithJSON(w, 200, introspection{
+		Active:    true,
+		Scope:     strings.Join(token.Scopes, " "),
+		ClientID:  token.ClientID,
+		Sub:       token.UserID.String(),
+		TokenType: tokenType,
+		Exp:       token.ExpiresAt.Unix(),
+		Iat:       token.CreatedAt.Unix(),
+	})
+}
+
+// handleOAuthRevoke implements RFC 7009. Unknown tokens still get a 200 so
+// the response reveals nothing about them.
+func (cfg *apiConfig) handleOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request", err.Error())
+		return
+	}
+	client, ok := cfg.authenticateOAuthClient(r)
+	if !ok {
+		respondWithOAuthError(w, 401, "invalid_client", "")
+		return
+	}
+	err := cfg.dbQueries.RevokeOAuthToken(r.Context(), database.RevokeOAuthTokenParams{
+		HashedToken: auth.HashToken(r.PostForm.Get("token")),
+		ClientID:    client.ClientID,
+	})
+	if err != nil {
+		respondWithOAuthError(w, 503, "temporarily_unavailable", "")
+		return
+	}
+	w.WriteHeader(200)
+}
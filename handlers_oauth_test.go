@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+)
+
+func TestIsValidRedirectURI(t *testing.T) {
+	tests := map[string]bool{
+		"https://app.example.com/callback":  true,
+		"http://localhost:3000/callback":    true,
+		"http://127.0.0.1:8000/cb":          true,
+		"http://[::1]/cb":                   true,
+		"http://app.example.com/callback":   false,
+		"javascript:alert(document.cookie)": false,
+		"data:text/html,<script>x</script>": false,
+		"https://app.example.com/cb#frag":   false,
+		"https://user:pw@app.example.com/":  false,
+		"/relative/callback":                false,
+		"com.example.app:/oauth":            false,
+	}
+	for uri, want := range tests {
+		if got := isValidRedirectURI(uri); got != want {
+			t.Errorf("isValidRedirectURI(%q) = %v, want %v", uri, got, want)
+		}
+	}
+}
+
+type oauthTestClient struct {
+	ID     string `json:"client_id"`
+	Secret string `json:"client_secret"`
+}
+
+const testRedirectURI = "https://app.example.com/callback"
+
+func createTestOAuthClient(t *testing.T, cfg *apiConfig, token string) oauthTestClient {
+	t.Helper()
+	rec := do(t, cfg.handleCreateOAuthClient, "POST", "/api/oauth/clients", token, map[string]any{
+		"name":          "test app",
+		"redirect_uris": []string{testRedirectURI},
+		"scopes":        []string{auth.ScopeRead, auth.ScopeChirpsWrite},
+	})
+	if rec.Code != 201 {
+		t.Fatalf("create client: status = %d: %s", rec.Code, rec.Body)
+	}
+	var client oauthTestClient
+	decodeJSON(t, rec, &client)
+	return client
+}
+
+func pkcePair() (verifier, challenge string) {
+	verifier = "a-verifier-that-is-long-enough-for-the-tests-0123456789"
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// consent approves or denies an authorization request and returns the
+// query of the redirect.
+func consent(t *testing.T, cfg *apiConfig, token string, client oauthTestClient, approve bool) url.Values {
+	t.Helper()
+	_, challenge := pkcePair()
+	q := url.Values{
+		"client_id":             {client.ID},
+		"redirect_uri":          {testRedirectURI},
+		"response_type":         {"code"},
+		"scope":                 {auth.ScopeRead},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	if approve {
+		q.Set("approve", "true")
+	}
+	rec := do(t, cfg.handleOAuthConsent, "POST", "/oauth/authorize?"+q.Encode(), token, nil)
+	if rec.Code != 200 {
+		t.Fatalf("consent: status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		RedirectTo string `json:"redirect_to"`
+	}
+	decodeJSON(t, rec, &resp)
+	u, err := url.Parse(resp.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.RedirectTo, testRedirectURI) || u.Query().Get("state") != "xyz" {
+		t.Fatalf("unexpected redirect %q", resp.RedirectTo)
+	}
+	return u.Query()
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	Error        string `json:"error"`
+}
+
+// oauthPost calls an OAuth endpoint with a form body and the client's
+// credentials as basic auth.
+func oauthPost(t *testing.T, cfg *apiConfig, target string, client oauthTestClient, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(client.ID), url.QueryEscape(client.Secret))
+	handler := cfg.handleOAuthToken
+	switch target {
+	case "/oauth/introspect":
+		handler = cfg.handleOAuthIntrospect
+	case "/oauth/revoke":
+		handler = cfg.handleOAuthRevoke
+	}
+	return serve(handler, req)
+}
+
+func exchange(t *testing.T, cfg *apiConfig, client oauthTestClient, form url.Values) (int, tokenResponse) {
+	t.Helper()
+	rec := oauthPost(t, cfg, "/oauth/token", client, form)
+	var resp tokenResponse
+	decodeJSON(t, rec, &resp)
+	return rec.Code, resp
+}
+
+func TestOAuthConsent(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	client := createTestOAuthClient(t, cfg, token)
+
+	if q := consent(t, cfg, token, client, false); q.Get("error") != "access_denied" || q.Get("code") != "" {
+		t.Errorf("denied consent redirect = %v", q)
+	}
+	if q := consent(t, cfg, token, client, true); q.Get("code") == "" || q.Get("error") != "" {
+		t.Errorf("approved consent redirect = %v", q)
+	}
+
+	// a token the client already holds can't approve more requests
+	apiKey := createTestAPIKey(t, cfg, user.ID, auth.AllScopes)
+	rec := do(t, cfg.handleOAuthConsent, "POST", "/oauth/authorize?client_id="+client.ID+"&approve=true", apiKey, nil)
+	if rec.Code != 403 {
+		t.Errorf("consent with an api key: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestOAuthCreateClientRejectsUnsafeRedirect(t *testing.T) {
+	cfg := testDBConfig(t)
+	_, token := createTestUser(t, cfg)
+	rec := do(t, cfg.handleCreateOAuthClient, "POST", "/api/oauth/clients", token, map[string]any{
+		"name":          "evil",
+		"redirect_uris": []string{"javascript:alert(1)"},
+	})
+	if rec.Code != 400 {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestOAuthTokenExchange(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	client := createTestOAuthClient(t, cfg, token)
+	verifier, _ := pkcePair()
+	codeForm := func(code, verifier string) url.Values {
+		return url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {testRedirectURI},
+			"code_verifier": {verifier},
+		}
+	}
+
+	// a wrong verifier fails and burns the code
+	code := consent(t, cfg, token, client, true).Get("code")
+	if status, resp := exchange(t, cfg, client, codeForm(code, "wrong")); status != 400 || resp.Error != "invalid_grant" {
+		t.Errorf("wrong verifier: %d %+v", status, resp)
+	}
+	if status, _ := exchange(t, cfg, client, codeForm(code, verifier)); status != 400 {
+		t.Errorf("reused code: status = %d, want 400", status)
+	}
+
+	code = consent(t, cfg, token, client, true).Get("code")
+	status, tokens := exchange(t, cfg, client, codeForm(code, verifier))
+	if status != 200 || tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.Scope != auth.ScopeRead {
+		t.Fatalf("exchange: %d %+v", status, tokens)
+	}
+	p, err := cfg.authenticate(newRequest(t, "GET", "/", tokens.AccessToken, nil))
+	if err != nil || p.UserID != user.ID || p.Credential != credentialOAuth {
+		t.Fatalf("access token principal = %+v, %v", p, err)
+	}
+
+	other := createTestOAuthClient(t, cfg, token)
+	if status, _ := exchange(t, cfg, other, url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}); status != 400 {
+		t.Errorf("refresh by another client: status = %d, want 400", status)
+	}
+
+	refreshForm := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokens.RefreshToken}}
+	status, rotated := exchange(t, cfg, client, refreshForm)
+	if status != 200 || rotated.RefreshToken == tokens.RefreshToken {
+		t.Fatalf("refresh: %d %+v", status, rotated)
+	}
+	if status, _ := exchange(t, cfg, client, refreshForm); status != 400 {
+		t.Errorf("replayed refresh token: status = %d, want 400", status)
+	}
+}
+
+func TestOAuthIntrospect(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	client := createTestOAuthClient(t, cfg, token)
+	other := createTestOAuthClient(t, cfg, token)
+	verifier, _ := pkcePair()
+	code := consent(t, cfg, token, client, true).Get("code")
+	_, tokens := exchange(t, cfg, client, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {verifier},
+	})
+
+	introspect := func(c oauthTestClient, tok string) map[string]any {
+		t.Helper()
+		rec := oauthPost(t, cfg, "/oauth/introspect", c, url.Values{"token": {tok}})
+		if rec.Code != 200 {
+			t.Fatalf("introspect: status = %d: %s", rec.Code, rec.Body)
+		}
+		var resp map[string]any
+		decodeJSON(t, rec, &resp)
+		return resp
+	}
+
+	resp := introspect(client, tokens.AccessToken)
+	if resp["active"] != true || resp["sub"] != user.ID.String() || resp["scope"] != auth.ScopeRead || resp["token_type"] != "Bearer" {
+		t.Errorf("introspect access token = %v", resp)
+	}
+	if resp := introspect(other, tokens.AccessToken); resp["active"] != false || len(resp) != 1 {
+		t.Errorf("another client's token = %v", resp)
+	}
+	if resp := introspect(client, "chirpy_at_unknown"); resp["active"] != false {
+		t.Errorf("unknown token = %v", resp)
+	}
+
+	if rec := oauthPost(t, cfg, "/oauth/revoke", client, url.Values{"token": {tokens.AccessToken}}); rec.Code != 200 {
+		t.Fatalf("revoke: status = %d", rec.Code)
+	}
+	if resp := introspect(client, tokens.AccessToken); resp["active"] != false {
+		t.Errorf("revoked token = %v", resp)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/oidc"
+)
+
+const oidcLoginStateDuration = 10 * time.Minute
+
+// errIdentityConflict means an account already uses the identity's email
+// and it isn't safe to link the two.
+var errIdentityConflict = errors.New("an account with this email already exists")
+
+func (cfg *apiConfig) handleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := oidc.RandomString(32)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	nonce, err := oidc.RandomString(32)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	err = cfg.dbQueries.CreateOidcLoginState(r.Context(), database.CreateOidcLoginStateParams{
+		State:        state,
+		ExpiresAt:    time.Now().Add(oidcLoginStateDuration),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	authURL, err := cfg.oidcClient.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		responsdWithError(w, 502, fmt.Sprintf("Error contacting identity provider: %v", err))
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	code, state, err := oidc.CallbackParams(r.URL.Query())
+	if err != nil {
+		responsdWithError(w, 400, err.Error())
+		return
+	}
+	loginState, err := cfg.dbQueries.ConsumeOidcLoginState(r.Context(), state)
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 400, "Invalid or expired login state")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	idToken, err := cfg.oidcClient.Exchange(r.Context(), code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		responsdWithError(w, 401, err.Error())
+		return
+	}
+	user, err := cfg.userForIdentity(r.Context(), idToken)
+	if errors.Is(err, errIdentityConflict) {
+		responsdWithError(w, 409, err.Error())
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error linking identity: %v", err))
+		return
+	}
+	cfg.completeLogin(w, r, user)
+}
+
+// userForIdentity finds the user linked to an external identity, linking
+// it to an existing account with the same email when both the provider and
+// Chirpy have verified the address, and creating a new account otherwise.
+// An unverified local account may have been registered by someone squatting
+// on the address, whose password would keep working next to the real
+// owner's login, so it is never linked.
+func (cfg *apiConfig) userForIdentity(ctx context.Context, idToken oidc.IDToken) (database.User, error) {
+	identity, err := cfg.dbQueries.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: cfg.oidcProvider,
+		Subject:  idToken.Subject,
+	})
+	if err == nil {
+		return cfg.dbQueries.GetUserById(ctx, identity.UserID)
+	}
+	if err != sql.ErrNoRows {
+		return database.User{}, err
+	}
+	if idToken.Email == "" {
+		return database.User{}, fmt.Errorf("identity provider did not return an email")
+	}
+
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.User{}, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	user, err := qtx.GetUserByEmail(ctx, idToken.Email)
+	if err == sql.ErrNoRows {
+		// the account has no usable password until the user sets one
+		// through the password reset flow
+		randomPassword, err := auth.MakeRefreshToken()
+		if err != nil {
+			return database.User{}, err
+		}
+		hash, err := auth.HashPassword(randomPassword)
+		if err != nil {
+			return database.User{}, err
+		}
+		user, err = qtx.CreateUser(ctx, database.CreateUserParams{
+			Email:          idToken.Email,
+			HashedPassword: hash,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+	} else if err != nil {
+		return database.User{}, err
+	} else if !idToken.EmailVerified {
+		return database.User{}, errIdentityConflict
+	} else if !user.EmailVerifiedAt.Valid {
+		return database.User{}, fmt.Errorf("%w, log in with its password and verify the address before linking", errIdentityConflict)
+	}
+	if idToken.EmailVerified && !user.EmailVerifiedAt.Valid {
+		user, err = qtx.SetUserVerifiedEmail(ctx, database.SetUserVerifiedEmailParams{
+			Email: user.Email,
+			ID:    user.ID,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+	}
+	_, err = qtx.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: cfg.oidcProvider,
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+		UserID:   user.ID,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return database.User{}, err
+	}
+	log.Printf("Linked %s identity %s to user %s", cfg.oidcProvider, idToken.Subject, user.ID)
+	return user, nil
+}
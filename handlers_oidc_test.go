@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/oidc"
+	"github.com/Moee1149/chirpy/internal/oidc/oidctest"
+)
+
+// oidcLogin runs handleOIDCLogin, lets the provider authorize the request
+// and returns the response of the callback.
+func oidcLogin(t *testing.T, cfg *apiConfig) *http.Response {
+	t.Helper()
+	rec := do(t, cfg.handleOIDCLogin, "GET", "/api/auth/oidc/login", "", nil)
+	if rec.Code != 302 {
+		t.Fatalf("login status = %d: %s", rec.Code, rec.Body)
+	}
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback := do(t, cfg.handleOIDCCallback, "GET", "/api/auth/oidc/callback?"+location.RawQuery, "", nil)
+	return callback.Result()
+}
+
+func testOIDCConfig(t *testing.T) (*apiConfig, *oidctest.Provider) {
+	t.Helper()
+	cfg := testDBConfig(t)
+	provider := oidctest.NewProvider("chirpy", "secret")
+	t.Cleanup(provider.Server.Close)
+	cfg.oidcProvider = "test"
+	cfg.oidcClient = &oidc.Client{
+		Issuer:       provider.Issuer(),
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/callback",
+		Scopes:       []string{"email"},
+	}
+	return cfg, provider
+}
+
+func verifyTestUser(t *testing.T, cfg *apiConfig, user database.User) {
+	t.Helper()
+	_, err := cfg.dbQueries.SetUserVerifiedEmail(context.Background(), database.SetUserVerifiedEmailParams{
+		Email: user.Email,
+		ID:    user.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCLoginAsksForSecondFactor(t *testing.T) {
+	cfg, provider := testOIDCConfig(t)
+	user, _ := createTestUser(t, cfg)
+	verifyTestUser(t, cfg, user)
+	ctx := context.Background()
+	if _, err := cfg.dbQueries.UpsertUserTotp(ctx, database.UpsertUserTotpParams{UserID: user.ID, Secret: "JBSWY3DPEHPK3PXP"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.dbQueries.ConfirmUserTotp(ctx, database.ConfirmUserTotpParams{UserID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+	provider.SetUser(oidctest.User{Subject: "sub-1", Email: user.Email, EmailVerified: true})
+
+	resp := oidcLogin(t, cfg)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var body struct {
+		MfaRequired bool   `json:"mfa_required"`
+		Token       string `json:"token"`
+	}
+	decodeBody(t, resp, &body)
+	if !body.MfaRequired || body.Token != "" {
+		t.Errorf("got a session without the second factor: %+v", body)
+	}
+}
+
+func TestOIDCDoesNotLinkUnverifiedAccount(t *testing.T) {
+	cfg, provider := testOIDCConfig(t)
+	squatter, _ := createTestUser(t, cfg)
+	provider.SetUser(oidctest.User{Subject: "sub-1", Email: squatter.Email, EmailVerified: true})
+
+	if resp := oidcLogin(t, cfg); resp.StatusCode != 409 {
+		t.Fatalf("status = %d, want 409", resp.StatusCode)
+	}
+	_, err := cfg.dbQueries.GetUserIdentity(context.Background(), database.GetUserIdentityParams{
+		Provider: cfg.oidcProvider,
+		Subject:  "sub-1",
+	})
+	if err == nil {
+		t.Error("identity was linked to the unverified account")
+	}
+}
+
+func TestOIDCLinksVerifiedAccount(t *testing.T) {
+	cfg, provider := testOIDCConfig(t)
+	user, _ := createTestUser(t, cfg)
+	verifyTestUser(t, cfg, user)
+	provider.SetUser(oidctest.User{Subject: "sub-1", Email: user.Email, EmailVerified: true})
+
+	resp := oidcLogin(t, cfg)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	var body struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	decodeBody(t, resp, &body)
+	if body.ID != user.ID.String() || body.Token == "" {
+		t.Errorf("unexpected session %+v", body)
+	}
+}
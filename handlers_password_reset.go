@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/mailer"
+)
+
+const passwordResetDuration = time.Hour
+
+func (cfg *apiConfig) handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if params.Email == "" {
+		responsdWithError(w, 400, "missing email field")
+		return
+	}
+	// always answer the same way so the endpoint can't be used to find out
+	// which emails have accounts
+	accepted := struct {
+		Message string `json:"message"`
+	}{
+		Message: "If that account exists a reset email has been sent",
+	}
+
+	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), params.Email)
+	if err == sql.ErrNoRows {
+		respondWithJSON(w, 202, accepted)
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	// the token and the mail are left until after answering, so both paths
+	// take one lookup and the response time doesn't give the account away
+	// either. Failures can only be logged.
+	go func() {
+		if err := cfg.sendPasswordReset(context.Background(), user); err != nil {
+			log.Printf("Error sending password reset email: %v", err)
+		}
+	}()
+	respondWithJSON(w, 202, accepted)
+}
+
+func (cfg *apiConfig) sendPasswordReset(ctx context.Context, user database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	_, err = cfg.dbQueries.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		HashedToken: auth.HashToken(token),
+		ExpiresAt:   time.Now().Add(passwordResetDuration),
+		UserID:      user.ID,
+	})
+	if err != nil {
+		return err
+	}
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Use this token to reset your password, it expires in %v:\n\n%s\n\nIf you didn't ask for this you can ignore this email.",
+			passwordResetDuration, token),
+	})
+}
+
+func (cfg *apiConfig) handleConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if params.Token == "" {
+		responsdWithError(w, 400, "missing token field")
+		return
+	}
+	if params.Password == "" {
+		responsdWithError(w, 400, "missing password field")
+		return
+	}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	resetToken, err := qtx.UsePasswordResetToken(r.Context(), auth.HashToken(params.Token))
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 401, "Invalid or expired token")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	user, err := qtx.GetUserById(r.Context(), resetToken.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	// rejecting the password rolls back, leaving the token usable for
+	// another attempt
+	if !cfg.checkPasswordPolicy(w, params.Password, user.Email) {
+		return
+	}
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	_, err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		HashedPassword: hashedPassword,
+		ID:             resetToken.UserID,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if err := revokeAllUserCredentials(r.Context(), qtx, resetToken.UserID); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	cfg.notify(r.Context(), resetToken.UserID, notifyPasswordChanged, struct{}{})
+	w.WriteHeader(204)
+}
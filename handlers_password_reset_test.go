@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRequestPasswordResetDoesNotRevealAccounts(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	mail := cfg.mailer.(*testMailer)
+
+	unknown := do(t, cfg.handleRequestPasswordReset, "POST", "/api/password-reset", "", map[string]string{"email": "nobody@example.com"})
+	known := do(t, cfg.handleRequestPasswordReset, "POST", "/api/password-reset", "", map[string]string{"email": user.Email})
+	if unknown.Code != 202 || known.Code != 202 || unknown.Body.String() != known.Body.String() {
+		t.Fatalf("responses differ: %d %q vs %d %q", unknown.Code, unknown.Body, known.Code, known.Body)
+	}
+	if sent := mail.waitForMessages(t, 1); len(sent) != 1 || sent[0].To != user.Email {
+		t.Fatalf("sent = %+v", sent)
+	}
+
+	mail.err = errors.New("smtp down")
+	failed := do(t, cfg.handleRequestPasswordReset, "POST", "/api/password-reset", "", map[string]string{"email": user.Email})
+	if failed.Code != 202 || failed.Body.String() != unknown.Body.String() {
+		t.Errorf("mail failure changed the response: %d %q", failed.Code, failed.Body)
+	}
+}
+
+func TestConfirmPasswordReset(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	mail := cfg.mailer.(*testMailer)
+
+	do(t, cfg.handleRequestPasswordReset, "POST", "/api/password-reset", "", map[string]string{"email": user.Email})
+	sent := mail.waitForMessages(t, 1)
+	if len(sent) != 1 {
+		t.Fatalf("sent = %+v", sent)
+	}
+	token := mailedToken(t, sent[0])
+
+	body := map[string]string{"token": token, "password": "a brand new passphrase"}
+	if rec := do(t, cfg.handleConfirmPasswordReset, "POST", "/api/password-reset/confirm", "", body); rec.Code != 204 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, cfg.handleConfirmPasswordReset, "POST", "/api/password-reset/confirm", "", body); rec.Code != 401 {
+		t.Errorf("reused token: status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+const (
+	polkaSignatureHeader = "Polka-Signature"
+	polkaDeliveryHeader  = "Polka-Delivery"
+	// polkaSignatureTolerance bounds how old a signed request may be, so a
+	// captured one can't be replayed later
+	polkaSignatureTolerance = 5 * time.Minute
+	maxPolkaBody            = 64 << 10
+	// maxAdminPageSize caps admin lists, which no tier applies to
+	maxAdminPageSize = 500
+
+	sourcePolka = "polka"
+)
+
+// Processing states of an inbound webhook event.
+const (
+	inboundPending   = "pending"
+	inboundProcessed = "processed"
+	inboundIgnored   = "ignored"
+	inboundFailed    = "failed"
+)
+
+var (
+	errPolkaUserNotFound = errors.New("user not found")
+	errPolkaBadPayload   = errors.New("invalid payload")
+)
+
+type polkaEvent struct {
+	ID    string `json:"id"`
+	EVENT string `json:"event"`
+	// CreatedAt is when the event happened, which can be well before it
+	// is delivered
+	CreatedAt *time.Time `json:"created_at"`
+	DATA      struct {
+		USER_ID          string     `json:"user_id"`
+		Plan             string     `json:"plan"`
+		CurrentPeriodEnd *time.Time `json:"current_period_end"`
+	} `json:"data"`
+}
+
+type inboundWebhookEventSchema struct {
+	ID             string          `json:"id"`
+	Source         string          `json:"source"`
+	EventID        *string         `json:"event_id"`
+	Type           string          `json:"type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Deliveries     int32           `json:"deliveries"`
+	Attempts       int32           `json:"attempts"`
+	ReceivedAt     string          `json:"received_at"`
+	LastReceivedAt string          `json:"last_received_at"`
+	ProcessedAt    *string         `json:"processed_at"`
+	LastError      *string         `json:"last_error"`
+}
+
+func toInboundWebhookEventSchema(event database.InboundWebhookEvent) inboundWebhookEventSchema {
+	resp := inboundWebhookEventSchema{
+		ID:             event.ID.String(),
+		Source:         event.Source,
+		Type:           event.Type,
+		Payload:        event.Payload,
+		Status:         event.Status,
+		Deliveries:     event.Deliveries,
+		Attempts:       event.Attempts,
+		ReceivedAt:     event.ReceivedAt.UTC().Format(time.RFC3339Nano),
+		LastReceivedAt: event.LastReceivedAt.UTC().Format(time.RFC3339Nano),
+		ProcessedAt:    nullTimeString(event.ProcessedAt),
+	}
+	if event.EventID.Valid {
+		resp.EventID = &event.EventID.String
+	}
+	if event.LastError.Valid {
+		resp.LastError = &event.LastError.String
+	}
+	return resp
+}
+
+// verifyPolka checks that a webhook really came from Polka. With signing
+// secrets configured the Polka-Signature header must be an HMAC of the
+// timestamp and the raw body under one of them. The static ApiKey header is
+// only accepted when the deployment has opted into the legacy key, and every
+// such request is logged.
+func (cfg *apiConfig) verifyPolka(r *http.Request, body []byte) error {
+	if len(cfg.polkaSecrets) > 0 {
+		return webhooks.Verify(r.Header.Get(polkaSignatureHeader), body, polkaSignatureTolerance, cfg.polkaSecrets...)
+	}
+	if cfg.polkaKey == "" {
+		return errors.New("no polka signing secret configured")
+	}
+	log.Printf("Warning: accepting a Polka webhook from %s by its legacy ApiKey, set POLKA_WEBHOOK_SECRET", clientIP(r))
+	apiKey, err := auth.GetAPIKey(r.Header)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.polkaKey)) != 1 {
+		return errors.New("invalid api key")
+	}
+	return nil
+}
+
+// polkaEventID identifies a delivery so retries of it can be recognised:
+// the payload's id, else Polka's delivery header, else the verified
+// signature, which covers the timestamp the request was signed at as well
+// as the body. Without any of those the event has no identity. Two events
+// can have identical bodies (every monthly user.renewed does), so the body
+// alone must not stand in for one.
+func (cfg *apiConfig) polkaEventID(r *http.Request, body []byte, params polkaEvent) sql.NullString {
+	if params.ID != "" {
+		return sql.NullString{String: params.ID, Valid: true}
+	}
+	if delivery := r.Header.Get(polkaDeliveryHeader); delivery != "" {
+		return sql.NullString{String: "delivery:" + delivery, Valid: true}
+	}
+	if len(cfg.polkaSecrets) > 0 {
+		h := sha256.New()
+		h.Write([]byte(r.Header.Get(polkaSignatureHeader)))
+		h.Write(body)
+		return sql.NullString{String: "signature:" + hex.EncodeToString(h.Sum(nil)), Valid: true}
+	}
+	return sql.NullString{}
+}
+
+// handleUpdateUserToChirpyRed records every Polka delivery before acting on
+// it. Retries of an event share its id, so they land on the same row and an
+// event that was already processed isn't applied twice. Events Polka gave
+// no identity are stored and applied every time they arrive.
+func (cfg *apiConfig) handleUpdateUserToChirpyRed(w http.ResponseWriter, r *http.Request) {
+	// the signature covers the exact bytes sent, so read them before decoding
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPolkaBody))
+	if err != nil {
+		responsdWithError(w, 400, "Error reading body")
+		return
+	}
+	if err := cfg.verifyPolka(r, body); err != nil {
+		responsdWithError(w, 401, "Unauthorized")
+		return
+	}
+	params := polkaEvent{}
+	if err := json.Unmarshal(body, &params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	event, err := cfg.dbQueries.RecordInboundWebhookEvent(r.Context(), database.RecordInboundWebhookEventParams{
+		Source:  sourcePolka,
+		EventID: cfg.polkaEventID(r, body, params),
+		Type:    params.EVENT,
+		Payload: body,
+	})
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server error")
+		return
+	}
+	_, err = cfg.processPolkaEvent(r.Context(), event.ID)
+	switch {
+	case errors.Is(err, errPolkaUserNotFound):
+		responsdWithError(w, 404, "User Not Found")
+	case errors.Is(err, errPolkaBadPayload):
+		responsdWithError(w, 400, err.Error())
+	case err != nil:
+		responsdWithError(w, 500, "Internal Server error")
+	default:
+		w.WriteHeader(204)
+	}
+}
+
+// processPolkaEvent applies a stored event, at most once. The event row is
+// locked and marked done in the same transaction as the user update, so
+// concurrent retries wait for each other and a crash leaves neither
+// applied. Failures are recorded on the event for an admin to replay.
+func (cfg *apiConfig) processPolkaEvent(ctx context.Context, id uuid.UUID) (database.InboundWebhookEvent, error) {
+	event, changed, err := cfg.applyPolkaEvent(ctx, id)
+	if err != nil {
+		failErr := cfg.dbQueries.FailInboundWebhookEvent(ctx, database.FailInboundWebhookEventParams{
+			ID:        id,
+			LastError: sql.NullString{String: err.Error(), Valid: true},
+		})
+		if failErr != nil {
+			log.Printf("Error recording failed webhook event %s: %v", id, failErr)
+		}
+		return database.InboundWebhookEvent{}, err
+	}
+	if changed != nil {
+		cfg.announceChirpyRed(ctx, *changed)
+	}
+	return event, nil
+}
+
+// applyPolkaEvent moves the user's subscription along and returns the user
+// when that changed is_chirpy_red, so the caller can announce it once the
+// transaction has committed.
+func (cfg *apiConfig) applyPolkaEvent(ctx context.Context, id uuid.UUID) (database.InboundWebhookEvent, *database.User, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return database.InboundWebhookEvent{}, nil, err
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	event, err := qtx.LockInboundWebhookEvent(ctx, id)
+	if err != nil {
+		return database.InboundWebhookEvent{}, nil, err
+	}
+	if event.Status == inboundProcessed || event.Status == inboundIgnored {
+		return event, nil, nil
+	}
+	params := polkaEvent{}
+	if err := json.Unmarshal(event.Payload, &params); err != nil {
+		return database.InboundWebhookEvent{}, nil, fmt.Errorf("%w: %v", errPolkaBadPayload, err)
+	}
+
+	status := inboundIgnored
+	var changed *database.User
+	switch params.EVENT {
+	case polkaUserUpgraded, polkaUserRenewed, polkaUserCancelled, polkaUserPaymentFailed, polkaUserDowngraded:
+		userId, err := uuid.Parse(params.DATA.USER_ID)
+		if err != nil {
+			return database.InboundWebhookEvent{}, nil, fmt.Errorf("%w: bad user_id", errPolkaBadPayload)
+		}
+		if _, err := qtx.GetUserById(ctx, userId); err == sql.ErrNoRows {
+			return database.InboundWebhookEvent{}, nil, errPolkaUserNotFound
+		} else if err != nil {
+			return database.InboundWebhookEvent{}, nil, err
+		}
+		current, err := qtx.GetSubscriptionByUserId(ctx, userId)
+		if err != nil && err != sql.ErrNoRows {
+			return database.InboundWebhookEvent{}, nil, err
+		}
+		next, ok := nextSubscription(userId, current, err == nil, params, time.Now())
+		if !ok {
+			break
+		}
+		status = inboundProcessed
+		if _, err := qtx.UpsertSubscription(ctx, next); err != nil {
+			return database.InboundWebhookEvent{}, nil, err
+		}
+		user, err := qtx.SyncUserChirpyRed(ctx, userId)
+		if err != nil && err != sql.ErrNoRows {
+			return database.InboundWebhookEvent{}, nil, err
+		}
+		if err == nil {
+			changed = &user
+		}
+	}
+	event, err = qtx.CompleteInboundWebhookEvent(ctx, database.CompleteInboundWebhookEventParams{
+		ID:     id,
+		Status: status,
+	})
+	if err != nil {
+		return database.InboundWebhookEvent{}, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return database.InboundWebhookEvent{}, nil, err
+	}
+	return event, changed, nil
+}
+
+// handleListWebhookEvents lets admins look through received webhooks,
+// newest first, optionally only those in one ?status=.
+func (cfg *apiConfig) handleListWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	status := sql.NullString{}
+	if raw := query.Get("status"); raw != "" {
+		switch raw {
+		case inboundPending, inboundProcessed, inboundIgnored, inboundFailed:
+		default:
+			responsdWithError(w, 400, "status must be one of pending, processed, ignored or failed")
+			return
+		}
+		status = sql.NullString{String: raw, Valid: true}
+	}
+	before := uuid.NullUUID{}
+	if raw := query.Get("before"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			responsdWithError(w, 400, "Invalid before format")
+			return
+		}
+		before = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	limit := defaultDeliveryPageSize
+	if raw := query.Get("limit"); raw != "" {
+		var err error
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxAdminPageSize {
+			responsdWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", maxAdminPageSize))
+			return
+		}
+	}
+	events, err := cfg.dbQueries.ListInboundWebhookEvents(r.Context(), database.ListInboundWebhookEventsParams{
+		Status: status,
+		Before: before,
+		Limit:  int32(limit),
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting webhook events: %v", err))
+		return
+	}
+	resp := make([]inboundWebhookEventSchema, 0, len(events))
+	for _, event := range events {
+		resp = append(resp, toInboundWebhookEventSchema(event))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handleReplayWebhookEvent processes a failed event again, for instance
+// once the user it named exists.
+func (cfg *apiConfig) handleReplayWebhookEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("eventID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid event_id format")
+		return
+	}
+	event, err := cfg.dbQueries.GetInboundWebhookEvent(r.Context(), id)
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "Event not found")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if event.Status != inboundFailed {
+		responsdWithError(w, 409, fmt.Sprintf("event is %s, only failed events can be replayed", event.Status))
+		return
+	}
+	event, err = cfg.processPolkaEvent(r.Context(), id)
+	if err != nil {
+		event, getErr := cfg.dbQueries.GetInboundWebhookEvent(r.Context(), id)
+		if getErr != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		respondWithJSON(w, 422, toInboundWebhookEventSchema(event))
+		return
+	}
+	respondWithJSON(w, 200, toInboundWebhookEventSchema(event))
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+const testPolkaSecret = "polka-secret"
+
+// polkaRequest builds a webhook request for body, signed at signedAt when
+// the config has signing secrets and carrying the legacy ApiKey otherwise.
+func polkaRequest(cfg *apiConfig, body string, signedAt time.Time) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", bytes.NewReader([]byte(body)))
+	if len(cfg.polkaSecrets) > 0 {
+		req.Header.Set(polkaSignatureHeader, webhooks.Sign(cfg.polkaSecrets[0], signedAt, []byte(body)))
+	} else {
+		req.Header.Set("Authorization", "ApiKey "+cfg.polkaKey)
+	}
+	return serve(cfg.handleUpdateUserToChirpyRed, req)
+}
+
+func listPolkaEvents(t *testing.T, cfg *apiConfig) []database.InboundWebhookEvent {
+	t.Helper()
+	events, err := cfg.dbQueries.ListInboundWebhookEvents(context.Background(), database.ListInboundWebhookEventsParams{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestPolkaEventID(t *testing.T) {
+	cfg := testConfig(t)
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"x"}}`)
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", nil)
+
+	if id := cfg.polkaEventID(req, body, polkaEvent{ID: "evt_1"}); id.String != "evt_1" {
+		t.Errorf("payload id: got %+v", id)
+	}
+	if id := cfg.polkaEventID(req, body, polkaEvent{}); id.Valid {
+		t.Errorf("unsigned body without an id got identity %q", id.String)
+	}
+
+	req.Header.Set(polkaDeliveryHeader, "d_1")
+	if id := cfg.polkaEventID(req, body, polkaEvent{}); id.String != "delivery:d_1" {
+		t.Errorf("delivery header: got %+v", id)
+	}
+	req.Header.Del(polkaDeliveryHeader)
+
+	cfg.polkaSecrets = []string{testPolkaSecret}
+	now := time.Now()
+	req.Header.Set(polkaSignatureHeader, webhooks.Sign(testPolkaSecret, now, body))
+	first := cfg.polkaEventID(req, body, polkaEvent{})
+	if !first.Valid || first != cfg.polkaEventID(req, body, polkaEvent{}) {
+		t.Fatalf("the same signed request should keep its identity, got %+v", first)
+	}
+	req.Header.Set(polkaSignatureHeader, webhooks.Sign(testPolkaSecret, now.Add(-time.Minute), body))
+	if cfg.polkaEventID(req, body, polkaEvent{}) == first {
+		t.Error("the same body signed at another time should be a different event")
+	}
+}
+
+func TestPolkaUnkeyedEventsAreAllApplied(t *testing.T) {
+	cfg := testDBConfig(t)
+	// only the legacy ApiKey leaves an event without any identity
+	cfg.polkaKey = "polka-key"
+	user, _ := createTestUser(t, cfg)
+	body := `{"event":"user.renewed","data":{"user_id":"` + user.ID.String() + `"}}`
+
+	for i := 0; i < 2; i++ {
+		if rec := polkaRequest(cfg, body, time.Now()); rec.Code != 204 {
+			t.Fatalf("delivery %d: status %d: %s", i, rec.Code, rec.Body)
+		}
+	}
+	events := listPolkaEvents(t, cfg)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want both renewals stored", len(events))
+	}
+	for _, event := range events {
+		if event.EventID.Valid || event.Status != inboundProcessed {
+			t.Errorf("event %+v, want processed with no event_id", event)
+		}
+	}
+	sub, err := cfg.dbQueries.GetSubscriptionByUserId(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().Add(2*subscriptionPeriod - time.Hour); sub.CurrentPeriodEnd.Before(want) {
+		t.Errorf("period ends %v, want two renewals past %v", sub.CurrentPeriodEnd, want)
+	}
+}
+
+func TestPolkaRetriesAreDeduplicated(t *testing.T) {
+	cfg := testDBConfig(t)
+	cfg.polkaSecrets = []string{testPolkaSecret}
+	user, _ := createTestUser(t, cfg)
+	withId := `{"id":"evt_1","event":"user.upgraded","data":{"user_id":"` + user.ID.String() + `"}}`
+	withoutId := `{"event":"user.renewed","data":{"user_id":"` + user.ID.String() + `"}}`
+
+	signedAt := time.Now()
+	deliveries := []struct {
+		body     string
+		signedAt time.Time
+	}{
+		{withId, signedAt},
+		{withId, signedAt.Add(-time.Minute)},
+		{withoutId, signedAt},
+		{withoutId, signedAt},
+		{withoutId, signedAt.Add(-time.Minute)},
+	}
+	for i, d := range deliveries {
+		if rec := polkaRequest(cfg, d.body, d.signedAt); rec.Code != 204 {
+			t.Fatalf("delivery %d: status %d: %s", i, rec.Code, rec.Body)
+		}
+	}
+
+	// evt_1 twice, the renewal signed at signedAt twice, and once more
+	// signed at another time
+	events := listPolkaEvents(t, cfg)
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	deliveriesById := map[sql.NullString]int32{}
+	for _, event := range events {
+		deliveriesById[event.EventID] = event.Deliveries
+	}
+	if deliveriesById[sql.NullString{String: "evt_1", Valid: true}] != 2 {
+		t.Errorf("evt_1 deliveries = %v", deliveriesById)
+	}
+}
+
+func TestPolkaRejectsBadSignatures(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.polkaSecrets = []string{testPolkaSecret, "old-secret"}
+	cfg.polkaKey = "polka-key"
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"` + uuid.NewString() + `"}}`)
+
+	cases := map[string]func(req *http.Request){
+		"unsigned":     func(req *http.Request) {},
+		"api key only": func(req *http.Request) { req.Header.Set("Authorization", "ApiKey polka-key") },
+		"wrong secret": func(req *http.Request) {
+			req.Header.Set(polkaSignatureHeader, webhooks.Sign("guess", time.Now(), body))
+		},
+		"too old": func(req *http.Request) {
+			req.Header.Set(polkaSignatureHeader, webhooks.Sign(testPolkaSecret, time.Now().Add(-time.Hour), body))
+		},
+		"signed another body": func(req *http.Request) {
+			req.Header.Set(polkaSignatureHeader, webhooks.Sign(testPolkaSecret, time.Now(), []byte("{}")))
+		},
+		"malformed signature": func(req *http.Request) { req.Header.Set(polkaSignatureHeader, "v1=abc") },
+	}
+	for name, setup := range cases {
+		req := httptest.NewRequest("POST", "/api/polka/webhooks", bytes.NewReader(body))
+		setup(req)
+		if rec := serve(cfg.handleUpdateUserToChirpyRed, req); rec.Code != 401 {
+			t.Errorf("%s: status %d, want 401", name, rec.Code)
+		}
+	}
+}
+
+func TestPolkaRejectsApiKeyWithoutOptIn(t *testing.T) {
+	cfg := testConfig(t)
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"` + uuid.NewString() + `"}}`)
+	for _, header := range []string{"", "ApiKey ", "ApiKey polka-key"} {
+		req := httptest.NewRequest("POST", "/api/polka/webhooks", bytes.NewReader(body))
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		if rec := serve(cfg.handleUpdateUserToChirpyRed, req); rec.Code != 401 {
+			t.Errorf("%q: status %d, want 401 with no secret and no legacy key", header, rec.Code)
+		}
+	}
+}
+
+func TestPolkaAcceptsRotatedSecret(t *testing.T) {
+	cfg := testDBConfig(t)
+	cfg.polkaSecrets = []string{testPolkaSecret, "old-secret"}
+	user, _ := createTestUser(t, cfg)
+	body := []byte(`{"event":"user.upgraded","data":{"user_id":"` + user.ID.String() + `"}}`)
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", bytes.NewReader(body))
+	req.Header.Set(polkaSignatureHeader, webhooks.Sign("old-secret", time.Now(), body))
+	if rec := serve(cfg.handleUpdateUserToChirpyRed, req); rec.Code != 204 {
+		t.Errorf("status %d, want 204 for the secret being rotated out: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedUsernames can't be claimed because they collide with routes
+// under /api/users.
+var reservedUsernames = map[string]bool{
+	"me":    true,
+	"admin": true,
+}
+
+type publicProfile struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatar_url"`
+	CreatedAt   string `json:"created_at"`
+	IsChirpyRed bool   `json:"is_chirpy_red"`
+	ChirpCount  int64  `json:"chirp_count"`
+}
+
+func nullString(s *string) sql.NullString {
+	if s == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *s, Valid: true}
+}
+
+type profileFields struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+// validate checks every field that was supplied and returns the first
+// problem found.
+func (p profileFields) validate() string {
+	if p.Username != nil {
+		if !usernamePattern.MatchString(*p.Username) {
+			return "username must be 3-30 letters, digits or underscores"
+		}
+		if reservedUsernames[strings.ToLower(*p.Username)] {
+			return "username is reserved"
+		}
+	}
+	if p.DisplayName != nil && utf8.RuneCountInString(*p.DisplayName) > maxDisplayNameLength {
+		return fmt.Sprintf("display_name must be at most %d characters", maxDisplayNameLength)
+	}
+	if p.Bio != nil && utf8.RuneCountInString(*p.Bio) > maxBioLength {
+		return fmt.Sprintf("bio must be at most %d characters", maxBioLength)
+	}
+	if p.AvatarURL != nil && *p.AvatarURL != "" {
+		u, err := url.Parse(*p.AvatarURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return "avatar_url must be an https URL"
+		}
+	}
+	return ""
+}
+
+// userPatch is the body of PATCH /api/users/me. Every field is optional;
+// omitted fields keep their current value.
+type userPatch struct {
+	profileFields
+	Email           *string `json:"email"`
+	Password        *string `json:"password"`
+	CurrentPassword string  `json:"current_password"`
+	AcceptMessages  *string `json:"accept_messages"`
+}
+
+// userETag is the entity tag for a user row. It changes on every update.
+func userETag(user database.User) string {
+	return fmt.Sprintf(`"%d"`, user.Version)
+}
+
+// parseIfMatch turns an If-Match header into the version the update must
+// find. A missing header or "*" matches any version.
+func parseIfMatch(header string) (sql.NullInt32, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return sql.NullInt32{}, true
+	}
+	header = strings.TrimPrefix(header, "W/")
+	version, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 32)
+	if err != nil {
+		return sql.NullInt32{}, false
+	}
+	return sql.NullInt32{Int32: int32(version), Valid: true}, true
+}
+
+func (cfg *apiConfig) handleGetMe(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	user, err := cfg.dbQueries.GetUserById(r.Context(), caller.UserID)
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "User Not Found")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	w.Header().Set("ETag", userETag(user))
+	respondWithJSON(w, 200, toUserSchema(user))
+}
+
+func (cfg *apiConfig) handleUpdateProfile(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	expectedVersion, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		responsdWithError(w, 412, "If-Match does not match the current version")
+		return
+	}
+	params := userPatch{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if msg := params.validate(); msg != "" {
+		responsdWithError(w, 400, msg)
+		return
+	}
+	if params.AcceptMessages != nil && !isValidAcceptMessages(*params.AcceptMessages) {
+		responsdWithError(w, 400, "accept_messages must be everyone or nobody")
+		return
+	}
+	if params.Email != nil && !validateEmail(*params.Email) {
+		responsdWithError(w, 400, "invalid email address")
+		return
+	}
+	current, err := cfg.dbQueries.GetUserById(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+
+	// a new address only replaces the current one once it's been verified
+	emailChanged := params.Email != nil && *params.Email != current.Email
+	// whoever controls the address can reset the password, so changing it
+	// needs the same proof as changing the password itself
+	if params.Password != nil || emailChanged {
+		if params.CurrentPassword == "" {
+			responsdWithError(w, 400, "current_password is required to change the email or password")
+			return
+		}
+		if !cfg.checkCurrentPassword(w, r, current, params.CurrentPassword) {
+			return
+		}
+	}
+
+	var hashedPassword sql.NullString
+	if params.Password != nil {
+		if !cfg.checkPasswordPolicy(w, *params.Password, current.Email) {
+			return
+		}
+		hash, err := auth.HashPassword(*params.Password)
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		hashedPassword = sql.NullString{String: hash, Valid: true}
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	user, err := qtx.UpdateUserProfile(r.Context(), database.UpdateUserProfileParams{
+		Username:        nullString(params.Username),
+		DisplayName:     nullString(params.DisplayName),
+		Bio:             nullString(params.Bio),
+		AvatarUrl:       nullString(params.AvatarURL),
+		HashedPassword:  hashedPassword,
+		AcceptMessages:  nullString(params.AcceptMessages),
+		ID:              caller.UserID,
+		ExpectedVersion: expectedVersion,
+	})
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		responsdWithError(w, 409, "username already taken")
+		return
+	}
+	if err == sql.ErrNoRows && expectedVersion.Valid {
+		responsdWithError(w, 412, "If-Match does not match the current version")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	// other sessions, keys and app grants were handed out on the strength
+	// of the old password
+	if hashedPassword.Valid {
+		if err := revokeAllUserCredentials(r.Context(), qtx, caller.UserID); err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if hashedPassword.Valid {
+		cfg.notify(r.Context(), user.ID, notifyPasswordChanged, struct{}{})
+	}
+	// the update has already happened, so a mail failure is logged rather
+	// than reported as a failed update; the user can ask again
+	if emailChanged {
+		if err := cfg.sendEmailVerification(r.Context(), user.ID, *params.Email); err != nil {
+			log.Printf("Error sending verification email: %v", err)
+		}
+	}
+	resp := struct {
+		users
+		PendingEmail string `json:"pending_email,omitempty"`
+	}{
+		users: toUserSchema(user),
+	}
+	if emailChanged {
+		resp.PendingEmail = *params.Email
+	}
+	w.Header().Set("ETag", userETag(user))
+	respondWithJSON(w, 200, resp)
+}
+
+// handleGetPublicProfile looks a user up by id or by username. It never
+// returns the email address.
+func (cfg *apiConfig) handleGetPublicProfile(w http.ResponseWriter, r *http.Request) {
+	viewer, err := cfg.viewer(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	handleOrId := r.PathValue("handleOrID")
+	var user database.User
+	if id, parseErr := uuid.Parse(handleOrId); parseErr == nil {
+		user, err = cfg.dbQueries.GetUserById(r.Context(), id)
+	} else {
+		user, err = cfg.dbQueries.GetUserByUsername(r.Context(), strings.TrimPrefix(handleOrId, "@"))
+	}
+	if err == sql.ErrNoRows || (err == nil && user.DeletedAt.Valid) {
+		responsdWithError(w, 404, "User Not Found")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	if viewer.Valid {
+		blocked, err := cfg.dbQueries.IsBlockedEitherWay(r.Context(), database.IsBlockedEitherWayParams{
+			BlockerID: user.ID,
+			BlockedID: viewer.UUID,
+		})
+		if err != nil {
+			responsdWithError(w, 500, "Internal Server Error")
+			return
+		}
+		if blocked {
+			responsdWithError(w, 404, "User Not Found")
+			return
+		}
+	}
+	count, err := cfg.dbQueries.CountChirpsByAuthorId(r.Context(), user.ID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, 200, publicProfile{
+		ID:          user.ID.String(),
+		Username:    user.Username.String,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarUrl,
+		CreatedAt:   user.CreatedAt.String(),
+		IsChirpyRed: user.IsChirpyRed,
+		ChirpCount:  count,
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+)
+
+func TestUpdateProfileEmailNeedsCurrentPassword(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	key := createTestAPIKey(t, cfg, user.ID, []string{auth.ScopeProfileWrite})
+
+	tests := []struct {
+		name string
+		body map[string]string
+		want int
+	}{
+		{"missing", map[string]string{"email": "taken@example.com"}, 400},
+		{"wrong", map[string]string{"email": "taken@example.com", "current_password": "guess"}, 401},
+		{"same address", map[string]string{"email": user.Email}, 200},
+		{"correct", map[string]string{"email": "mine@example.com", "current_password": "correct horse battery"}, 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, cfg.handleUpdateProfile, "PATCH", "/api/users/me", key, tt.body)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+	if sent := cfg.mailer.(*testMailer).messages(); len(sent) != 1 || sent[0].To != "mine@example.com" {
+		t.Errorf("sent = %+v, want one verification mail to mine@example.com", sent)
+	}
+}
+
+func TestUpdateProfilePasswordRevokesCredentials(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	key := createTestAPIKey(t, cfg, user.ID, []string{auth.ScopeRead})
+
+	rec := do(t, cfg.handleUpdateProfile, "PATCH", "/api/users/me", token, map[string]string{
+		"password":         "another fine passphrase",
+		"current_password": "correct horse battery",
+	})
+	if rec.Code != 200 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := cfg.authenticate(newRequest(t, "GET", "/", key, nil)); err == nil {
+		t.Error("api key still works after the password changed")
+	}
+}
+
+func TestUpdateProfileCurrentPasswordIsThrottled(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	key := createTestAPIKey(t, cfg, user.ID, []string{auth.ScopeProfileWrite})
+	update := func(current string) int {
+		return do(t, cfg.handleUpdateProfile, "PATCH", "/api/users/me", key, map[string]string{
+			"email":            "mine@example.com",
+			"current_password": current,
+		}).Code
+	}
+
+	for i := range cfg.accountLimiter.Threshold {
+		if code := update("guess"); code != 401 {
+			t.Fatalf("guess %d: status = %d, want 401", i, code)
+		}
+	}
+	if code := update("correct horse battery"); code != 429 {
+		t.Errorf("status = %d, want 429 once the account is locked", code)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultStatsDays = 7
+	maxStatsDays     = 90
+	topChirpsCount   = 10
+	// impressionTimeout bounds each batch write
+	impressionTimeout = 5 * time.Second
+	// impressionQueueSize bounds the impressions waiting to be written.
+	// Past it new ones are dropped: stats are best effort and mustn't slow
+	// down or pile up behind reads.
+	impressionQueueSize     = 10000
+	impressionBatchSize     = 1000
+	impressionFlushInterval = time.Second
+)
+
+type impression struct {
+	ChirpID   uuid.UUID
+	AuthorID  uuid.UUID
+	ViewerKey string
+}
+
+type statsPoint struct {
+	Period      string `json:"period"`
+	Impressions int64  `json:"impressions"`
+}
+
+type statsQuery struct {
+	Granularity string
+	Since       time.Time
+}
+
+// viewerKey identifies who saw a chirp for deduplication. Anonymous
+// viewers are told apart by address alone, hashed so it isn't stored.
+// Headers such as the user agent are left out since a client can change
+// them on every request; an IPv6 client is keyed on its /64, which it
+// usually controls all of.
+func viewerKey(r *http.Request, viewer uuid.NullUUID) string {
+	if viewer.Valid {
+		return "user:" + viewer.UUID.String()
+	}
+	ip := clientIP(r)
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		addr = addr.Unmap()
+		if addr.Is6() {
+			prefix, _ := addr.Prefix(64)
+			ip = prefix.String()
+		} else {
+			ip = addr.String()
+		}
+	}
+	sum := sha256.Sum256([]byte(ip))
+	return "anon:" + hex.EncodeToString(sum[:16])
+}
+
+// recordImpressions counts chirps as seen by the viewer, at most once per
+// viewer per chirp per hour. Authors seeing their own chirps don't count.
+// Impressions are queued for writeImpressions so serving chirps doesn't
+// wait on the write, and dropped when the queue is full.
+func (cfg *apiConfig) recordImpressions(r *http.Request, viewer uuid.NullUUID, chirps ...database.Chirp) {
+	key := viewerKey(r, viewer)
+	for _, chirp := range chirps {
+		if viewer.Valid && chirp.UserID == viewer.UUID {
+			continue
+		}
+		select {
+		case cfg.impressions <- impression{ChirpID: chirp.ID, AuthorID: chirp.UserID, ViewerKey: key}:
+		default:
+			return
+		}
+	}
+}
+
+// writeImpressions is the only writer of queued impressions. It inserts
+// them in batches of up to impressionBatchSize, at least every interval,
+// until ctx is cancelled.
+func (cfg *apiConfig) writeImpressions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := make([]impression, 0, impressionBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := cfg.flushImpressions(batch); err != nil {
+			log.Printf("Error recording %d impressions: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case imp := <-cfg.impressions:
+			batch = append(batch, imp)
+			if len(batch) >= impressionBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (cfg *apiConfig) flushImpressions(batch []impression) error {
+	params := database.RecordImpressionsParams{
+		ChirpIds:   make([]uuid.UUID, len(batch)),
+		AuthorIds:  make([]uuid.UUID, len(batch)),
+		ViewerKeys: make([]string, len(batch)),
+	}
+	for i, imp := range batch {
+		params.ChirpIds[i], params.AuthorIds[i], params.ViewerKeys[i] = imp.ChirpID, imp.AuthorID, imp.ViewerKey
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), impressionTimeout)
+	defer cancel()
+	return cfg.dbQueries.RecordImpressions(ctx, params)
+}
+
+// rollupImpressions folds raw impressions into the hourly stats tables and
+// prunes the ones whose hour has passed. It runs until ctx is cancelled, so
+// stats trail live traffic by up to interval.
+func (cfg *apiConfig) rollupImpressions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := cfg.dbQueries.RollupImpressions(ctx); err != nil {
+			log.Printf("Error rolling up impressions: %v", err)
+		} else if _, err := cfg.dbQueries.PruneImpressions(ctx); err != nil {
+			log.Printf("Error pruning impressions: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseStatsQuery reads ?days= (default 7, at most 90) and ?granularity=
+// (hour or day, default hour).
+func parseStatsQuery(w http.ResponseWriter, r *http.Request) (statsQuery, bool) {
+	query := r.URL.Query()
+	days := defaultStatsDays
+	if raw := query.Get("days"); raw != "" {
+		var err error
+		days, err = strconv.Atoi(raw)
+		if err != nil || days < 1 || days > maxStatsDays {
+			responsdWithError(w, 400, fmt.Sprintf("days must be between 1 and %d", maxStatsDays))
+			return statsQuery{}, false
+		}
+	}
+	granularity := query.Get("granularity")
+	if granularity == "" {
+		granularity = "hour"
+	}
+	if granularity != "hour" && granularity != "day" {
+		responsdWithError(w, 400, "granularity must be hour or day")
+		return statsQuery{}, false
+	}
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour).Truncate(time.Hour)
+	return statsQuery{Granularity: granularity, Since: since}, true
+}
+
+// statsSeries turns rows into the response series. Periods without any
+// impressions are left out.
+func statsSeries(periods []time.Time, counts []int64) ([]statsPoint, int64) {
+	series := make([]statsPoint, 0, len(periods))
+	var total int64
+	for i, period := range periods {
+		series = append(series, statsPoint{
+			Period:      period.Format(time.RFC3339),
+			Impressions: counts[i],
+		})
+		total += counts[i]
+	}
+	return series, total
+}
+
+// handleGetChirpStats shows a chirp's author how many people saw it over
+// time.
+func (cfg *apiConfig) handleGetChirpStats(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	chirpId, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid chirp_id format")
+		return
+	}
+	q, ok := parseStatsQuery(w, r)
+	if !ok {
+		return
+	}
+	chirp, err := cfg.dbQueries.GetChirpsById(r.Context(), database.GetChirpsByIdParams{
+		ID:       chirpId,
+		ViewerID: uuid.NullUUID{UUID: caller.UserID, Valid: true},
+	})
+	if err != nil {
+		responsdWithError(w, 404, "Chirp not found")
+		return
+	}
+	if chirp.UserID != caller.UserID {
+		responsdWithError(w, 403, "only the author can see a chirp's stats")
+		return
+	}
+	rows, err := cfg.dbQueries.GetChirpStats(r.Context(), database.GetChirpStatsParams{
+		Granularity: q.Granularity,
+		ChirpID:     chirpId,
+		Since:       q.Since,
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting stats: %v", err))
+		return
+	}
+	periods := make([]time.Time, len(rows))
+	counts := make([]int64, len(rows))
+	for i, row := range rows {
+		periods[i], counts[i] = row.Period, row.Impressions
+	}
+	series, total := statsSeries(periods, counts)
+	resp := struct {
+		ChirpID          string       `json:"chirp_id"`
+		Granularity      string       `json:"granularity"`
+		Since            string       `json:"since"`
+		TotalImpressions int64        `json:"total_impressions"`
+		Series           []statsPoint `json:"series"`
+	}{
+		ChirpID:          chirpId.String(),
+		Granularity:      q.Granularity,
+		Since:            q.Since.UTC().Format(time.RFC3339),
+		TotalImpressions: total,
+		Series:           series,
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handleGetMyStats is the caller's reach across all their chirps, with the
+// chirps seen most over the same window.
+func (cfg *apiConfig) handleGetMyStats(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	q, ok := parseStatsQuery(w, r)
+	if !ok {
+		return
+	}
+	rows, err := cfg.dbQueries.GetAuthorStats(r.Context(), database.GetAuthorStatsParams{
+		Granularity: q.Granularity,
+		AuthorID:    caller.UserID,
+		Since:       q.Since,
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting stats: %v", err))
+		return
+	}
+	top, err := cfg.dbQueries.GetTopChirpsByImpressions(r.Context(), database.GetTopChirpsByImpressionsParams{
+		AuthorID: caller.UserID,
+		Since:    q.Since,
+		Limit:    topChirpsCount,
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting stats: %v", err))
+		return
+	}
+	periods := make([]time.Time, len(rows))
+	counts := make([]int64, len(rows))
+	for i, row := range rows {
+		periods[i], counts[i] = row.Period, row.Impressions
+	}
+	series, total := statsSeries(periods, counts)
+	type topChirp struct {
+		ChirpID     string `json:"chirp_id"`
+		Impressions int64  `json:"impressions"`
+	}
+	topChirps := make([]topChirp, 0, len(top))
+	for _, row := range top {
+		topChirps = append(topChirps, topChirp{ChirpID: row.ChirpID.String(), Impressions: row.Impressions})
+	}
+	resp := struct {
+		Granularity      string       `json:"granularity"`
+		Since            string       `json:"since"`
+		TotalImpressions int64        `json:"total_impressions"`
+		Series           []statsPoint `json:"series"`
+		TopChirps        []topChirp   `json:"top_chirps"`
+	}{
+		Granularity:      q.Granularity,
+		Since:            q.Since.UTC().Format(time.RFC3339),
+		TotalImpressions: total,
+		Series:           series,
+		TopChirps:        topChirps,
+	}
+	respondWithJSON(w, 200, resp)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestViewerKey(t *testing.T) {
+	key := func(remoteAddr, userAgent string) string {
+		req := httptest.NewRequest("GET", "/api/chirps", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("User-Agent", userAgent)
+		return viewerKey(req, uuid.NullUUID{})
+	}
+
+	if key("203.0.113.7:1000", "curl/8") != key("203.0.113.7:2000", "Mozilla/5.0") {
+		t.Error("changing the user agent or port gave an anonymous viewer a new key")
+	}
+	if key("203.0.113.7:1000", "curl/8") == key("203.0.113.8:1000", "curl/8") {
+		t.Error("different addresses share a key")
+	}
+	if key("[2001:db8:1:2::1]:1000", "a") != key("[2001:db8:1:2:ffff::9]:1000", "b") {
+		t.Error("addresses in one IPv6 /64 should share a key")
+	}
+	if key("[2001:db8:1:2::1]:1000", "a") == key("[2001:db8:1:3::1]:1000", "a") {
+		t.Error("different IPv6 /64s share a key")
+	}
+	if key("[::ffff:203.0.113.7]:1000", "a") != key("203.0.113.7:1000", "a") {
+		t.Error("an IPv4-mapped address should match its IPv4 form")
+	}
+
+	userId := uuid.New()
+	req := httptest.NewRequest("GET", "/api/chirps", nil)
+	if got := viewerKey(req, uuid.NullUUID{UUID: userId, Valid: true}); got != "user:"+userId.String() {
+		t.Errorf("signed in viewer key = %q", got)
+	}
+}
+
+func TestRecordImpressionsDropsWhenFull(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.impressions = make(chan impression, 2)
+	viewer := uuid.NullUUID{UUID: uuid.New(), Valid: true}
+	author := uuid.New()
+	chirps := []database.Chirp{{ID: uuid.New(), UserID: viewer.UUID}}
+	for i := 0; i < 5; i++ {
+		chirps = append(chirps, database.Chirp{ID: uuid.New(), UserID: author})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		cfg.recordImpressions(httptest.NewRequest("GET", "/api/chirps", nil), viewer, chirps...)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("recordImpressions blocked on a full queue")
+	}
+	if len(cfg.impressions) != 2 {
+		t.Fatalf("queued %d impressions, want 2", len(cfg.impressions))
+	}
+	for i := 0; i < 2; i++ {
+		imp := <-cfg.impressions
+		if imp.ChirpID != chirps[i+1].ID || imp.AuthorID != author {
+			t.Errorf("queued %+v, want the first chirps not by the viewer", imp)
+		}
+	}
+}
+
+// flushQueuedImpressions writes whatever is queued, standing in for
+// writeImpressions.
+func flushQueuedImpressions(t *testing.T, cfg *apiConfig) {
+	t.Helper()
+	var batch []impression
+	for len(cfg.impressions) > 0 {
+		batch = append(batch, <-cfg.impressions)
+	}
+	if len(batch) == 0 {
+		return
+	}
+	if err := cfg.flushImpressions(batch); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func viewChirp(t *testing.T, cfg *apiConfig, chirpId uuid.UUID, token, remoteAddr, userAgent string) {
+	t.Helper()
+	req := newRequest(t, "GET", "/api/chirps/"+chirpId.String(), token, nil)
+	req.SetPathValue("chirpID", chirpId.String())
+	req.RemoteAddr = remoteAddr
+	req.Header.Set("User-Agent", userAgent)
+	if rec := serve(cfg.handleGetChirpsById, req); rec.Code != 200 {
+		t.Fatalf("viewing chirp: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+type statsResponse struct {
+	TotalImpressions int64        `json:"total_impressions"`
+	Series           []statsPoint `json:"series"`
+	TopChirps        []struct {
+		ChirpID     string `json:"chirp_id"`
+		Impressions int64  `json:"impressions"`
+	} `json:"top_chirps"`
+}
+
+func chirpStats(t *testing.T, cfg *apiConfig, chirpId uuid.UUID, token string) (int, statsResponse) {
+	t.Helper()
+	req := newRequest(t, "GET", "/api/chirps/"+chirpId.String()+"/stats?granularity=day", token, nil)
+	req.SetPathValue("chirpID", chirpId.String())
+	rec := serve(cfg.handleGetChirpStats, req)
+	var resp statsResponse
+	if rec.Code == 200 {
+		decodeJSON(t, rec, &resp)
+	}
+	return rec.Code, resp
+}
+
+func TestImpressionsDeduplicateAndRollUp(t *testing.T) {
+	cfg := testDBConfig(t)
+	_, authorToken := createTestUser(t, cfg)
+	_, viewerToken := createTestUser(t, cfg)
+	rec := do(t, cfg.handleCreateChirps, "POST", "/api/chirps", authorToken, map[string]string{"body": "seen"})
+	if rec.Code != 201 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var created chirpSchema
+	decodeJSON(t, rec, &created)
+	chirpId := uuid.MustParse(created.ID)
+
+	// one signed in viewer twice, one anonymous viewer switching user
+	// agents, a second anonymous address, and the author, who doesn't count
+	viewChirp(t, cfg, chirpId, viewerToken, "198.51.100.1:1000", "a")
+	viewChirp(t, cfg, chirpId, viewerToken, "198.51.100.2:1000", "b")
+	viewChirp(t, cfg, chirpId, "", "203.0.113.7:1000", "curl/8")
+	viewChirp(t, cfg, chirpId, "", "203.0.113.7:1001", "Mozilla/5.0")
+	viewChirp(t, cfg, chirpId, "", "203.0.113.8:1000", "curl/8")
+	viewChirp(t, cfg, chirpId, authorToken, "198.51.100.3:1000", "a")
+	flushQueuedImpressions(t, cfg)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := cfg.dbQueries.RollupImpressions(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	code, stats := chirpStats(t, cfg, chirpId, authorToken)
+	if code != 200 {
+		t.Fatalf("chirp stats: status %d", code)
+	}
+	if stats.TotalImpressions != 3 || len(stats.Series) != 1 || stats.Series[0].Impressions != 3 {
+		t.Errorf("chirp stats %+v, want 3 impressions in one day", stats)
+	}
+
+	rec = do(t, cfg.handleGetMyStats, "GET", "/api/users/me/stats", authorToken, nil)
+	if rec.Code != 200 {
+		t.Fatalf("my stats: status %d: %s", rec.Code, rec.Body)
+	}
+	var mine statsResponse
+	decodeJSON(t, rec, &mine)
+	if mine.TotalImpressions != 3 || len(mine.TopChirps) != 1 || mine.TopChirps[0].ChirpID != created.ID || mine.TopChirps[0].Impressions != 3 {
+		t.Errorf("my stats %+v, want 3 impressions of %s", mine, created.ID)
+	}
+
+	if code, _ := chirpStats(t, cfg, chirpId, viewerToken); code != 403 {
+		t.Errorf("another user's chirp stats: status %d, want 403", code)
+	}
+}
+
+func TestWriteImpressionsFlushesOnStop(t *testing.T) {
+	cfg := testDBConfig(t)
+	author, authorToken := createTestUser(t, cfg)
+	rec := do(t, cfg.handleCreateChirps, "POST", "/api/chirps", authorToken, map[string]string{"body": "seen"})
+	if rec.Code != 201 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var created chirpSchema
+	decodeJSON(t, rec, &created)
+	chirpId := uuid.MustParse(created.ID)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		cfg.writeImpressions(ctx, time.Hour)
+		close(stopped)
+	}()
+	viewChirp(t, cfg, chirpId, "", "203.0.113.7:1000", "curl/8")
+	// the writer takes the impression off the queue before it stops
+	for len(cfg.impressions) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-stopped
+
+	if err := cfg.dbQueries.RollupImpressions(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := cfg.dbQueries.GetAuthorStats(context.Background(), database.GetAuthorStatsParams{
+		Granularity: "day",
+		AuthorID:    author.ID,
+		Since:       time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Impressions != 1 {
+		t.Errorf("author stats %+v, want the one queued impression", rows)
+	}
+}
+
+func TestStatsRejectBadQuery(t *testing.T) {
+	for _, query := range []string{"days=0", "days=91", "days=x", "granularity=week"} {
+		req := httptest.NewRequest("GET", "/api/users/me/stats?"+query, nil)
+		rec := httptest.NewRecorder()
+		if _, ok := parseStatsQuery(rec, req); ok || rec.Code != 400 {
+			t.Errorf("%s: ok %v status %d, want 400", query, ok, rec.Code)
+		}
+	}
+	req := httptest.NewRequest("GET", "/api/users/me/stats", nil)
+	q, ok := parseStatsQuery(httptest.NewRecorder(), req)
+	if !ok || q.Granularity != "hour" || time.Since(q.Since) < defaultStatsDays*24*time.Hour {
+		t.Errorf("defaults: %+v", q)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/eventbus"
+	"github.com/Moee1149/chirpy/internal/pubsub"
+	"github.com/google/uuid"
+)
+
+const (
+	eventChirpCreated = "chirp_created"
+	eventChirpDeleted = "chirp_deleted"
+
+	// events on userHub, only ever delivered to the user they're about
+	eventNotification   = "notification"
+	eventMessageCreated = "message_created"
+	eventUserUpgraded   = "user_upgraded"
+	eventUserDowngraded = "user_downgraded"
+
+	// streamHistory is how many events a reconnecting client can catch up on.
+	streamHistory   = 1000
+	streamHeartbeat = 25 * time.Second
+)
+
+type chirpDeletedPayload struct {
+	ID     string `json:"id"`
+	UserId string `json:"UserId"`
+}
+
+// publishEvent sends an event over the bus so live connections on every
+// instance hear about it, this one included.
+func (cfg *apiConfig) publishEvent(ctx context.Context, topic, kind string, key uuid.UUID, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", kind, err)
+		return
+	}
+	err = cfg.bus.Publish(ctx, eventbus.Event{Topic: topic, Type: kind, Key: key.String(), Data: data})
+	if err != nil {
+		log.Printf("Error publishing %s event: %v", kind, err)
+	}
+}
+
+// publishChirpEvent tells live streams about a chirp. The author's id is
+// the topic so streams can filter on it.
+func (cfg *apiConfig) publishChirpEvent(ctx context.Context, kind string, authorId uuid.UUID, payload any) {
+	cfg.publishEvent(ctx, eventbus.TopicChirps, kind, authorId, payload)
+}
+
+// publishUserEvent pushes an event to userId's own live connections.
+func (cfg *apiConfig) publishUserEvent(ctx context.Context, kind string, userId uuid.UUID, payload any) {
+	cfg.publishEvent(ctx, eventbus.TopicUsers, kind, userId, payload)
+}
+
+// routeEvent feeds events arriving from the bus into this instance's hubs.
+// They keep the bus's ids, which every instance shares, so a client can
+// resume with Last-Event-ID on whichever instance it reconnects to.
+func (cfg *apiConfig) routeEvent(event eventbus.Event) {
+	hubEvent := pubsub.Event{ID: event.ID, Type: event.Type, Topic: event.Key, Data: event.Data}
+	switch event.Topic {
+	case eventbus.TopicChirps:
+		cfg.chirpHub.Publish(hubEvent)
+	case eventbus.TopicUsers:
+		cfg.userHub.Publish(hubEvent)
+	}
+}
+
+// hiddenAuthors is the set of authors whose chirps the viewer shouldn't
+// get on a live stream, following the same rules as handleGetChirps. It's
+// taken once when the stream opens.
+func (cfg *apiConfig) hiddenAuthors(r *http.Request, viewer uuid.NullUUID, includeMuted bool) (map[string]bool, error) {
+	hidden := map[string]bool{}
+	if !viewer.Valid {
+		return hidden, nil
+	}
+	blocked, err := cfg.dbQueries.ListBlockRelatedUserIds(r.Context(), viewer.UUID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range blocked {
+		hidden[id.String()] = true
+	}
+	if includeMuted {
+		mutes, err := cfg.dbQueries.ListMutedUsers(r.Context(), viewer.UUID)
+		if err != nil {
+			return nil, err
+		}
+		for _, mute := range mutes {
+			hidden[mute.MutedID.String()] = true
+		}
+	}
+	return hidden, nil
+}
+
+// handleStreamChirps pushes chirp_created and chirp_deleted events as
+// Server-Sent Events. Clients resume with the Last-Event-ID header, or the
+// last_event_id query parameter when they can't set headers.
+func (cfg *apiConfig) handleStreamChirps(w http.ResponseWriter, r *http.Request) {
+	viewer, err := cfg.viewer(r)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	authorFilter := r.URL.Query().Get("author_id")
+	if authorFilter != "" {
+		authorId, err := uuid.Parse(authorFilter)
+		if err != nil {
+			responsdWithError(w, 400, "Invalid author_id format")
+			return
+		}
+		authorFilter = authorId.String()
+	}
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+	var lastId uint64
+	if lastEventId != "" {
+		lastId, err = strconv.ParseUint(lastEventId, 10, 64)
+		if err != nil {
+			responsdWithError(w, 400, "Invalid Last-Event-ID")
+			return
+		}
+	}
+	hidden, err := cfg.hiddenAuthors(r, viewer, authorFilter == "")
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	// the stream outlives any write timeout set on the server
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(200)
+
+	sub, replay := cfg.chirpHub.Subscribe(lastId)
+	defer sub.Cancel()
+	send := func(event pubsub.Event) error {
+		if (authorFilter != "" && event.Topic != authorFilter) || hidden[event.Topic] {
+			return nil
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, event.Data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	for _, event := range replay {
+		if err := send(event); err != nil {
+			return
+		}
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		case event, ok := <-sub.C:
+			if !ok {
+				// dropped for falling behind, the client reconnects and resumes
+				return
+			}
+			if err := send(event); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestStreamResumesOnAnotherInstance(t *testing.T) {
+	// two instances behind a load balancer, sharing one bus
+	a, b := testConfig(t), testConfig(t)
+	b.bus = a.bus
+	a.bus.Subscribe(a.routeEvent)
+	a.bus.Subscribe(b.routeEvent)
+
+	author := uuid.New()
+	for _, id := range []string{"1", "2", "3"} {
+		a.publishChirpEvent(context.Background(), eventChirpCreated, author, map[string]string{"id": id})
+	}
+
+	sub, seen := a.chirpHub.Subscribe(1)
+	sub.Cancel()
+	if len(seen) != 3 {
+		t.Fatalf("instance a kept %d events, want 3", len(seen))
+	}
+	// the client saw the first event on a and reconnects to b
+	sub, replay := b.chirpHub.Subscribe(seen[0].ID)
+	defer sub.Cancel()
+	if len(replay) != 2 || replay[0].ID != seen[1].ID || replay[1].ID != seen[2].ID {
+		t.Errorf("replay on b = %+v, want the last two events seen on a", replay)
+	}
+	if replay[0].Topic != author.String() {
+		t.Errorf("topic = %q, want the author", replay[0].Topic)
+	}
+}
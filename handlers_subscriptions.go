@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	subscriptionActive    = "active"
+	subscriptionPastDue   = "past_due"
+	subscriptionCancelled = "cancelled"
+	subscriptionExpired   = "expired"
+
+	defaultPlan = "red"
+	// subscriptionPeriod is assumed when Polka renews, cancels or fails a
+	// payment without saying when the period ends
+	subscriptionPeriod = 30 * 24 * time.Hour
+)
+
+// openEndedPeriod is the period end of a subscription with no known term:
+// an upgrade Polka sent without current_period_end, which may be the only
+// event it ever sends for that user. Such subscriptions never expire on
+// their own.
+var openEndedPeriod = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+func isOpenEnded(periodEnd time.Time) bool {
+	return !periodEnd.Before(openEndedPeriod)
+}
+
+// Polka subscription events.
+const (
+	polkaUserUpgraded      = "user.upgraded"
+	polkaUserRenewed       = "user.renewed"
+	polkaUserCancelled     = "user.cancelled"
+	polkaUserPaymentFailed = "user.payment_failed"
+	polkaUserDowngraded    = "user.downgraded"
+)
+
+type subscriptionSchema struct {
+	Plan             string  `json:"plan"`
+	Status           string  `json:"status"`
+	CurrentPeriodEnd *string `json:"current_period_end"`
+	CancelledAt      *string `json:"cancelled_at"`
+}
+
+func toSubscriptionSchema(s database.Subscription) subscriptionSchema {
+	resp := subscriptionSchema{
+		Plan:        s.Plan,
+		Status:      s.Status,
+		CancelledAt: nullTimeString(s.CancelledAt),
+	}
+	if !isOpenEnded(s.CurrentPeriodEnd) {
+		periodEnd := s.CurrentPeriodEnd.UTC().Format(time.RFC3339)
+		resp.CurrentPeriodEnd = &periodEnd
+	}
+	return resp
+}
+
+// nextSubscription works out where a Polka event leaves a subscription.
+// Cancelling or a failed payment keep Chirpy Red until the paid period
+// ends; a downgrade ends it straight away. An upgrade without a period end
+// is open-ended, and the first renewal, cancellation or failed payment
+// gives it a term. It returns false when the event has nothing to act on,
+// such as a cancellation for a user who never subscribed, or when it is
+// older than what has already been applied.
+func nextSubscription(userId uuid.UUID, current database.Subscription, exists bool, event polkaEvent, now time.Time) (database.UpsertSubscriptionParams, bool) {
+	next := database.UpsertSubscriptionParams{
+		UserID:           userId,
+		Plan:             current.Plan,
+		Status:           current.Status,
+		CurrentPeriodEnd: current.CurrentPeriodEnd,
+		CancelledAt:      current.CancelledAt,
+		LastEventAt:      current.LastEventAt,
+	}
+	if exists && isStalePolkaEvent(current, event) {
+		return next, false
+	}
+	if event.CreatedAt != nil {
+		next.LastEventAt = sql.NullTime{Time: *event.CreatedAt, Valid: true}
+	}
+	if event.DATA.Plan != "" {
+		next.Plan = event.DATA.Plan
+	}
+	if next.Plan == "" {
+		next.Plan = defaultPlan
+	}
+
+	switch event.EVENT {
+	case polkaUserUpgraded:
+		next.Status = subscriptionActive
+		next.CurrentPeriodEnd = openEndedPeriod
+		next.CancelledAt = sql.NullTime{}
+	case polkaUserRenewed:
+		start := now
+		if exists && current.CurrentPeriodEnd.After(now) && !isOpenEnded(current.CurrentPeriodEnd) {
+			start = current.CurrentPeriodEnd
+		}
+		next.Status = subscriptionActive
+		next.CurrentPeriodEnd = start.Add(subscriptionPeriod)
+		next.CancelledAt = sql.NullTime{}
+	case polkaUserCancelled:
+		if !exists || current.Status == subscriptionExpired {
+			return next, false
+		}
+		next.Status = subscriptionCancelled
+		if !next.CancelledAt.Valid {
+			next.CancelledAt = sql.NullTime{Time: now, Valid: true}
+		}
+		if isOpenEnded(next.CurrentPeriodEnd) {
+			next.CurrentPeriodEnd = now.Add(subscriptionPeriod)
+		}
+	case polkaUserPaymentFailed:
+		if !exists || current.Status != subscriptionActive {
+			return next, false
+		}
+		next.Status = subscriptionPastDue
+		if isOpenEnded(next.CurrentPeriodEnd) {
+			next.CurrentPeriodEnd = now.Add(subscriptionPeriod)
+		}
+	case polkaUserDowngraded:
+		if !exists || current.Status == subscriptionExpired {
+			return next, false
+		}
+		next.Status = subscriptionExpired
+		next.CurrentPeriodEnd = now
+	default:
+		return next, false
+	}
+	if event.DATA.CurrentPeriodEnd != nil && event.EVENT != polkaUserDowngraded {
+		next.CurrentPeriodEnd = *event.DATA.CurrentPeriodEnd
+	}
+	return next, true
+}
+
+// isStalePolkaEvent reports whether event was overtaken by events already
+// applied to current. Polka doesn't promise to deliver in order, and a
+// cancellation that was held up must not undo the renewal that came after
+// it. Events are ordered by when they happened where Polka says so, and
+// otherwise an event for a period ending before the recorded one is
+// assumed to be out of date.
+func isStalePolkaEvent(current database.Subscription, event polkaEvent) bool {
+	if event.CreatedAt != nil && current.LastEventAt.Valid && event.CreatedAt.Before(current.LastEventAt.Time) {
+		return true
+	}
+	periodEnd := event.DATA.CurrentPeriodEnd
+	return periodEnd != nil && !isOpenEnded(current.CurrentPeriodEnd) && periodEnd.Before(current.CurrentPeriodEnd)
+}
+
+// announceChirpyRed tells the user, their live connections and their
+// webhooks that is_chirpy_red changed. Call it after the change commits.
+func (cfg *apiConfig) announceChirpyRed(ctx context.Context, user database.User) {
+	if user.IsChirpyRed {
+		cfg.publishUserEvent(ctx, eventUserUpgraded, user.ID, toUserSchema(user))
+		cfg.emitWebhook(ctx, webhookUserUpgraded, user.ID, toUserSchema(user))
+		cfg.notify(ctx, user.ID, notifyChirpyRedUpgraded, struct{}{})
+		return
+	}
+	cfg.publishUserEvent(ctx, eventUserDowngraded, user.ID, toUserSchema(user))
+	cfg.emitWebhook(ctx, webhookUserDowngraded, user.ID, toUserSchema(user))
+	cfg.notify(ctx, user.ID, notifyChirpyRedEnded, struct{}{})
+}
+
+// expireSubscriptions ends subscriptions whose period has run out without a
+// renewal. It runs until ctx is cancelled; each expiry is only seen by one
+// instance so it's announced once.
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		users, err := cfg.dbQueries.ExpireSubscriptions(ctx)
+		if err != nil {
+			log.Printf("Error expiring subscriptions: %v", err)
+		}
+		for _, user := range users {
+			cfg.announceChirpyRed(ctx, user)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) handleGetSubscription(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeRead)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	subscription, err := cfg.dbQueries.GetSubscriptionByUserId(r.Context(), caller.UserID)
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "No subscription")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	respondWithJSON(w, 200, toSubscriptionSchema(subscription))
+}
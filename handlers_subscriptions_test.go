@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestNextSubscription(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	userId := uuid.New()
+	paidUntil := now.Add(10 * 24 * time.Hour)
+	polkaEnd := now.Add(45 * 24 * time.Hour)
+	cancelledAt := sql.NullTime{Time: now.Add(-time.Hour), Valid: true}
+	subscription := func(status string, periodEnd time.Time) database.Subscription {
+		return database.Subscription{UserID: userId, Plan: "red", Status: status, CurrentPeriodEnd: periodEnd}
+	}
+	event := func(name string, periodEnd *time.Time) polkaEvent {
+		e := polkaEvent{EVENT: name}
+		e.DATA.CurrentPeriodEnd = periodEnd
+		return e
+	}
+
+	cases := []struct {
+		name          string
+		current       database.Subscription
+		exists        bool
+		event         polkaEvent
+		wantOK        bool
+		wantStatus    string
+		wantPeriodEnd time.Time
+		wantCancelled bool
+	}{
+		{
+			name:          "upgrade without a term is open-ended",
+			event:         event(polkaUserUpgraded, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionActive,
+			wantPeriodEnd: openEndedPeriod,
+		},
+		{
+			name:          "upgrade takes Polka's period end",
+			event:         event(polkaUserUpgraded, &polkaEnd),
+			wantOK:        true,
+			wantStatus:    subscriptionActive,
+			wantPeriodEnd: polkaEnd,
+		},
+		{
+			name:          "renewal extends from the current period end",
+			current:       subscription(subscriptionActive, paidUntil),
+			exists:        true,
+			event:         event(polkaUserRenewed, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionActive,
+			wantPeriodEnd: paidUntil.Add(subscriptionPeriod),
+		},
+		{
+			name:          "renewal after the period lapsed starts now",
+			current:       subscription(subscriptionPastDue, now.Add(-time.Hour)),
+			exists:        true,
+			event:         event(polkaUserRenewed, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionActive,
+			wantPeriodEnd: now.Add(subscriptionPeriod),
+		},
+		{
+			name:          "renewal gives an open-ended subscription a term",
+			current:       subscription(subscriptionActive, openEndedPeriod),
+			exists:        true,
+			event:         event(polkaUserRenewed, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionActive,
+			wantPeriodEnd: now.Add(subscriptionPeriod),
+		},
+		{
+			name:          "renewal takes Polka's period end",
+			current:       subscription(subscriptionActive, paidUntil),
+			exists:        true,
+			event:         event(polkaUserRenewed, &polkaEnd),
+			wantOK:        true,
+			wantStatus:    subscriptionActive,
+			wantPeriodEnd: polkaEnd,
+		},
+		{
+			name:          "renewal clears a cancellation",
+			current:       database.Subscription{Plan: "red", Status: subscriptionCancelled, CurrentPeriodEnd: paidUntil, CancelledAt: cancelledAt},
+			exists:        true,
+			event:         event(polkaUserRenewed, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionActive,
+			wantPeriodEnd: paidUntil.Add(subscriptionPeriod),
+		},
+		{
+			name:          "cancellation keeps the paid period",
+			current:       subscription(subscriptionActive, paidUntil),
+			exists:        true,
+			event:         event(polkaUserCancelled, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionCancelled,
+			wantPeriodEnd: paidUntil,
+			wantCancelled: true,
+		},
+		{
+			name:          "cancelling an open-ended subscription gives it one period",
+			current:       subscription(subscriptionActive, openEndedPeriod),
+			exists:        true,
+			event:         event(polkaUserCancelled, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionCancelled,
+			wantPeriodEnd: now.Add(subscriptionPeriod),
+			wantCancelled: true,
+		},
+		{
+			name:  "cancellation without a subscription",
+			event: event(polkaUserCancelled, nil),
+		},
+		{
+			name:    "cancelling an expired subscription",
+			current: subscription(subscriptionExpired, now.Add(-time.Hour)),
+			exists:  true,
+			event:   event(polkaUserCancelled, nil),
+		},
+		{
+			name:          "failed payment keeps the paid period",
+			current:       subscription(subscriptionActive, paidUntil),
+			exists:        true,
+			event:         event(polkaUserPaymentFailed, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionPastDue,
+			wantPeriodEnd: paidUntil,
+		},
+		{
+			name:          "failed payment on an open-ended subscription gives it one period",
+			current:       subscription(subscriptionActive, openEndedPeriod),
+			exists:        true,
+			event:         event(polkaUserPaymentFailed, nil),
+			wantOK:        true,
+			wantStatus:    subscriptionPastDue,
+			wantPeriodEnd: now.Add(subscriptionPeriod),
+		},
+		{
+			name:    "failed payment on a cancelled subscription",
+			current: subscription(subscriptionCancelled, paidUntil),
+			exists:  true,
+			event:   event(polkaUserPaymentFailed, nil),
+		},
+		{
+			name:          "downgrade ends it now, whatever Polka's period end",
+			current:       subscription(subscriptionActive, paidUntil),
+			exists:        true,
+			event:         event(polkaUserDowngraded, &polkaEnd),
+			wantOK:        true,
+			wantStatus:    subscriptionExpired,
+			wantPeriodEnd: now,
+		},
+		{
+			name:    "downgrading an expired subscription",
+			current: subscription(subscriptionExpired, now.Add(-time.Hour)),
+			exists:  true,
+			event:   event(polkaUserDowngraded, nil),
+		},
+		{
+			name:    "unknown event",
+			current: subscription(subscriptionActive, paidUntil),
+			exists:  true,
+			event:   event("user.updated", nil),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			next, ok := nextSubscription(userId, tc.current, tc.exists, tc.event, now)
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if next.UserID != userId || next.Plan != defaultPlan {
+				t.Errorf("user %v plan %q", next.UserID, next.Plan)
+			}
+			if next.Status != tc.wantStatus {
+				t.Errorf("status = %q, want %q", next.Status, tc.wantStatus)
+			}
+			if !next.CurrentPeriodEnd.Equal(tc.wantPeriodEnd) {
+				t.Errorf("period end = %v, want %v", next.CurrentPeriodEnd, tc.wantPeriodEnd)
+			}
+			if next.CancelledAt.Valid != tc.wantCancelled {
+				t.Errorf("cancelled_at = %+v, want set %v", next.CancelledAt, tc.wantCancelled)
+			}
+		})
+	}
+}
+
+func TestNextSubscriptionKeepsCancellationTime(t *testing.T) {
+	now := time.Now()
+	cancelledAt := sql.NullTime{Time: now.Add(-time.Hour), Valid: true}
+	current := database.Subscription{Plan: "red", Status: subscriptionCancelled, CurrentPeriodEnd: now.Add(time.Hour), CancelledAt: cancelledAt}
+	next, ok := nextSubscription(uuid.New(), current, true, polkaEvent{EVENT: polkaUserCancelled}, now)
+	if !ok || !next.CancelledAt.Time.Equal(cancelledAt.Time) {
+		t.Errorf("a repeated cancellation moved cancelled_at to %+v", next.CancelledAt)
+	}
+}
+
+func TestNextSubscriptionIgnoresReorderedEvents(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	userId := uuid.New()
+	paidUntil := now.Add(10 * 24 * time.Hour)
+	current := database.Subscription{UserID: userId, Plan: "red", Status: subscriptionActive, CurrentPeriodEnd: paidUntil}
+	// apply moves current along the way applyPolkaEvent's upsert would
+	apply := func(event polkaEvent) bool {
+		next, ok := nextSubscription(userId, current, true, event, now)
+		if ok {
+			current = database.Subscription{
+				UserID:           next.UserID,
+				Plan:             next.Plan,
+				Status:           next.Status,
+				CurrentPeriodEnd: next.CurrentPeriodEnd,
+				CancelledAt:      next.CancelledAt,
+				LastEventAt:      next.LastEventAt,
+			}
+		}
+		return ok
+	}
+
+	cancelledAt, renewedAt := now.Add(-2*time.Hour), now.Add(-time.Hour)
+	renewal := polkaEvent{EVENT: polkaUserRenewed, CreatedAt: &renewedAt}
+	cancellation := polkaEvent{EVENT: polkaUserCancelled, CreatedAt: &cancelledAt}
+	if !apply(renewal) {
+		t.Fatal("renewal was not applied")
+	}
+	if apply(cancellation) {
+		t.Error("a cancellation from before the renewal was applied")
+	}
+	if current.Status != subscriptionActive || current.CancelledAt.Valid {
+		t.Errorf("status = %q, cancelled_at = %+v, want the renewal to stand", current.Status, current.CancelledAt)
+	}
+	if !current.LastEventAt.Time.Equal(renewedAt) {
+		t.Errorf("last_event_at = %+v, want the renewal's", current.LastEventAt)
+	}
+
+	// without timestamps, a cancellation for the period that has since been
+	// renewed past is just as stale
+	renewedEnd := current.CurrentPeriodEnd
+	stale := polkaEvent{EVENT: polkaUserCancelled}
+	stale.DATA.CurrentPeriodEnd = &paidUntil
+	if apply(stale) {
+		t.Error("a cancellation for the previous period was applied")
+	}
+	if current.Status != subscriptionActive || !current.CurrentPeriodEnd.Equal(renewedEnd) {
+		t.Errorf("status = %q, period end = %v, want active until %v", current.Status, current.CurrentPeriodEnd, renewedEnd)
+	}
+
+	laterAt := now
+	later := polkaEvent{EVENT: polkaUserCancelled, CreatedAt: &laterAt}
+	if !apply(later) || current.Status != subscriptionCancelled {
+		t.Errorf("a cancellation after the renewal was not applied, status = %q", current.Status)
+	}
+}
+
+func TestSubscriptionSchemaHidesOpenEndedPeriod(t *testing.T) {
+	resp := toSubscriptionSchema(database.Subscription{Plan: "red", Status: subscriptionActive, CurrentPeriodEnd: openEndedPeriod})
+	if resp.CurrentPeriodEnd != nil {
+		t.Errorf("current_period_end = %q, want null", *resp.CurrentPeriodEnd)
+	}
+}
+
+func TestOpenEndedSubscriptionDoesNotExpire(t *testing.T) {
+	cfg := testDBConfig(t)
+	cfg.polkaSecrets = []string{testPolkaSecret}
+	user, _ := createTestUser(t, cfg)
+	body := `{"event":"user.upgraded","data":{"user_id":"` + user.ID.String() + `"}}`
+	if rec := polkaRequest(cfg, body, time.Now()); rec.Code != 204 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	expired, err := cfg.dbQueries.ExpireSubscriptions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 0 {
+		t.Errorf("expired %d users", len(expired))
+	}
+	got, err := cfg.dbQueries.GetUserById(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsChirpyRed {
+		t.Error("upgraded user lost Chirpy Red")
+	}
+}
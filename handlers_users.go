@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -14,11 +15,35 @@ import (
 )
 
 type users struct {
-	ID            string `json:"id"`
-	EMAIL         string `json:"email"`
-	UPDATED_AT    string `json:"updated_at"`
-	CREATED_AT    string `json:"created_at"`
-	IS_CHIRPY_RED bool   `json:"is_chirpy_red"`
+	ID              string `json:"id"`
+	EMAIL           string `json:"email"`
+	UPDATED_AT      string `json:"updated_at"`
+	CREATED_AT      string `json:"created_at"`
+	IS_CHIRPY_RED   bool   `json:"is_chirpy_red"`
+	EMAIL_VERIFIED  bool   `json:"email_verified"`
+	ROLE            string `json:"role"`
+	USERNAME        string `json:"username"`
+	DISPLAY_NAME    string `json:"display_name"`
+	BIO             string `json:"bio"`
+	AVATAR_URL      string `json:"avatar_url"`
+	ACCEPT_MESSAGES string `json:"accept_messages"`
+}
+
+func toUserSchema(user database.User) users {
+	return users{
+		ID:              user.ID.String(),
+		EMAIL:           user.Email,
+		CREATED_AT:      user.CreatedAt.String(),
+		UPDATED_AT:      user.UpdatedAt.String(),
+		IS_CHIRPY_RED:   user.IsChirpyRed,
+		EMAIL_VERIFIED:  user.EmailVerifiedAt.Valid,
+		ROLE:            user.Role,
+		USERNAME:        user.Username.String,
+		DISPLAY_NAME:    user.DisplayName,
+		BIO:             user.Bio,
+		AVATAR_URL:      user.AvatarUrl,
+		ACCEPT_MESSAGES: user.AcceptMessages,
+	}
 }
 
 func (cfg *apiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
@@ -38,10 +63,17 @@ func (cfg *apiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		responsdWithError(w, 400, "missing email field")
 		return
 	}
+	if !validateEmail(params.Email) {
+		responsdWithError(w, 400, "invalid email address")
+		return
+	}
 	if params.Password == "" {
 		responsdWithError(w, 400, "missing password field")
 		return
 	}
+	if !cfg.checkPasswordPolicy(w, params.Password, params.Email) {
+		return
+	}
 	hashPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
 		log.Fatalf("Error hashing password: %v", err)
@@ -57,13 +89,10 @@ func (cfg *apiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		responsdWithError(w, 500, fmt.Sprintf("Error creating user %v", err))
 		return
 	}
-	usr := users{
-		ID:         user.ID.String(),
-		EMAIL:      user.Email,
-		CREATED_AT: user.CreatedAt.String(),
-		UPDATED_AT: user.UpdatedAt.String(),
+	if err := cfg.sendEmailVerification(r.Context(), user.ID, user.Email); err != nil {
+		log.Printf("Error sending verification email: %v", err)
 	}
-	respondWithJSON(w, 201, usr)
+	respondWithJSON(w, 201, toUserSchema(user))
 }
 
 func (cfg *apiConfig) handleUserLogin(w http.ResponseWriter, r *http.Request) {
@@ -77,11 +106,15 @@ func (cfg *apiConfig) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 		responsdWithError(w, 500, fmt.Sprintf("Error decoding body: %v", err))
 		return
 	}
-	expiresDuration := 3600 * time.Second
+	accountKey := accountThrottleKey(params.Email)
+	if !cfg.checkLoginThrottle(w, r, accountKey) {
+		return
+	}
 
 	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), params.Email)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			cfg.recordLoginFailure(r.Context(), accountKey, clientIP(r))
 			responsdWithError(w, 401, "Incorrect email or password")
 			return
 		}
@@ -94,38 +127,113 @@ func (cfg *apiConfig) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if !passwordMatch {
+		cfg.recordLoginFailure(r.Context(), accountKey, clientIP(r))
 		responsdWithError(w, 401, "Incorrect email or password")
 		return
 	}
-	accessToken, err := auth.MakeJWT(user.ID, cfg.jwtKey, expiresDuration)
+	cfg.recordLoginSuccess(r.Context(), accountKey)
+	if auth.NeedsRehash(user.HashedPassword) {
+		cfg.upgradePasswordHash(r.Context(), user.ID, params.Password)
+	}
+	cfg.completeLogin(w, r, user)
+}
+
+// completeLogin finishes a first factor login, by password or an external
+// identity. Users with a second factor get a challenge to answer at
+// /api/login/mfa instead of a session.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	mfaRequired, err := cfg.userHasMFA(r.Context(), user.ID)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Database Error: %v", err))
+		return
+	}
+	if mfaRequired {
+		mfaToken, err := auth.MakeMFAChallengeJWT(user.ID, cfg.jwtKey, mfaChallengeDuration)
+		if err != nil {
+			responsdWithError(w, 500, fmt.Sprintf("Error creating token: %v", err))
+			return
+		}
+		resp := struct {
+			MfaRequired bool   `json:"mfa_required"`
+			MfaToken    string `json:"mfa_token"`
+		}{
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		}
+		respondWithJSON(w, 200, resp)
+		return
+	}
+	cfg.respondWithSession(w, r, user)
+}
+
+// upgradePasswordHash re-hashes a password that was stored with weaker
+// Argon2 parameters. Failing here shouldn't fail the login, so errors are
+// only logged.
+func (cfg *apiConfig) upgradePasswordHash(ctx context.Context, userId uuid.UUID, password string) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Printf("Error upgrading password hash: %v", err)
+		return
+	}
+	_, err = cfg.dbQueries.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		HashedPassword: hash,
+		ID:             userId,
+	})
+	if err != nil {
+		log.Printf("Error upgrading password hash: %v", err)
+	}
+}
+
+// respondWithSession issues a fresh access and refresh token pair for a user
+// who has fully authenticated.
+func (cfg *apiConfig) respondWithSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	if user.DeletedAt.Valid {
+		// logging in during the grace period cancels the deletion
+		restored, err := cfg.dbQueries.RestoreUser(r.Context(), user.ID)
+		if err != nil {
+			responsdWithError(w, 500, fmt.Sprintf("Database Error: %v", err))
+			return
+		}
+		user = restored
+	}
+	expiresDuration := 3600 * time.Second
+	accessToken, err := auth.MakeJWT(user.ID, user.Role, cfg.jwtKey, expiresDuration)
 	if err != nil {
 		responsdWithError(w, 500, fmt.Sprintf("Error creating token: %v", err))
+		return
 	}
 
 	refreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
 		responsdWithError(w, 500, fmt.Sprintf("Error creating token: %v", err))
+		return
 	}
 	tokenPramas := database.InsertRefreshTokenParams{
 		Token:     refreshToken,
 		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(60 * time.Hour * 24),
+		ExpiresAt: time.Now().Add(entitlementsOf(user).RefreshTokenLifetime),
 	}
 
 	_, err = cfg.dbQueries.InsertRefreshToken(r.Context(), tokenPramas)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error creating token: %v", err))
+		return
+	}
+
+	cfg.notify(r.Context(), user.ID, notifyNewLogin, struct {
+		IP        string `json:"ip"`
+		UserAgent string `json:"user_agent"`
+	}{
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
 
 	usr := struct {
 		users
 		AccessToken  string `json:"token"`
 		RefreshToken string `json:"refresh_token"`
 	}{
-		users: users{
-			ID:            user.ID.String(),
-			EMAIL:         user.Email,
-			CREATED_AT:    user.CreatedAt.String(),
-			UPDATED_AT:    user.UpdatedAt.String(),
-			IS_CHIRPY_RED: user.IsChirpyRed,
-		},
+		users:        toUserSchema(user),
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 	}
@@ -133,19 +241,21 @@ func (cfg *apiConfig) handleUserLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *apiConfig) handleUpdateUserInfo(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
 	if err != nil {
-		responsdWithError(w, 401, err.Error())
+		respondWithAuthError(w, err)
 		return
 	}
-	userId, err := auth.ValidateJwt(token, cfg.jwtKey)
-	if err != nil {
-		responsdWithError(w, 401, err.Error())
+	// this replaces both the email and the password, so it is kept to the
+	// user's own logins rather than keys and apps acting for them
+	if caller.Credential != credentialSession {
+		responsdWithError(w, 403, "email and password can only be changed from a login session")
 		return
 	}
 	type paramters struct {
-		EMAIL    string `json:"email"`
-		PASSWORD string `json:"password"`
+		EMAIL           string `json:"email"`
+		PASSWORD        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
 	}
 	parmas := paramters{}
 	decoder := json.NewDecoder(r.Body)
@@ -157,76 +267,73 @@ func (cfg *apiConfig) handleUpdateUserInfo(w http.ResponseWriter, r *http.Reques
 		responsdWithError(w, 400, "Bad Request")
 		return
 	}
-	hashedPassword, err := auth.HashPassword(parmas.PASSWORD)
-	if err != nil {
-		responsdWithError(w, 500, "Internal Server Error")
+	if parmas.CurrentPassword == "" {
+		responsdWithError(w, 400, "current_password is required to change the email or password")
 		return
 	}
-	userUpdateParams := database.UpdateUserByIdParams{
-		ID:             userId,
-		Email:          parmas.EMAIL,
-		HashedPassword: hashedPassword,
+	if !validateEmail(parmas.EMAIL) {
+		responsdWithError(w, 400, "invalid email address")
+		return
 	}
-	user, err := cfg.dbQueries.UpdateUserById(r.Context(), userUpdateParams)
+	current, err := cfg.dbQueries.GetUserById(r.Context(), caller.UserID)
 	if err != nil {
 		responsdWithError(w, 500, "Internal Server Error")
 		return
 	}
-	usr := users{
-		ID:            user.ID.String(),
-		EMAIL:         user.Email,
-		UPDATED_AT:    user.UpdatedAt.String(),
-		CREATED_AT:    user.CreatedAt.String(),
-		IS_CHIRPY_RED: user.IsChirpyRed,
+	if !cfg.checkCurrentPassword(w, r, current, parmas.CurrentPassword) {
+		return
 	}
-	respondWithJSON(w, 200, usr)
-}
-
-func (cfg *apiConfig) handleUpdateUserToChirpyRed(w http.ResponseWriter, r *http.Request) {
-	apiKey, err := auth.GetAPIKey(r.Header)
-	if err != nil {
-		responsdWithError(w, 400, err.Error())
+	// a new address only replaces the current one once it's been verified
+	emailChanged := parmas.EMAIL != current.Email
+	if !cfg.checkPasswordPolicy(w, parmas.PASSWORD, parmas.EMAIL) {
 		return
 	}
-	if apiKey != cfg.polkaKey {
-		responsdWithError(w, 401, "Unauthorized")
+	hashedPassword, err := auth.HashPassword(parmas.PASSWORD)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
 		return
 	}
-	type parameters struct {
-		EVENT string `json:"event"`
-		DATA  struct {
-			USER_ID string `json:"user_id"`
-		}
+	userUpdateParams := database.UpdateUserByIdParams{
+		ID:             caller.UserID,
+		Email:          current.Email,
+		HashedPassword: hashedPassword,
 	}
-	params := parameters{}
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&params); err != nil {
-		responsdWithError(w, 500, fmt.Sprintf("Error decoding body: %v", err))
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
 		return
 	}
-
-	if params.EVENT != "user.upgraded" {
-		respondWithJSON(w, 204, struct{}{})
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
+	user, err := qtx.UpdateUserById(r.Context(), userUpdateParams)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
 		return
 	}
-	userId, err := uuid.Parse(params.DATA.USER_ID)
-	if err != nil {
-		responsdWithError(w, 500, "Internal server error")
+	if err := revokeAllUserCredentials(r.Context(), qtx, user.ID); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
 		return
 	}
-
-	dbParams := database.UpdateUserToChirpyRedParams{
-		ID:          userId,
-		IsChirpyRed: true,
+	if err := tx.Commit(); err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
 	}
-	_, err = cfg.dbQueries.UpdateUserToChirpyRed(r.Context(), dbParams)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			responsdWithError(w, 404, "User Not Found")
-			return
+	cfg.notify(r.Context(), user.ID, notifyPasswordChanged, struct{}{})
+	// the password change has already happened, so a mail failure is logged
+	// rather than reported as a failed update; the user can ask again
+	if emailChanged {
+		if err := cfg.sendEmailVerification(r.Context(), user.ID, parmas.EMAIL); err != nil {
+			log.Printf("Error sending verification email: %v", err)
 		}
-		responsdWithError(w, 500, "Internal Server error")
-		return
 	}
-	respondWithJSON(w, 204, struct{}{})
+	resp := struct {
+		users
+		PendingEmail string `json:"pending_email,omitempty"`
+	}{
+		users: toUserSchema(user),
+	}
+	if emailChanged {
+		resp.PendingEmail = parmas.EMAIL
+	}
+	respondWithJSON(w, 200, resp)
 }
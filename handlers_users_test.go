@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+)
+
+func TestUpdateUserInfoEmailChange(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	mail := cfg.mailer.(*testMailer)
+
+	update := func(email string) pendingEmailResponse {
+		t.Helper()
+		rec := do(t, cfg.handleUpdateUserInfo, "PUT", "/api/users", token, map[string]string{
+			"email":            email,
+			"password":         "correct horse battery",
+			"current_password": "correct horse battery",
+		})
+		if rec.Code != 200 {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+		var resp pendingEmailResponse
+		decodeJSON(t, rec, &resp)
+		return resp
+	}
+
+	if resp := update("first@example.com"); resp.Email != user.Email || resp.PendingEmail != "first@example.com" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	update("second@example.com")
+	sent := mail.messages()
+	if len(sent) != 2 {
+		t.Fatalf("sent = %+v", sent)
+	}
+	confirm := func(token string) int {
+		return do(t, cfg.handleConfirmEmailVerification, "POST", "/api/email-verification/confirm", "", map[string]string{"token": token}).Code
+	}
+	if code := confirm(mailedToken(t, sent[0])); code != 401 {
+		t.Errorf("superseded token: status = %d, want 401", code)
+	}
+	if code := confirm(mailedToken(t, sent[1])); code != 200 {
+		t.Errorf("latest token: status = %d, want 200", code)
+	}
+}
+
+func TestUpdateUserInfoMailFailure(t *testing.T) {
+	cfg := testDBConfig(t)
+	_, token := createTestUser(t, cfg)
+	cfg.mailer.(*testMailer).err = errors.New("smtp down")
+
+	rec := do(t, cfg.handleUpdateUserInfo, "PUT", "/api/users", token, map[string]string{
+		"email":            "new@example.com",
+		"password":         "another fine passphrase",
+		"current_password": "correct horse battery",
+	})
+	if rec.Code != 200 {
+		t.Errorf("status = %d, want 200 since the password was changed: %s", rec.Code, rec.Body)
+	}
+}
+
+type pendingEmailResponse struct {
+	Email        string `json:"email"`
+	PendingEmail string `json:"pending_email"`
+}
+
+func TestUpdateUserInfoRequiresSession(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	key := createTestAPIKey(t, cfg, user.ID, []string{auth.ScopeProfileWrite})
+
+	rec := do(t, cfg.handleUpdateUserInfo, "PUT", "/api/users", key, map[string]string{
+		"email":            "attacker@example.com",
+		"password":         "another fine passphrase",
+		"current_password": "correct horse battery",
+	})
+	if rec.Code != 403 {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestUpdateUserInfoNeedsCurrentPassword(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+
+	tests := []struct {
+		name    string
+		current string
+		want    int
+	}{
+		{"missing", "", 400},
+		{"wrong", "guess", 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, cfg.handleUpdateUserInfo, "PUT", "/api/users", token, map[string]string{
+				"email":            "attacker@example.com",
+				"password":         "another fine passphrase",
+				"current_password": tt.current,
+			})
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+	got, err := cfg.dbQueries.GetUserById(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match, _ := auth.CheckPasswordHash("correct horse battery", got.HashedPassword); !match {
+		t.Error("password changed without the current one")
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+// Webhook event types. Endpoints pick which of these they receive.
+const (
+	webhookChirpCreated   = "chirp.created"
+	webhookChirpDeleted   = "chirp.deleted"
+	webhookUserUpgraded   = "user.upgraded"
+	webhookUserDowngraded = "user.downgraded"
+)
+
+var webhookEventTypes = []string{
+	webhookChirpCreated,
+	webhookChirpDeleted,
+	webhookUserUpgraded,
+	webhookUserDowngraded,
+}
+
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+
+	// maxDeliveryAttempts keeps retrying for about fifteen hours
+	maxDeliveryAttempts = 12
+	deliveryBatchSize   = 20
+	// deliveryLease is how long a claimed delivery is left alone before
+	// another worker may retry it, well past the sender's timeout
+	deliveryLease = time.Minute
+
+	defaultDeliveryPageSize = 50
+)
+
+type webhookEndpointSchema struct {
+	ID         string   `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Global     bool     `json:"global"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+func toWebhookEndpointSchema(endpoint database.WebhookEndpoint) webhookEndpointSchema {
+	return webhookEndpointSchema{
+		ID:         endpoint.ID.String(),
+		URL:        endpoint.Url,
+		EventTypes: endpoint.EventTypes,
+		Global:     endpoint.Global,
+		CreatedAt:  endpoint.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+type webhookDeliverySchema struct {
+	ID             string          `json:"id"`
+	EventID        string          `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int32           `json:"attempts"`
+	NextAttemptAt  *string         `json:"next_attempt_at"`
+	LastAttemptAt  *string         `json:"last_attempt_at"`
+	ResponseStatus *int32          `json:"response_status"`
+	LastError      *string         `json:"last_error"`
+	CreatedAt      string          `json:"created_at"`
+}
+
+func toWebhookDeliverySchema(d database.WebhookDelivery) webhookDeliverySchema {
+	resp := webhookDeliverySchema{
+		ID:            d.ID.String(),
+		EventID:       d.EventID.String(),
+		EventType:     d.EventType,
+		Payload:       d.Payload,
+		Status:        d.Status,
+		Attempts:      d.Attempts,
+		LastAttemptAt: nullTimeString(d.LastAttemptAt),
+		CreatedAt:     d.CreatedAt.UTC().Format(time.RFC3339Nano),
+	}
+	if d.Status == deliveryPending {
+		resp.NextAttemptAt = nullTimeString(sql.NullTime{Time: d.NextAttemptAt, Valid: true})
+	}
+	if d.ResponseStatus.Valid {
+		resp.ResponseStatus = &d.ResponseStatus.Int32
+	}
+	if d.LastError.Valid {
+		resp.LastError = &d.LastError.String
+	}
+	return resp
+}
+
+// emitWebhook queues kind for every endpoint that wants it: the user's own
+// endpoints and any global ones. The deliveries are stored before anything
+// is sent so they survive a restart. Failures are logged, like notify.
+func (cfg *apiConfig) emitWebhook(ctx context.Context, kind string, userId uuid.UUID, data any) {
+	eventId := uuid.New()
+	body, err := json.Marshal(struct {
+		ID        uuid.UUID `json:"id"`
+		Type      string    `json:"type"`
+		CreatedAt string    `json:"created_at"`
+		Data      any       `json:"data"`
+	}{
+		ID:        eventId,
+		Type:      kind,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Data:      data,
+	})
+	if err != nil {
+		log.Printf("Error encoding %s webhook: %v", kind, err)
+		return
+	}
+	n, err := cfg.dbQueries.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:   eventId,
+		EventType: kind,
+		Payload:   body,
+		UserID:    userId,
+	})
+	if err != nil {
+		log.Printf("Error queueing %s webhook: %v", kind, err)
+		return
+	}
+	if n > 0 {
+		cfg.wakeWebhookWorker()
+	}
+}
+
+func (cfg *apiConfig) wakeWebhookWorker() {
+	select {
+	case cfg.webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverWebhooks sends queued deliveries until ctx is cancelled. It polls
+// every interval so deliveries queued by other instances, and retries,
+// still go out; emitWebhook wakes it early for new ones. Several instances
+// can run it at once, a claimed delivery is leased to one of them.
+func (cfg *apiConfig) deliverWebhooks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for {
+			claimed, err := cfg.dbQueries.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+				Limit:      deliveryBatchSize,
+				LeaseUntil: time.Now().Add(deliveryLease),
+			})
+			if err != nil {
+				log.Printf("Error claiming webhook deliveries: %v", err)
+				break
+			}
+			var wg sync.WaitGroup
+			for _, delivery := range claimed {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					cfg.attemptDelivery(ctx, delivery)
+				}()
+			}
+			wg.Wait()
+			if len(claimed) < deliveryBatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.webhookWake:
+		}
+	}
+}
+
+func (cfg *apiConfig) attemptDelivery(ctx context.Context, delivery database.ClaimWebhookDeliveriesRow) {
+	// the owner of a global endpoint may have been demoted since the
+	// delivery was queued, and must not see other users' events any more
+	if delivery.Global && !auth.RoleAtLeast(delivery.OwnerRole, auth.RoleAdmin) {
+		err := cfg.dbQueries.RecordWebhookAttempt(ctx, database.RecordWebhookAttemptParams{
+			ID:            delivery.ID,
+			Status:        deliveryFailed,
+			NextAttemptAt: time.Now(),
+			LastError:     sql.NullString{String: "endpoint owner is no longer an admin", Valid: true},
+		})
+		if err != nil {
+			log.Printf("Error recording webhook delivery %s: %v", delivery.ID, err)
+		}
+		return
+	}
+	result, err := cfg.webhookSender.Send(ctx, webhooks.Delivery{
+		ID:     delivery.ID.String(),
+		Event:  delivery.EventType,
+		URL:    delivery.Url,
+		Secret: delivery.Secret,
+		Body:   delivery.Payload,
+	})
+	attempts := int(delivery.Attempts) + 1
+	params := database.RecordWebhookAttemptParams{
+		ID:            delivery.ID,
+		Status:        deliverySucceeded,
+		NextAttemptAt: time.Now(),
+		ResponseStatus: sql.NullInt32{
+			Int32: int32(result.StatusCode),
+			Valid: result.StatusCode != 0,
+		},
+	}
+	if err != nil {
+		params.LastError = sql.NullString{String: err.Error(), Valid: true}
+		params.Status = deliveryPending
+		params.NextAttemptAt = time.Now().Add(webhooks.Backoff(attempts))
+		if attempts >= maxDeliveryAttempts {
+			params.Status = deliveryFailed
+		}
+	}
+	if err := cfg.dbQueries.RecordWebhookAttempt(ctx, params); err != nil {
+		log.Printf("Error recording webhook delivery %s: %v", delivery.ID, err)
+	}
+}
+
+// isValidWebhookURL turns away URLs that plainly point inside the network.
+// Hostnames can resolve anywhere, so the sender checks every address it
+// dials as well.
+func isValidWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.User != nil {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return webhooks.IsPublicAddr(addr)
+	}
+	return true
+}
+
+// handleCreateWebhook registers an endpoint. The signing secret is only
+// returned here; receivers use it to check the Chirpy-Signature header.
+// Admins may register global endpoints that hear about every user.
+func (cfg *apiConfig) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	type parameters struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Global     bool     `json:"global"`
+	}
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		responsdWithError(w, 400, fmt.Sprintf("Error decoding body: %v", err))
+		return
+	}
+	if !isValidWebhookURL(params.URL) {
+		responsdWithError(w, 400, "url must be an http or https URL on a public host")
+		return
+	}
+	if len(params.EventTypes) == 0 {
+		responsdWithError(w, 400, "missing event_types field")
+		return
+	}
+	for _, kind := range params.EventTypes {
+		if !slices.Contains(webhookEventTypes, kind) {
+			responsdWithError(w, 400, fmt.Sprintf("unknown event type %q", kind))
+			return
+		}
+	}
+	if params.Global && !auth.RoleAtLeast(caller.Role, auth.RoleAdmin) {
+		responsdWithError(w, 403, "only admins can create global webhooks")
+		return
+	}
+	secret, err := webhooks.MakeSecret()
+	if err != nil {
+		responsdWithError(w, 500, "Error creating webhook")
+		return
+	}
+	slices.Sort(params.EventTypes)
+	endpoint, err := cfg.dbQueries.CreateWebhookEndpoint(r.Context(), database.CreateWebhookEndpointParams{
+		UserID:     caller.UserID,
+		Url:        params.URL,
+		Secret:     secret,
+		EventTypes: slices.Compact(params.EventTypes),
+		Global:     params.Global,
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error creating webhook: %v", err))
+		return
+	}
+	resp := struct {
+		webhookEndpointSchema
+		Secret string `json:"secret"`
+	}{
+		webhookEndpointSchema: toWebhookEndpointSchema(endpoint),
+		Secret:                secret,
+	}
+	respondWithJSON(w, 201, resp)
+}
+
+func (cfg *apiConfig) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	endpoints, err := cfg.dbQueries.ListWebhookEndpoints(r.Context(), caller.UserID)
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting webhooks: %v", err))
+		return
+	}
+	resp := make([]webhookEndpointSchema, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		resp = append(resp, toWebhookEndpointSchema(endpoint))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+func (cfg *apiConfig) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return
+	}
+	endpointId, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid webhook_id format")
+		return
+	}
+	result, err := cfg.dbQueries.DeleteWebhookEndpoint(r.Context(), database.DeleteWebhookEndpointParams{
+		ID:     endpointId,
+		UserID: caller.UserID,
+	})
+	respondAffected(w, result, err, "Webhook not found")
+}
+
+// handleListWebhookDeliveries is the delivery log for one endpoint, newest
+// first, paged with ?before= like notifications.
+func (cfg *apiConfig) handleListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.loadWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	before := uuid.NullUUID{}
+	if raw := query.Get("before"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			responsdWithError(w, 400, "Invalid before format")
+			return
+		}
+		before = uuid.NullUUID{UUID: id, Valid: true}
+	}
+	limits, err := cfg.entitlementsFor(r.Context(), endpoint.UserID)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	limit := defaultDeliveryPageSize
+	if raw := query.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > limits.MaxPageSize {
+			responsdWithError(w, 400, fmt.Sprintf("limit must be between 1 and %d", limits.MaxPageSize))
+			return
+		}
+	}
+	deliveries, err := cfg.dbQueries.ListWebhookDeliveries(r.Context(), database.ListWebhookDeliveriesParams{
+		EndpointID: endpoint.ID,
+		Before:     before,
+		Limit:      int32(limit),
+	})
+	if err != nil {
+		responsdWithError(w, 500, fmt.Sprintf("Error getting deliveries: %v", err))
+		return
+	}
+	resp := make([]webhookDeliverySchema, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resp = append(resp, toWebhookDeliverySchema(delivery))
+	}
+	respondWithJSON(w, 200, resp)
+}
+
+// handleRedeliverWebhook queues a fresh copy of a past delivery, keeping
+// the same event id so receivers can tell it's a repeat. The original stays
+// in the log as it was.
+func (cfg *apiConfig) handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	endpoint, ok := cfg.loadWebhookEndpoint(w, r)
+	if !ok {
+		return
+	}
+	deliveryId, err := uuid.Parse(r.PathValue("deliveryID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid delivery_id format")
+		return
+	}
+	delivery, err := cfg.dbQueries.RedeliverWebhook(r.Context(), database.RedeliverWebhookParams{
+		ID:         deliveryId,
+		EndpointID: endpoint.ID,
+	})
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "Delivery not found")
+		return
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return
+	}
+	cfg.wakeWebhookWorker()
+	respondWithJSON(w, 202, toWebhookDeliverySchema(delivery))
+}
+
+func (cfg *apiConfig) loadWebhookEndpoint(w http.ResponseWriter, r *http.Request) (database.WebhookEndpoint, bool) {
+	caller, err := cfg.authorize(r, auth.ScopeProfileWrite)
+	if err != nil {
+		respondWithAuthError(w, err)
+		return database.WebhookEndpoint{}, false
+	}
+	endpointId, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		responsdWithError(w, 400, "Invalid webhook_id format")
+		return database.WebhookEndpoint{}, false
+	}
+	endpoint, err := cfg.dbQueries.GetWebhookEndpoint(r.Context(), database.GetWebhookEndpointParams{
+		ID:     endpointId,
+		UserID: caller.UserID,
+	})
+	if err == sql.ErrNoRows {
+		responsdWithError(w, 404, "Webhook not found")
+		return database.WebhookEndpoint{}, false
+	}
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return database.WebhookEndpoint{}, false
+	}
+	return endpoint, true
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/pubsub"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsPingInterval = 30 * time.Second
+	// wsReadTimeout leaves room for one missed pong before giving up
+	wsReadTimeout = 2*wsPingInterval + 10*time.Second
+	// wsSendQueue is how many outgoing messages may wait for a slow client
+	// before the connection is dropped
+	wsSendQueue        = 64
+	maxWsSubscriptions = 50
+	// wsAuthTimeout is how long a connection opened without a token has to
+	// send its authenticate message
+	wsAuthTimeout  = 10 * time.Second
+	wsWriteTimeout = 10 * time.Second
+	wsReadLimit    = 64 << 10
+)
+
+var wsUpgrader = websocket.Upgrader{
+	// connections authenticate with a bearer token rather than cookies, so
+	// a page on another origin gains nothing by opening one
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+const (
+	wsChannelTimeline     = "timeline"
+	wsChannelUser         = "user"
+	wsChannelAuthorPrefix = "author:"
+	wsChannelChirpPrefix  = "chirp:"
+)
+
+// wsClientMessage is everything a client can send:
+//
+//	{"type": "subscribe", "id": "a", "channel": "author:<uuid>"}
+//	{"type": "unsubscribe", "id": "a"}
+//	{"type": "ping"}
+//	{"type": "authenticate", "token": "<jwt>"}
+//
+// Channels are "timeline", "author:<user id>", "chirp:<chirp id>" and
+// "user" for the caller's own notifications and messages. Sending a fresh
+// token with "authenticate" keeps the connection open past the expiry of
+// the one used to connect. Connections opened without an Authorization
+// header must send "authenticate" first.
+type wsClientMessage struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Channel string `json:"channel"`
+	Token   string `json:"token"`
+}
+
+type wsServerMessage struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Event   string          `json:"event,omitempty"`
+	EventID uint64          `json:"event_id,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+type wsSession struct {
+	conn   *websocket.Conn
+	userId uuid.UUID
+	// blocked and muted are authors whose chirps the user doesn't get,
+	// taken when the connection opens
+	blocked map[string]bool
+	muted   map[string]bool
+
+	out       chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	expiry *time.Timer
+
+	mu   sync.Mutex
+	subs map[string]string
+}
+
+// handleWebSocket upgrades to a WebSocket that multiplexes live chirp
+// feeds and the caller's own events. The access token comes from the
+// Authorization header, or for browsers that can't set headers on the
+// handshake, from an authenticate message sent as soon as it opens. Tokens
+// are never taken from the URL, where they'd end up in access logs.
+func (cfg *apiConfig) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	var userId uuid.UUID
+	var expiresAt time.Time
+	authenticated := r.Header.Get("Authorization") != ""
+	if authenticated {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+		userId, expiresAt, err = cfg.wsAuthenticate(r.Context(), token)
+		if err != nil {
+			respondWithAuthError(w, err)
+			return
+		}
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already answered
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(wsReadLimit)
+	if !authenticated {
+		userId, expiresAt, err = cfg.wsFirstMessageAuth(r.Context(), conn)
+		if err != nil {
+			wsCloseWith(conn, websocket.ClosePolicyViolation, err.Error())
+			return
+		}
+	}
+
+	blocked, err := cfg.hiddenAuthors(r, uuid.NullUUID{UUID: userId, Valid: true}, false)
+	if err != nil {
+		wsCloseWith(conn, websocket.CloseInternalServerErr, "")
+		return
+	}
+	mutes, err := cfg.dbQueries.ListMutedUsers(r.Context(), userId)
+	if err != nil {
+		wsCloseWith(conn, websocket.CloseInternalServerErr, "")
+		return
+	}
+	muted := map[string]bool{}
+	for _, mute := range mutes {
+		muted[mute.MutedID.String()] = true
+	}
+
+	s := &wsSession{
+		conn:    conn,
+		userId:  userId,
+		blocked: blocked,
+		muted:   muted,
+		out:     make(chan []byte, wsSendQueue),
+		done:    make(chan struct{}),
+		subs:    map[string]string{},
+		expiry:  time.NewTimer(time.Until(expiresAt)),
+	}
+	defer s.expiry.Stop()
+
+	chirps, _ := cfg.chirpHub.Subscribe(0)
+	defer chirps.Cancel()
+	personal, _ := cfg.userHub.Subscribe(0)
+	defer personal.Cancel()
+
+	go s.writeLoop()
+	go s.readLoop(r.Context(), cfg)
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.expiry.C:
+			s.close(websocket.ClosePolicyViolation, "token expired")
+			return
+		case event, ok := <-chirps.C:
+			if !ok {
+				s.close(websocket.CloseTryAgainLater, "fell behind")
+				return
+			}
+			s.dispatchChirp(event)
+		case event, ok := <-personal.C:
+			if !ok {
+				s.close(websocket.CloseTryAgainLater, "fell behind")
+				return
+			}
+			if event.Topic == s.userId.String() {
+				s.dispatch(wsChannelUser, event)
+			}
+		}
+	}
+}
+
+// wsAuthenticate checks an access token for a live connection. Only
+// session tokens work, since the connection is held open until the token
+// expires and has to be refreshed by the same user.
+func (cfg *apiConfig) wsAuthenticate(ctx context.Context, token string) (uuid.UUID, time.Time, error) {
+	claims, err := auth.ParseJwt(token, cfg.jwtKey)
+	if err == nil && claims.ExpiresAt == nil {
+		err = errors.New("token has no expiry")
+	}
+	if err != nil {
+		return uuid.Nil, time.Time{}, err
+	}
+	userId, err := claims.UserID()
+	if err != nil {
+		return uuid.Nil, time.Time{}, err
+	}
+	if !auth.HasScope(claims.Scopes, auth.ScopeRead) {
+		return uuid.Nil, time.Time{}, errInsufficientScope
+	}
+	active, err := cfg.dbQueries.IsUserActive(ctx, userId)
+	if err == sql.ErrNoRows || (err == nil && !active) {
+		return uuid.Nil, time.Time{}, errAccountDeleted
+	}
+	if err != nil {
+		return uuid.Nil, time.Time{}, err
+	}
+	return userId, claims.ExpiresAt.Time, nil
+}
+
+// wsFirstMessageAuth waits for the authenticate message of a connection
+// that was opened without a token.
+func (cfg *apiConfig) wsFirstMessageAuth(ctx context.Context, conn *websocket.Conn) (uuid.UUID, time.Time, error) {
+	conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return uuid.Nil, time.Time{}, err
+	}
+	msg := wsClientMessage{}
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "authenticate" {
+		return uuid.Nil, time.Time{}, errors.New("authenticate first")
+	}
+	userId, expiresAt, err := cfg.wsAuthenticate(ctx, msg.Token)
+	if err != nil {
+		return uuid.Nil, time.Time{}, err
+	}
+	// nothing else writes to the connection yet
+	reply, _ := json.Marshal(wsServerMessage{Type: "authenticated"})
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	if err := conn.WriteMessage(websocket.TextMessage, reply); err != nil {
+		return uuid.Nil, time.Time{}, err
+	}
+	return userId, expiresAt, nil
+}
+
+// wsCloseWith sends a close frame and closes the connection. The close
+// frame is a control frame, which may be written alongside the writer.
+func wsCloseWith(conn *websocket.Conn, code int, reason string) {
+	// control frames carry at most 125 bytes, two of them the code
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteTimeout))
+	conn.Close()
+}
+
+func (s *wsSession) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		wsCloseWith(s.conn, code, reason)
+		close(s.done)
+	})
+}
+
+// enqueue hands a message to the writer. A client that lets its queue
+// fill up is disconnected rather than letting events pile up in memory.
+func (s *wsSession) enqueue(msg wsServerMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding websocket message: %v", err)
+		return
+	}
+	select {
+	case s.out <- data:
+	case <-s.done:
+	default:
+		s.close(websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+func (s *wsSession) writeLoop() {
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case data := <-s.out:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := s.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				s.close(websocket.CloseGoingAway, "")
+				return
+			}
+		case <-ping.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				s.close(websocket.CloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+func (s *wsSession) readLoop(ctx context.Context, cfg *apiConfig) {
+	// any message or pong shows the client is still there
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+	})
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			s.close(websocket.CloseNormalClosure, "")
+			return
+		}
+		msg := wsClientMessage{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			s.enqueue(wsServerMessage{Type: "error", Error: "invalid message"})
+			continue
+		}
+		switch msg.Type {
+		case "ping":
+			s.enqueue(wsServerMessage{Type: "pong"})
+		case "subscribe":
+			if errMsg := s.subscribe(msg.ID, msg.Channel); errMsg != "" {
+				s.enqueue(wsServerMessage{Type: "error", ID: msg.ID, Error: errMsg})
+				continue
+			}
+			s.enqueue(wsServerMessage{Type: "subscribed", ID: msg.ID})
+		case "unsubscribe":
+			s.mu.Lock()
+			delete(s.subs, msg.ID)
+			s.mu.Unlock()
+			s.enqueue(wsServerMessage{Type: "unsubscribed", ID: msg.ID})
+		case "authenticate":
+			userId, expiresAt, err := cfg.wsAuthenticate(ctx, msg.Token)
+			if errors.Is(err, errInsufficientScope) || errors.Is(err, errAccountDeleted) {
+				s.enqueue(wsServerMessage{Type: "error", Error: err.Error()})
+				continue
+			}
+			if err != nil {
+				s.enqueue(wsServerMessage{Type: "error", Error: "invalid token"})
+				continue
+			}
+			if userId != s.userId {
+				s.enqueue(wsServerMessage{Type: "error", Error: "token belongs to another user"})
+				continue
+			}
+			s.expiry.Reset(time.Until(expiresAt))
+			s.enqueue(wsServerMessage{Type: "authenticated"})
+		default:
+			s.enqueue(wsServerMessage{Type: "error", Error: "unknown message type"})
+		}
+	}
+}
+
+func (s *wsSession) subscribe(id, channel string) string {
+	if id == "" {
+		return "missing id"
+	}
+	switch {
+	case channel == wsChannelTimeline || channel == wsChannelUser:
+	case strings.HasPrefix(channel, wsChannelAuthorPrefix):
+		if _, err := uuid.Parse(strings.TrimPrefix(channel, wsChannelAuthorPrefix)); err != nil {
+			return "invalid author id"
+		}
+	case strings.HasPrefix(channel, wsChannelChirpPrefix):
+		if _, err := uuid.Parse(strings.TrimPrefix(channel, wsChannelChirpPrefix)); err != nil {
+			return "invalid chirp id"
+		}
+	default:
+		return "unknown channel"
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.subs[id]; !exists && len(s.subs) >= maxWsSubscriptions {
+		return "too many subscriptions"
+	}
+	s.subs[id] = channel
+	return ""
+}
+
+// dispatchChirp works out which channels a chirp event belongs to before
+// handing it out.
+func (s *wsSession) dispatchChirp(event pubsub.Event) {
+	if s.blocked[event.Topic] {
+		return
+	}
+	var chirp struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(event.Data, &chirp)
+	if !s.muted[event.Topic] {
+		s.dispatch(wsChannelTimeline, event)
+	}
+	s.dispatch(wsChannelAuthorPrefix+event.Topic, event)
+	s.dispatch(wsChannelChirpPrefix+chirp.ID, event)
+}
+
+func (s *wsSession) dispatch(channel string, event pubsub.Event) {
+	s.mu.Lock()
+	var ids []string
+	for id, subscribed := range s.subs {
+		if subscribed == channel {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.Unlock()
+	for _, id := range ids {
+		s.enqueue(wsServerMessage{
+			Type:    "event",
+			ID:      id,
+			Event:   event.Type,
+			EventID: event.ID,
+			Data:    event.Data,
+		})
+	}
+}
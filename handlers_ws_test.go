@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func dialWebSocket(t *testing.T, cfg *apiConfig, query, token string) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(cfg.handleWebSocket))
+	t.Cleanup(server.Close)
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/ws" + query
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("dial: %v (%v)", err, resp)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn
+}
+
+func wsRoundTrip(t *testing.T, conn *websocket.Conn, msg wsClientMessage) wsServerMessage {
+	t.Helper()
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+	var reply wsServerMessage
+	if err := conn.ReadJSON(&reply); err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestWebSocketHeaderNeedsReadScope(t *testing.T) {
+	cfg := testConfig(t)
+	// every write scope implies read, so only a token without scopes lacks it
+	token := sessionToken(t, cfg, uuid.New(), []string{})
+	if rec := do(t, cfg.handleWebSocket, "GET", "/api/ws", token, nil); rec.Code != 403 {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
+
+func TestWebSocketIgnoresQueryToken(t *testing.T) {
+	cfg := testConfig(t)
+	token := sessionToken(t, cfg, uuid.New(), auth.AllScopes)
+	conn := dialWebSocket(t, cfg, "?access_token="+token, "")
+
+	if err := conn.WriteJSON(wsClientMessage{Type: "subscribe", ID: "a", Channel: wsChannelTimeline}); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != websocket.ClosePolicyViolation {
+		t.Errorf("err = %v, want a policy violation close", err)
+	}
+}
+
+func TestWebSocketFirstMessageAuth(t *testing.T) {
+	cfg := testDBConfig(t)
+	_, token := createTestUser(t, cfg)
+	conn := dialWebSocket(t, cfg, "", "")
+
+	if reply := wsRoundTrip(t, conn, wsClientMessage{Type: "authenticate", Token: token}); reply.Type != "authenticated" {
+		t.Fatalf("reply = %+v", reply)
+	}
+	if reply := wsRoundTrip(t, conn, wsClientMessage{Type: "ping"}); reply.Type != "pong" {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestWebSocketRefreshNeedsReadScope(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, token := createTestUser(t, cfg)
+	other, _ := createTestUser(t, cfg)
+	conn := dialWebSocket(t, cfg, "", token)
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"no read scope", sessionToken(t, cfg, user.ID, []string{}), errInsufficientScope.Error()},
+		{"other user", sessionToken(t, cfg, other.ID, auth.AllScopes), "token belongs to another user"},
+		{"garbage", "garbage", "invalid token"},
+	}
+	for _, tt := range tests {
+		reply := wsRoundTrip(t, conn, wsClientMessage{Type: "authenticate", Token: tt.token})
+		if reply.Type != "error" || reply.Error != tt.want {
+			t.Errorf("%s: reply = %+v, want error %q", tt.name, reply, tt.want)
+		}
+	}
+	fresh := sessionToken(t, cfg, user.ID, []string{auth.ScopeRead})
+	if reply := wsRoundTrip(t, conn, wsClientMessage{Type: "authenticate", Token: fresh}); reply.Type != "authenticated" {
+		t.Errorf("reply = %+v", reply)
+	}
+}
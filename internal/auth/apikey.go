@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix marks personal API keys so they can be told apart from JWTs
+// in the Authorization header.
+const APIKeyPrefix = "chirpy_pk_"
+
+func MakeAPIKey() (string, error) {
+	return makePrefixedToken(APIKeyPrefix)
+}
+
+func makePrefixedToken(prefix string) (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(randomBytes), nil
+}
+
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// HashToken returns the hex sha256 of an opaque token. Keys are random and
+// high entropy so a fast hash is enough and keeps lookups indexable.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
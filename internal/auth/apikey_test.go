@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMakeAPIKey(t *testing.T) {
+	a, err := MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Error("two keys should not be equal")
+	}
+	if !IsAPIKey(a) || !strings.HasPrefix(a, APIKeyPrefix) {
+		t.Errorf("key %q is missing the %q prefix", a, APIKeyPrefix)
+	}
+	if IsAPIKey("eyJhbGciOiJIUzI1NiJ9.e30.x") {
+		t.Error("a JWT should not look like an api key")
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	key, err := MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := HashToken(key)
+	if hash != HashToken(key) {
+		t.Error("hashing should be deterministic")
+	}
+	if len(hash) != 64 || strings.Contains(hash, key) {
+		t.Errorf("unexpected hash %q", hash)
+	}
+	if hash == HashToken(key+"x") {
+		t.Error("different keys should hash differently")
+	}
+	// known sha256 so stored hashes stay valid across changes
+	if got := HashToken("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("HashToken(abc) = %s", got)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,48 +14,98 @@ import (
 	"github.com/google/uuid"
 )
 
+// passwordParams are the Argon2id parameters used for new hashes. Override
+// them once at startup with SetPasswordParams.
+var passwordParams = argon2id.DefaultParams
+
+func SetPasswordParams(params *argon2id.Params) {
+	passwordParams = params
+}
+
+func PasswordParams() argon2id.Params {
+	return *passwordParams
+}
+
 func HashPassword(password string) (string, error) {
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	hash, err := argon2id.CreateHash(password, passwordParams)
 	return hash, err
 }
 
+// NeedsRehash reports whether hash was created with weaker parameters than
+// the ones currently configured, so it should be replaced after the next
+// successful login.
+func NeedsRehash(hash string) bool {
+	params, _, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return false
+	}
+	return params.Memory < passwordParams.Memory ||
+		params.Iterations < passwordParams.Iterations ||
+		params.Parallelism < passwordParams.Parallelism ||
+		params.SaltLength < passwordParams.SaltLength ||
+		params.KeyLength < passwordParams.KeyLength
+}
+
 func CheckPasswordHash(password, hash string) (bool, error) {
 	return argon2id.ComparePasswordAndHash(password, hash)
 }
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+type Claims struct {
+	jwt.RegisteredClaims
+	Scopes []string `json:"scopes,omitempty"`
+	Role   string   `json:"role,omitempty"`
+}
+
+func MakeJWT(userID uuid.UUID, role string, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return MakeScopedJWT(userID, role, tokenSecret, expiresIn, AllScopes)
+}
+
+func MakeScopedJWT(userID uuid.UUID, role string, tokenSecret string, expiresIn time.Duration, scopes []string) (string, error) {
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256,
-		jwt.RegisteredClaims{
-			Issuer:    "chirpy",
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
-			Subject:   userID.String(),
+		Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "chirpy",
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+				Subject:   userID.String(),
+			},
+			Scopes: scopes,
+			Role:   role,
 		})
 	s, err := t.SignedString([]byte(tokenSecret))
 	return s, err
 }
 
 func ValidateJwt(tokenString, tokenSecrect string) (uuid.UUID, error) {
-	type MyCustomClaims struct {
-		jwt.RegisteredClaims
+	claims, err := ParseJwt(tokenString, tokenSecrect)
+	if err != nil {
+		return uuid.Nil, err
 	}
+	return claims.UserID()
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &MyCustomClaims{}, func(token *jwt.Token) (any, error) {
+// ParseJwt validates the token signature and expiry and returns its claims.
+func ParseJwt(tokenString, tokenSecrect string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (any, error) {
 		return []byte(tokenSecrect), nil
 	})
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
-	claims, ok := token.Claims.(*MyCustomClaims)
+	claims, ok := token.Claims.(*Claims)
 	if !ok {
-		return uuid.Nil, err
+		return nil, fmt.Errorf("invalid token claims")
 	}
-
-	userId, err := uuid.Parse(claims.Subject)
-	if err != nil {
-		return uuid.Nil, err
+	// challenge tokens are signed with the same key but must never be
+	// usable as access tokens
+	if slices.Contains(claims.Audience, mfaAudience) {
+		return nil, fmt.Errorf("token is not an access token")
 	}
-	return userId, nil
+	return claims, nil
+}
+
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
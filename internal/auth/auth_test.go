@@ -1,5 +1,32 @@
 package auth
 
+import (
+	"testing"
+
+	"github.com/alexedwards/argon2id"
+)
+
 // func TestGetBearerToken(t *testing.T) {
 // 	got := GetBearerToken(headers);
 // }
+
+func TestNeedsRehash(t *testing.T) {
+	weak := &argon2id.Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	defer SetPasswordParams(argon2id.DefaultParams)
+
+	SetPasswordParams(weak)
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(hash) {
+		t.Error("hash made with current params should not need rehash")
+	}
+
+	stronger := *weak
+	stronger.Iterations = 2
+	SetPasswordParams(&stronger)
+	if !NeedsRehash(hash) {
+		t.Error("hash made with weaker params should need rehash")
+	}
+}
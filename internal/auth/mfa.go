@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const mfaAudience = "chirpy-mfa"
+
+// MakeMFAChallengeJWT issues the short-lived token returned by the password
+// step of login. It proves the password was correct and nothing more.
+func MakeMFAChallengeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256,
+		jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   userID.String(),
+		})
+	return t.SignedString([]byte(tokenSecret))
+}
+
+func ValidateMFAChallengeJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims := jwt.RegisteredClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (any, error) {
+		return []byte(tokenSecret), nil
+	}, jwt.WithAudience(mfaAudience))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid mfa token: %w", err)
+	}
+	return uuid.Parse(claims.Subject)
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// Opaque tokens issued to third-party OAuth clients. Unlike JWTs they are
+// looked up on every request so they can be introspected and revoked.
+const (
+	OAuthAccessTokenPrefix  = "chirpy_at_"
+	OAuthRefreshTokenPrefix = "chirpy_rt_"
+	OAuthClientIDPrefix     = "chirpy_client_"
+	OAuthCodePrefix         = "chirpy_code_"
+)
+
+func MakeOAuthAccessToken() (string, error) {
+	return makePrefixedToken(OAuthAccessTokenPrefix)
+}
+
+func MakeOAuthRefreshToken() (string, error) {
+	return makePrefixedToken(OAuthRefreshTokenPrefix)
+}
+
+func MakeOAuthCode() (string, error) {
+	return makePrefixedToken(OAuthCodePrefix)
+}
+
+// MakeOAuthClientCredentials returns a client_id, which is public and kept
+// short, and a client secret.
+func MakeOAuthClientCredentials() (clientID, secret string, err error) {
+	randomBytes := make([]byte, 8)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", "", err
+	}
+	secret, err = makePrefixedToken("")
+	if err != nil {
+		return "", "", err
+	}
+	return OAuthClientIDPrefix + hex.EncodeToString(randomBytes), secret, nil
+}
+
+func IsOAuthAccessToken(token string) bool {
+	return strings.HasPrefix(token, OAuthAccessTokenPrefix)
+}
+
+// VerifyPKCE checks an S256 code_verifier against the stored challenge.
+func VerifyPKCE(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
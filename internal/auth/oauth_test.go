@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if !VerifyPKCE(verifier, challenge) {
+		t.Error("RFC 7636 example should verify")
+	}
+	if VerifyPKCE(verifier+"x", challenge) {
+		t.Error("wrong verifier should not verify")
+	}
+	if VerifyPKCE("", challenge) || VerifyPKCE(verifier, "") {
+		t.Error("empty values should not verify")
+	}
+	// plain challenges are not accepted
+	if VerifyPKCE(verifier, verifier) {
+		t.Error("plain method should not verify")
+	}
+}
+
+func TestOAuthTokenPrefixes(t *testing.T) {
+	access, err := MakeOAuthAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := MakeOAuthRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsOAuthAccessToken(access) || IsOAuthAccessToken(refresh) {
+		t.Errorf("access %q refresh %q", access, refresh)
+	}
+	clientId, secret, err := MakeOAuthClientCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(clientId, OAuthClientIDPrefix) || secret == "" || strings.Contains(secret, clientId) {
+		t.Errorf("client_id %q secret %q", clientId, secret)
+	}
+}
@@ -0,0 +1,24 @@
+package auth
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// RoleAtLeast reports whether role grants everything min does. Admins can
+// do anything moderators can, and moderators anything users can.
+func RoleAtLeast(role, min string) bool {
+	return roleRank[role] >= roleRank[min] && roleRank[min] > 0
+}
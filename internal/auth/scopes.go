@@ -0,0 +1,26 @@
+package auth
+
+import "slices"
+
+const (
+	ScopeRead          = "read"
+	ScopeChirpsWrite   = "chirps:write"
+	ScopeProfileWrite  = "profile:write"
+	ScopeMessagesWrite = "messages:write"
+)
+
+// AllScopes is granted to tokens issued by password login and refresh.
+var AllScopes = []string{ScopeRead, ScopeChirpsWrite, ScopeProfileWrite, ScopeMessagesWrite}
+
+func IsValidScope(scope string) bool {
+	return slices.Contains(AllScopes, scope)
+}
+
+// HasScope reports whether granted contains the required scope. Every
+// write scope implies read access.
+func HasScope(granted []string, required string) bool {
+	if slices.Contains(granted, required) {
+		return true
+	}
+	return required == ScopeRead && len(granted) > 0
+}
@@ -0,0 +1,38 @@
+package auth
+
+import "testing"
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		name     string
+		granted  []string
+		required string
+		want     bool
+	}{
+		{"exact", []string{ScopeChirpsWrite}, ScopeChirpsWrite, true},
+		{"missing", []string{ScopeChirpsWrite}, ScopeProfileWrite, false},
+		{"write implies read", []string{ScopeMessagesWrite}, ScopeRead, true},
+		{"read does not imply write", []string{ScopeRead}, ScopeChirpsWrite, false},
+		{"no scopes", nil, ScopeRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasScope(tt.granted, tt.required); got != tt.want {
+				t.Errorf("HasScope(%v, %q) = %v, want %v", tt.granted, tt.required, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsValidScope(t *testing.T) {
+	for _, scope := range AllScopes {
+		if !IsValidScope(scope) {
+			t.Errorf("IsValidScope(%q) = false", scope)
+		}
+	}
+	for _, scope := range []string{"", "admin", "READ", "chirps"} {
+		if IsValidScope(scope) {
+			t.Errorf("IsValidScope(%q) = true", scope)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// secret from RFC 6238 appendix B, base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+	}
+	for _, c := range cases {
+		got, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", c.unix, got, c.want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code, _ := TOTPCode(rfcSecret, TOTPStep(now)-1)
+	if _, ok := ValidateTOTP(rfcSecret, code, now); !ok {
+		t.Error("code from previous step should be accepted")
+	}
+	code, _ = TOTPCode(rfcSecret, TOTPStep(now)-2)
+	if _, ok := ValidateTOTP(rfcSecret, code, now); ok {
+		t.Error("code from two steps ago should be rejected")
+	}
+}
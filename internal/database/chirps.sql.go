@@ -8,10 +8,38 @@ package database
 import (
 	"context"
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+const countChirpsByAuthorId = `-- name: CountChirpsByAuthorId :one
+SELECT count(*) FROM chirps WHERE user_id = $1
+`
+
+func (q *Queries) CountChirpsByAuthorId(ctx context.Context, userID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsByAuthorId, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countChirpsSince = `-- name: CountChirpsSince :one
+SELECT count(*) FROM chirps WHERE user_id = $1 AND created_at > $2
+`
+
+type CountChirpsSinceParams struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+func (q *Queries) CountChirpsSince(ctx context.Context, arg CountChirpsSinceParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countChirpsSince, arg.UserID, arg.CreatedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createChirpy = `-- name: CreateChirpy :one
 INSERT INTO chirps (id, created_at, updated_at, body, user_id)
 VALUES (gen_random_uuid(), now(), now(), $1, $2) RETURNING id, created_at, updated_at, body, user_id
@@ -48,6 +76,14 @@ func (q *Queries) DeleteChirpById(ctx context.Context, arg DeleteChirpByIdParams
 	return q.db.ExecContext(ctx, deleteChirpById, arg.ID, arg.UserID)
 }
 
+const deleteChirpByIdAsModerator = `-- name: DeleteChirpByIdAsModerator :execresult
+DELETE FROM chirps WHERE id = $1
+`
+
+func (q *Queries) DeleteChirpByIdAsModerator(ctx context.Context, id uuid.UUID) (sql.Result, error) {
+	return q.db.ExecContext(ctx, deleteChirpByIdAsModerator, id)
+}
+
 const dropChirpsTable = `-- name: DropChirpsTable :exec
 DELETE FROM chirps
 `
@@ -57,12 +93,24 @@ func (q *Queries) DropChirpsTable(ctx context.Context) error {
 	return err
 }
 
-const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+const getChirpsByAuthorId = `-- name: GetChirpsByAuthorId :many
+SELECT id, created_at, updated_at, body, user_id FROM chirps
+WHERE user_id = $1 AND user_id NOT IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+    AND NOT EXISTS (
+        SELECT 1 FROM user_blocks b
+        WHERE (b.blocker_id = chirps.user_id AND b.blocked_id = $2::uuid)
+           OR (b.blocker_id = $2::uuid AND b.blocked_id = chirps.user_id)
+    )
+ORDER BY created_at ASC
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+type GetChirpsByAuthorIdParams struct {
+	UserID   uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+func (q *Queries) GetChirpsByAuthorId(ctx context.Context, arg GetChirpsByAuthorIdParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByAuthorId, arg.UserID, arg.ViewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -90,12 +138,84 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsByAuthorId = `-- name: GetChirpsByAuthorId :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id = $1 ORDER BY created_at ASC
+const getChirpsById = `-- name: GetChirpsById :one
+SELECT id, created_at, updated_at, body, user_id FROM chirps
+WHERE id = $1 AND user_id NOT IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+    AND NOT EXISTS (
+        SELECT 1 FROM user_blocks b
+        WHERE (b.blocker_id = chirps.user_id AND b.blocked_id = $2::uuid)
+           OR (b.blocker_id = $2::uuid AND b.blocked_id = chirps.user_id)
+    )
+`
+
+type GetChirpsByIdParams struct {
+	ID       uuid.UUID
+	ViewerID uuid.NullUUID
+}
+
+func (q *Queries) GetChirpsById(ctx context.Context, arg GetChirpsByIdParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpsById, arg.ID, arg.ViewerID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+	)
+	return i, err
+}
+
+const listChirps = `-- name: ListChirps :many
+SELECT id, created_at, updated_at, body, user_id FROM chirps
+WHERE ($1::uuid IS NULL OR user_id = $1)
+    AND user_id NOT IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+    AND NOT EXISTS (
+        SELECT 1 FROM user_blocks b
+        WHERE (b.blocker_id = chirps.user_id AND b.blocked_id = $2::uuid)
+           OR (b.blocker_id = $2::uuid AND b.blocked_id = chirps.user_id)
+    )
+    AND (
+        $1::uuid IS NOT NULL
+        OR NOT EXISTS (
+            SELECT 1 FROM user_mutes m
+            WHERE m.muter_id = $2::uuid AND m.muted_id = chirps.user_id
+        )
+    )
+    AND (
+        $3::uuid IS NULL
+        OR ($4::bool AND (created_at, id) < (
+            SELECT a.created_at, a.id FROM chirps a WHERE a.id = $3
+        ))
+        OR (NOT $4::bool AND (created_at, id) > (
+            SELECT a.created_at, a.id FROM chirps a WHERE a.id = $3
+        ))
+    )
+ORDER BY
+    CASE WHEN $4::bool THEN created_at END DESC,
+    CASE WHEN $4::bool THEN id END DESC,
+    created_at ASC, id ASC
+LIMIT $5
 `
 
-func (q *Queries) GetChirpsByAuthorId(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthorId, userID)
+type ListChirpsParams struct {
+	AuthorID   uuid.NullUUID
+	ViewerID   uuid.NullUUID
+	After      uuid.NullUUID
+	Descending bool
+	Limit      int32
+}
+
+// One page of chirps, oldest first unless descending, continuing after the
+// chirp with id after. Mutes only hide chirps from the unfiltered list.
+func (q *Queries) ListChirps(ctx context.Context, arg ListChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirps,
+		arg.AuthorID,
+		arg.ViewerID,
+		arg.After,
+		arg.Descending,
+		arg.Limit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -123,19 +243,13 @@ func (q *Queries) GetChirpsByAuthorId(ctx context.Context, userID uuid.UUID) ([]
 	return items, nil
 }
 
-const getChirpsById = `-- name: GetChirpsById :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id = $1
+const lockChirpQuota = `-- name: LockChirpQuota :exec
+SELECT pg_advisory_xact_lock(hashtext('chirp_quota:' || $1::text))
 `
 
-func (q *Queries) GetChirpsById(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirpsById, id)
-	var i Chirp
-	err := row.Scan(
-		&i.ID,
-		&i.CreatedAt,
-		&i.UpdatedAt,
-		&i.Body,
-		&i.UserID,
-	)
-	return i, err
+// Held until the transaction ends, so one user's concurrent posts take
+// turns counting against their quota and inserting.
+func (q *Queries) LockChirpQuota(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, lockChirpQuota, userID)
+	return err
 }
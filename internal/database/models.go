@@ -6,11 +6,25 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type ApiKey struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Name       string
+	HashedKey  string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+	UserID     uuid.UUID
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
@@ -19,6 +33,145 @@ type Chirp struct {
 	UserID    uuid.UUID
 }
 
+type Conversation struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	CreatedBy uuid.NullUUID
+	DirectKey sql.NullString
+}
+
+type ConversationParticipant struct {
+	ConversationID uuid.UUID
+	UserID         uuid.UUID
+	JoinedAt       time.Time
+	LastReadAt     sql.NullTime
+}
+
+type DataExport struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Status    string
+	FilePath  string
+	Error     string
+	UserID    uuid.UUID
+}
+
+type EmailVerificationToken struct {
+	HashedToken string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	UsedAt      sql.NullTime
+	Email       string
+	UserID      uuid.UUID
+}
+
+type InboundWebhookEvent struct {
+	ID             uuid.UUID
+	Source         string
+	EventID        sql.NullString
+	Type           string
+	Payload        json.RawMessage
+	Status         string
+	Deliveries     int32
+	Attempts       int32
+	ReceivedAt     time.Time
+	LastReceivedAt time.Time
+	ProcessedAt    sql.NullTime
+	LastError      sql.NullString
+}
+
+type LoginFailure struct {
+	Key         string
+	Failures    int32
+	LockedUntil sql.NullTime
+	UpdatedAt   time.Time
+}
+
+type Message struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	ConversationID uuid.UUID
+	SenderID       uuid.UUID
+	Body           string
+}
+
+type Notification struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	Type      string
+	Payload   json.RawMessage
+	ReadAt    sql.NullTime
+}
+
+type NotificationPreference struct {
+	UserID    uuid.UUID
+	Type      string
+	Enabled   bool
+	UpdatedAt time.Time
+}
+
+type OauthAuthorizationCode struct {
+	HashedCode    string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+	ClientID      string
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	UserID        uuid.UUID
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	ClientID     string
+	HashedSecret sql.NullString
+	Name         string
+	RedirectUris []string
+	Scopes       []string
+	OwnerID      uuid.UUID
+}
+
+type OauthToken struct {
+	HashedToken string
+	CreatedAt   time.Time
+	Kind        string
+	ExpiresAt   time.Time
+	RevokedAt   sql.NullTime
+	ClientID    string
+	Scopes      []string
+	UserID      uuid.UUID
+}
+
+type OidcLoginState struct {
+	State        string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	CodeVerifier string
+	Nonce        string
+}
+
+type PasswordResetToken struct {
+	HashedToken string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	UsedAt      sql.NullTime
+	UserID      uuid.UUID
+}
+
+type RecoveryCode struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	HashedCode string
+	UsedAt     sql.NullTime
+	UserID     uuid.UUID
+}
+
 type RefreshToken struct {
 	Token     string
 	CreatedAt time.Time
@@ -28,11 +181,88 @@ type RefreshToken struct {
 	UserID    uuid.UUID
 }
 
+type Subscription struct {
+	ID               uuid.UUID
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+	UserID           uuid.UUID
+	Plan             string
+	Status           string
+	CurrentPeriodEnd time.Time
+	CancelledAt      sql.NullTime
+	LastEventAt      sql.NullTime
+}
+
 type User struct {
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	HashedPassword  string
+	IsChirpyRed     bool
+	EmailVerifiedAt sql.NullTime
+	Role            string
+	DeletedAt       sql.NullTime
+	Username        sql.NullString
+	DisplayName     string
+	Bio             string
+	AvatarUrl       string
+	Version         int32
+	AcceptMessages  string
+}
+
+type UserBlock struct {
+	BlockerID uuid.UUID
+	BlockedID uuid.UUID
+	CreatedAt time.Time
+}
+
+type UserIdentity struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Provider  string
+	Subject   string
+	Email     string
+	UserID    uuid.UUID
+}
+
+type UserMute struct {
+	MuterID   uuid.UUID
+	MutedID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type UserTotp struct {
+	UserID       uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Secret       string
+	ConfirmedAt  sql.NullTime
+	LastUsedStep int64
+}
+
+type WebhookDelivery struct {
 	ID             uuid.UUID
 	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    bool
+	EndpointID     uuid.UUID
+	EventID        uuid.UUID
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastAttemptAt  sql.NullTime
+	ResponseStatus sql.NullInt32
+	LastError      sql.NullString
+}
+
+type WebhookEndpoint struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	UserID     uuid.UUID
+	Url        string
+	Secret     string
+	EventTypes []string
+	Global     bool
 }
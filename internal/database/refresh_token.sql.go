@@ -12,6 +12,40 @@ import (
 	"github.com/google/uuid"
 )
 
+const getRefreshTokensByUserId = `-- name: GetRefreshTokensByUserId :many
+SELECT token, created_at, updated_at, expires_at, revoked_at, user_id FROM refresh_tokens WHERE user_id = $1 ORDER BY created_at ASC
+`
+
+func (q *Queries) GetRefreshTokensByUserId(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, getRefreshTokensByUserId, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.Token,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+			&i.UserID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getToken = `-- name: GetToken :one
 SELECT token, created_at, updated_at, expires_at, revoked_at, user_id FROM refresh_tokens WHERE token = $1
 `
@@ -55,6 +89,16 @@ func (q *Queries) InsertRefreshToken(ctx context.Context, arg InsertRefreshToken
 	return i, err
 }
 
+const revokeAllUserTokens = `-- name: RevokeAllUserTokens :exec
+UPDATE refresh_tokens SET revoked_at = NOW(), updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAllUserTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeAllUserTokens, userID)
+	return err
+}
+
 const revokeToken = `-- name: RevokeToken :one
 UPDATE refresh_tokens SET revoked_at = NOW() WHERE token = $1 RETURNING token, created_at, updated_at, expires_at, revoked_at, user_id
 `
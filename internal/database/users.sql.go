@@ -7,13 +7,14 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
 const createUser = `-- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES (gen_random_uuid(), now(), now(), $1, $2) RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+VALUES (gen_random_uuid(), now(), now(), $1, $2) RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
 `
 
 type CreateUserParams struct {
@@ -31,6 +32,15 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
 	)
 	return i, err
 }
@@ -44,8 +54,26 @@ func (q *Queries) DropTable(ctx context.Context) error {
 	return err
 }
 
+const getUserAccess = `-- name: GetUserAccess :one
+SELECT deleted_at IS NULL AS active, role FROM users WHERE id = $1
+`
+
+type GetUserAccessRow struct {
+	Active bool
+	Role   string
+}
+
+// The role is read on every request rather than trusted from the token, so
+// a demotion takes effect straight away.
+func (q *Queries) GetUserAccess(ctx context.Context, id uuid.UUID) (GetUserAccessRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserAccess, id)
+	var i GetUserAccessRow
+	err := row.Scan(&i.Active, &i.Role)
+	return i, err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages FROM users WHERE email = $1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -58,12 +86,279 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
+	)
+	return i, err
+}
+
+const getUserById = `-- name: GetUserById :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages FROM users WHERE id = $1
+`
+
+func (q *Queries) GetUserById(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserById, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
+	)
+	return i, err
+}
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages FROM users WHERE lower(username) = lower($1)
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, lower string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByUsername, lower)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
+	)
+	return i, err
+}
+
+const isUserActive = `-- name: IsUserActive :one
+SELECT deleted_at IS NULL FROM users WHERE id = $1
+`
+
+func (q *Queries) IsUserActive(ctx context.Context, id uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isUserActive, id)
+	var column_1 bool
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const markUserDeleted = `-- name: MarkUserDeleted :one
+UPDATE users SET deleted_at=now(), updated_at=now(), version=version+1 WHERE id = $1 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
+`
+
+func (q *Queries) MarkUserDeleted(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, markUserDeleted, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
+	)
+	return i, err
+}
+
+const purgeDeletedUsers = `-- name: PurgeDeletedUsers :many
+WITH purged AS (
+    DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING id
+)
+SELECT purged.id AS user_id, COALESCE(data_exports.file_path, '')::text AS file_path
+FROM purged LEFT JOIN data_exports ON data_exports.user_id = purged.id
+`
+
+type PurgeDeletedUsersRow struct {
+	UserID   uuid.UUID
+	FilePath string
+}
+
+// The users' export rows cascade away with them, so their archive paths
+// come back to remove the files too. Users without exports get one row
+// with an empty path.
+func (q *Queries) PurgeDeletedUsers(ctx context.Context, deletedAt sql.NullTime) ([]PurgeDeletedUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, purgeDeletedUsers, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PurgeDeletedUsersRow
+	for rows.Next() {
+		var i PurgeDeletedUsersRow
+		if err := rows.Scan(&i.UserID, &i.FilePath); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const restoreUser = `-- name: RestoreUser :one
+UPDATE users SET deleted_at=NULL, updated_at=now(), version=version+1 WHERE id = $1 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
+`
+
+func (q *Queries) RestoreUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, restoreUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
+	)
+	return i, err
+}
+
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users SET role=$1, updated_at=now(), version=version+1 WHERE id = $2 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
+`
+
+type SetUserRoleParams struct {
+	Role string
+	ID   uuid.UUID
+}
+
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.Role, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
+	)
+	return i, err
+}
+
+const setUserVerifiedEmail = `-- name: SetUserVerifiedEmail :one
+UPDATE users SET email=$1, email_verified_at=now(), updated_at=now(), version=version+1 WHERE id = $2 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
+`
+
+type SetUserVerifiedEmailParams struct {
+	Email string
+	ID    uuid.UUID
+}
+
+func (q *Queries) SetUserVerifiedEmail(ctx context.Context, arg SetUserVerifiedEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserVerifiedEmail, arg.Email, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
+	)
+	return i, err
+}
+
+const syncUserChirpyRed = `-- name: SyncUserChirpyRed :one
+UPDATE users SET is_chirpy_red = NOT is_chirpy_red, version = version + 1
+WHERE id = $1 AND is_chirpy_red <> EXISTS (
+    SELECT 1 FROM subscriptions s
+    WHERE s.user_id = users.id
+        AND s.status IN ('active', 'past_due', 'cancelled')
+        AND s.current_period_end > now()
+)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
+`
+
+// is_chirpy_red is only ever written here, from the user's subscription.
+// No row comes back when it was already right.
+func (q *Queries) SyncUserChirpyRed(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, syncUserChirpyRed, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
 	)
 	return i, err
 }
 
 const updateUserById = `-- name: UpdateUserById :one
-UPDATE users SET email=$1, hashed_password=$2, updated_at=now() WHERE id = $3 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+UPDATE users SET email=$1, hashed_password=$2, updated_at=now(), version=version+1 WHERE id = $3 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
 `
 
 type UpdateUserByIdParams struct {
@@ -82,21 +377,88 @@ func (q *Queries) UpdateUserById(ctx context.Context, arg UpdateUserByIdParams)
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
 	)
 	return i, err
 }
 
-const updateUserToChirpyRed = `-- name: UpdateUserToChirpyRed :one
-UPDATE users SET is_chirpy_red=$1 WHERE id=$2 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+const updateUserPassword = `-- name: UpdateUserPassword :one
+UPDATE users SET hashed_password=$1, updated_at=now(), version=version+1 WHERE id = $2 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
 `
 
-type UpdateUserToChirpyRedParams struct {
-	IsChirpyRed bool
-	ID          uuid.UUID
+type UpdateUserPasswordParams struct {
+	HashedPassword string
+	ID             uuid.UUID
 }
 
-func (q *Queries) UpdateUserToChirpyRed(ctx context.Context, arg UpdateUserToChirpyRedParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUserToChirpyRed, arg.IsChirpyRed, arg.ID)
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserPassword, arg.HashedPassword, arg.ID)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
+	)
+	return i, err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users SET
+    username = COALESCE($1, username),
+    display_name = COALESCE($2, display_name),
+    bio = COALESCE($3, bio),
+    avatar_url = COALESCE($4, avatar_url),
+    hashed_password = COALESCE($5, hashed_password),
+    accept_messages = COALESCE($6, accept_messages),
+    updated_at = now(),
+    version = version + 1
+WHERE id = $7
+    AND ($8::integer IS NULL OR version = $8)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, email_verified_at, role, deleted_at, username, display_name, bio, avatar_url, version, accept_messages
+`
+
+type UpdateUserProfileParams struct {
+	Username        sql.NullString
+	DisplayName     sql.NullString
+	Bio             sql.NullString
+	AvatarUrl       sql.NullString
+	HashedPassword  sql.NullString
+	AcceptMessages  sql.NullString
+	ID              uuid.UUID
+	ExpectedVersion sql.NullInt32
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile,
+		arg.Username,
+		arg.DisplayName,
+		arg.Bio,
+		arg.AvatarUrl,
+		arg.HashedPassword,
+		arg.AcceptMessages,
+		arg.ID,
+		arg.ExpectedVersion,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -105,6 +467,15 @@ func (q *Queries) UpdateUserToChirpyRed(ctx context.Context, arg UpdateUserToChi
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.EmailVerifiedAt,
+		&i.Role,
+		&i.DeletedAt,
+		&i.Username,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarUrl,
+		&i.Version,
+		&i.AcceptMessages,
 	)
 	return i, err
 }
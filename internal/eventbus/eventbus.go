@@ -0,0 +1,96 @@
+// Package eventbus carries state-change events between Chirpy instances.
+// Every instance publishes what it changes and hears what the others
+// changed, so live streams and caches stay consistent behind a load
+// balancer.
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Topics group events by what they're about.
+const (
+	TopicChirps = "chirps"
+	TopicUsers  = "users"
+)
+
+type Event struct {
+	// ID is assigned by Publish and orders events across every instance,
+	// so a client can resume a stream from any of them.
+	ID    uint64 `json:"id"`
+	Topic string `json:"topic"`
+	Type  string `json:"type"`
+	// Key identifies the entity, such as the author of a chirp or the user
+	// an event is for.
+	Key  string          `json:"key"`
+	Data json.RawMessage `json:"data"`
+}
+
+type Handler func(Event)
+
+// Bus delivers every published event to every subscriber on every
+// instance, including the one that published it. Delivery is at most
+// once: events published while an instance is disconnected are lost.
+type Bus interface {
+	Publish(ctx context.Context, event Event) error
+	// Subscribe registers h for all events. Handlers run one at a time and
+	// should return quickly.
+	Subscribe(h Handler)
+	Close() error
+}
+
+// handlers is the subscriber list shared by the implementations.
+type handlers struct {
+	mu   sync.Mutex
+	list []Handler
+}
+
+func (h *handlers) add(handler Handler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.list = append(h.list, handler)
+}
+
+func (h *handlers) dispatch(event Event) {
+	h.mu.Lock()
+	list := h.list
+	h.mu.Unlock()
+	for _, handler := range list {
+		handler(event)
+	}
+}
+
+// Local delivers events within the process. It's enough for a single
+// instance and for tests.
+type Local struct {
+	mu     sync.Mutex
+	nextID uint64
+	handlers
+}
+
+// NewLocal starts ids at the current time in microseconds, so ids handed
+// out before a restart stay below the new ones.
+func NewLocal() *Local {
+	return &Local{nextID: uint64(time.Now().UnixMicro())}
+}
+
+func (l *Local) Publish(ctx context.Context, event Event) error {
+	// one at a time, like the Postgres listener
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	event.ID = l.nextID
+	l.dispatch(event)
+	return nil
+}
+
+func (l *Local) Subscribe(h Handler) {
+	l.add(h)
+}
+
+func (l *Local) Close() error {
+	return nil
+}
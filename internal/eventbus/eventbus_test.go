@@ -0,0 +1,84 @@
+package eventbus
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestLocalDeliversToEverySubscriber(t *testing.T) {
+	bus := NewLocal()
+	var got []string
+	bus.Subscribe(func(e Event) { got = append(got, "a:"+e.Type) })
+	bus.Subscribe(func(e Event) { got = append(got, "b:"+e.Key) })
+	err := bus.Publish(context.Background(), Event{Topic: TopicChirps, Type: "chirp_created", Key: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != "a:chirp_created" || got[1] != "b:u1" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestLocalAssignsIncreasingIDs(t *testing.T) {
+	bus := NewLocal()
+	var ids []uint64
+	bus.Subscribe(func(e Event) { ids = append(ids, e.ID) })
+	for range 3 {
+		if err := bus.Publish(context.Background(), Event{Topic: TopicChirps, ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ids) != 3 || ids[0] <= 1 || ids[1] <= ids[0] || ids[2] <= ids[1] {
+		t.Errorf("ids = %v, want increasing ids assigned by the bus", ids)
+	}
+}
+
+func TestPostgresDecodesNotifications(t *testing.T) {
+	p := &Postgres{}
+	var got []Event
+	p.Subscribe(func(e Event) { got = append(got, e) })
+
+	p.handle(nil)
+	p.handle(&pq.Notification{Channel: Channel, Extra: "not json"})
+	p.handle(&pq.Notification{
+		Channel: Channel,
+		Extra:   `{"id":7,"topic":"users","type":"user_upgraded","key":"u1","data":{"is_chirpy_red":true}}`,
+	})
+	if len(got) != 1 {
+		t.Fatalf("dispatched %d events, want 1", len(got))
+	}
+	e := got[0]
+	if e.ID != 7 || e.Topic != TopicUsers || e.Type != "user_upgraded" || e.Key != "u1" || string(e.Data) != `{"is_chirpy_red":true}` {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestPostgresStoresOversizedData(t *testing.T) {
+	small := Event{ID: 1, Topic: TopicUsers, Type: "message_created", Key: "u1", Data: []byte(`{"body":"hi"}`)}
+	payload, stored, err := encodeNotification(small)
+	if err != nil || stored || !strings.Contains(string(payload), `"body":"hi"`) {
+		t.Errorf("small event: stored %v, payload %s, err %v", stored, payload, err)
+	}
+
+	// a long message escapes to several times its length in JSON
+	big := small
+	big.Data = []byte(`{"body":"` + strings.Repeat(`\u003c`, maxPayload/6) + `"}`)
+	payload, stored, err = encodeNotification(big)
+	if err != nil || !stored {
+		t.Fatalf("big event: stored %v, err %v", stored, err)
+	}
+	if len(payload) >= maxPayload {
+		t.Errorf("notification is %d bytes, over the NOTIFY limit", len(payload))
+	}
+	received := notification{}
+	if err := json.Unmarshal(payload, &received); err != nil {
+		t.Fatal(err)
+	}
+	if !received.Stored || received.ID != 1 || received.Key != "u1" || string(received.Data) != "null" {
+		t.Errorf("notification = %+v, want the event without its data", received)
+	}
+}
@@ -0,0 +1,155 @@
+package eventbus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Channel is the LISTEN/NOTIFY channel every instance shares.
+const Channel = "chirpy_events"
+
+// maxPayload is the limit Postgres puts on a NOTIFY payload, which must be
+// shorter than this.
+const maxPayload = 8000
+
+// notification is what goes over the channel. An event too big for it is
+// sent without its data, which is kept in event_payloads for listeners to
+// load by id.
+type notification struct {
+	Event
+	Stored bool `json:"stored,omitempty"`
+}
+
+// encodeNotification returns the NOTIFY payload for event, and whether the
+// data has to be stored for it to be loaded on the other side.
+func encodeNotification(event Event) ([]byte, bool, error) {
+	payload, err := json.Marshal(notification{Event: event})
+	if err != nil || len(payload) < maxPayload {
+		return payload, false, err
+	}
+	event.Data = nil
+	payload, err = json.Marshal(notification{Event: event, Stored: true})
+	if err != nil {
+		return nil, false, err
+	}
+	if len(payload) >= maxPayload {
+		return nil, false, fmt.Errorf("event %s/%s doesn't fit a NOTIFY payload even without its data", event.Topic, event.Type)
+	}
+	return payload, true, nil
+}
+
+// idSequence hands out event ids. Being shared by every instance, it gives
+// the same ids everywhere and keeps counting across restarts.
+const idSequence = "chirpy_event_ids"
+
+// Postgres publishes with pg_notify and receives through a dedicated
+// LISTEN connection that reconnects on its own.
+type Postgres struct {
+	db       *sql.DB
+	listener *pq.Listener
+	done     chan struct{}
+	handlers
+}
+
+// NewPostgres starts listening in the background; it doesn't wait for the
+// database to be reachable.
+func NewPostgres(db *sql.DB, dsn string) *Postgres {
+	p := &Postgres{db: db, done: make(chan struct{})}
+	p.listener = pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			log.Printf("Event bus disconnected: %v", err)
+		case pq.ListenerEventReconnected:
+			log.Printf("Event bus reconnected, events sent meanwhile were missed")
+		case pq.ListenerEventConnectionAttemptFailed:
+			log.Printf("Event bus connection failed: %v", err)
+		}
+	})
+	go func() {
+		if err := p.listener.Listen(Channel); err != nil {
+			log.Printf("Error listening on %s: %v", Channel, err)
+		}
+	}()
+	go p.run()
+	return p
+}
+
+func (p *Postgres) Publish(ctx context.Context, event Event) error {
+	if err := p.db.QueryRowContext(ctx, "SELECT nextval($1)", idSequence).Scan(&event.ID); err != nil {
+		return err
+	}
+	payload, stored, err := encodeNotification(event)
+	if err != nil {
+		return err
+	}
+	if stored {
+		// listeners load the data straight away, so an hour is plenty
+		_, err := p.db.ExecContext(ctx, `
+			WITH pruned AS (DELETE FROM event_payloads WHERE created_at < now() - interval '1 hour')
+			INSERT INTO event_payloads (id, data) VALUES ($1, $2)`,
+			int64(event.ID), []byte(event.Data))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = p.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", Channel, string(payload))
+	return err
+}
+
+func (p *Postgres) Subscribe(h Handler) {
+	p.add(h)
+}
+
+func (p *Postgres) Close() error {
+	close(p.done)
+	return p.listener.Close()
+}
+
+func (p *Postgres) run() {
+	// an idle LISTEN connection can die silently, pinging notices that
+	check := time.NewTicker(90 * time.Second)
+	defer check.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case n, ok := <-p.listener.Notify:
+			if !ok {
+				return
+			}
+			p.handle(n)
+		case <-check.C:
+			go p.listener.Ping()
+		}
+	}
+}
+
+// handle decodes one notification. A nil notification means the
+// connection was re-established.
+func (p *Postgres) handle(n *pq.Notification) {
+	if n == nil {
+		return
+	}
+	received := notification{}
+	if err := json.Unmarshal([]byte(n.Extra), &received); err != nil {
+		log.Printf("Error decoding event: %v", err)
+		return
+	}
+	event := received.Event
+	if received.Stored {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := p.db.QueryRowContext(ctx, "SELECT data FROM event_payloads WHERE id = $1", int64(event.ID)).Scan(&event.Data)
+		if err != nil {
+			log.Printf("Error loading data of event %d: %v", event.ID, err)
+			return
+		}
+	}
+	p.dispatch(event)
+}
@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileMailerSend(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewFileMailer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Send(context.Background(), Message{To: "a@b.com", Subject: "hi", Body: "token=abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(entries))
+	}
+	data, _ := os.ReadFile(dir + "/" + entries[0].Name())
+	if !strings.Contains(string(data), "token=abc") || !strings.Contains(string(data), "To: a@b.com") {
+		t.Errorf("unexpected mail contents: %s", data)
+	}
+}
@@ -0,0 +1,78 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Moee1149/chirpy/internal/oidc"
+	"github.com/Moee1149/chirpy/internal/oidc/oidctest"
+)
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	provider := oidctest.NewProvider("chirpy", "secret")
+	defer provider.Server.Close()
+	provider.SetUser(oidctest.User{Subject: "user-1", Email: "a@example.com", EmailVerified: true})
+
+	client := &oidc.Client{
+		Issuer:       provider.Issuer(),
+		ClientID:     "chirpy",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/callback",
+		Scopes:       []string{"email"},
+	}
+	ctx := context.Background()
+	verifier, _ := oidc.NewCodeVerifier()
+	authURL, err := client.AuthCodeURL(ctx, "the-state", "the-nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := noRedirect.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := oidc.CallbackParams(location.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "the-state" {
+		t.Fatalf("state = %q", state)
+	}
+
+	if _, err := client.Exchange(ctx, code, "wrong-verifier", "the-nonce"); err == nil {
+		t.Fatal("exchange with the wrong verifier should fail")
+	}
+
+	// the failed attempt above burned the code, so fetch a fresh one
+	resp, _ = noRedirect.Get(authURL)
+	resp.Body.Close()
+	location, _ = url.Parse(resp.Header.Get("Location"))
+	code, _, _ = oidc.CallbackParams(location.Query())
+
+	if _, err := client.Exchange(ctx, code, verifier, "other-nonce"); err == nil {
+		t.Fatal("exchange with the wrong nonce should fail")
+	}
+
+	resp, _ = noRedirect.Get(authURL)
+	resp.Body.Close()
+	location, _ = url.Parse(resp.Header.Get("Location"))
+	code, _, _ = oidc.CallbackParams(location.Query())
+
+	idToken, err := client.Exchange(ctx, code, verifier, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken.Subject != "user-1" || idToken.Email != "a@example.com" || !idToken.EmailVerified {
+		t.Errorf("unexpected id token: %+v", idToken)
+	}
+}
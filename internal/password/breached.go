@@ -0,0 +1,45 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RangeDir checks passwords against a local copy of a k-anonymity range
+// list, laid out like the Have I Been Pwned range API: one file per five
+// character SHA-1 prefix, each line holding the remaining 35 hex characters
+// of a hash and a count, e.g. dir/5BAA6 containing
+// "1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493".
+type RangeDir struct {
+	Dir string
+}
+
+func (d RangeDir) IsBreached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := os.Open(filepath.Join(d.Dir, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
@@ -0,0 +1,81 @@
+// Package password decides whether a new password is acceptable.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// BreachChecker reports whether a password appears in a list of passwords
+// exposed in known data breaches.
+type BreachChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+type Policy struct {
+	MinLength int
+	// MaxLength bounds the input to Argon2 so huge passwords can't be used
+	// to burn CPU on the server.
+	MaxLength int
+	Breached  BreachChecker
+}
+
+var DefaultPolicy = Policy{
+	MinLength: 8,
+	MaxLength: 128,
+}
+
+// Validate returns every rule the password breaks, or nil if it is
+// acceptable. email is the account's address, which may not be reused as
+// the password.
+func (p Policy) Validate(password, email string) ([]Violation, error) {
+	var violations []Violation
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, Violation{
+			Rule:    "min_length",
+			Message: fmt.Sprintf("password must be at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Rule:    "max_length",
+			Message: fmt.Sprintf("password must be at most %d characters", p.MaxLength),
+		})
+	}
+	if email != "" && containsEmail(password, email) {
+		violations = append(violations, Violation{
+			Rule:    "not_email",
+			Message: "password must not contain your email address",
+		})
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.IsBreached(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Rule:    "breached",
+				Message: "password has appeared in a data breach, choose a different one",
+			})
+		}
+	}
+	return violations, nil
+}
+
+func containsEmail(password, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, ok := strings.Cut(email, "@")
+	return ok && len(local) >= 4 && password == local
+}
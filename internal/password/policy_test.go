@@ -0,0 +1,54 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func rules(vs []Violation) map[string]bool {
+	m := map[string]bool{}
+	for _, v := range vs {
+		m[v.Rule] = true
+	}
+	return m
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	// sha1("password") = 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	err := os.WriteFile(filepath.Join(dir, "5BAA6"), []byte("1E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := DefaultPolicy
+	p.Breached = RangeDir{Dir: dir}
+
+	cases := []struct {
+		password string
+		email    string
+		want     []string
+	}{
+		{"correct horse battery", "a@b.com", nil},
+		{"short", "a@b.com", []string{"min_length"}},
+		{"password", "a@b.com", []string{"breached"}},
+		{"x" + "walter@example.com", "walter@example.com", []string{"not_email"}},
+		{"walter", "walter@example.com", []string{"min_length", "not_email"}},
+	}
+	for _, c := range cases {
+		vs, err := p.Validate(c.password, c.email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := rules(vs)
+		if len(got) != len(c.want) {
+			t.Errorf("Validate(%q) = %v, want %v", c.password, vs, c.want)
+			continue
+		}
+		for _, r := range c.want {
+			if !got[r] {
+				t.Errorf("Validate(%q) missing rule %s", c.password, r)
+			}
+		}
+	}
+}
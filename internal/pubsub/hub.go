@@ -0,0 +1,103 @@
+// Package pubsub fans events out to in-process subscribers and keeps a
+// short history so a reconnecting client can catch up on what it missed.
+package pubsub
+
+import (
+	"sort"
+	"sync"
+)
+
+type Event struct {
+	// ID orders events. It comes from the publisher, so every instance
+	// gives the same event the same id.
+	ID uint64
+	// Type names the event, such as "chirp_created".
+	Type string
+	// Topic lets subscribers filter, for chirps it's the author's id.
+	Topic string
+	Data  []byte
+}
+
+// Subscription delivers events on C. C is closed when the subscription is
+// cancelled or when the subscriber falls too far behind; in the latter
+// case the client should reconnect and resume from the last id it saw.
+type Subscription struct {
+	C <-chan Event
+
+	hub *Hub
+	ch  chan Event
+}
+
+func (s *Subscription) Cancel() {
+	s.hub.remove(s)
+}
+
+type Hub struct {
+	mu      sync.Mutex
+	history []Event
+	limit   int
+	buffer  int
+	subs    map[*Subscription]struct{}
+}
+
+// NewHub keeps the last history events for resuming.
+func NewHub(history int) *Hub {
+	return &Hub{
+		limit:  history,
+		buffer: 64,
+		subs:   map[*Subscription]struct{}{},
+	}
+}
+
+// Publish delivers the event to every subscriber without blocking. Ids
+// should increase, but an event that arrives after a later one is still
+// kept in id order for replay.
+func (h *Hub) Publish(event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	i := sort.Search(len(h.history), func(i int) bool { return h.history[i].ID > event.ID })
+	h.history = append(h.history, Event{})
+	copy(h.history[i+1:], h.history[i:])
+	h.history[i] = event
+	if len(h.history) > h.limit {
+		h.history = h.history[len(h.history)-h.limit:]
+	}
+	for sub := range h.subs {
+		select {
+		case sub.ch <- event:
+		default:
+			// a slow reader is cut off rather than holding up everyone else
+			delete(h.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe registers a subscriber and returns the kept events with an id
+// after lastID. Nothing published after the replay is taken can be missed.
+// Pass 0 to skip the replay.
+func (h *Hub) Subscribe(lastID uint64) (*Subscription, []Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var replay []Event
+	if lastID > 0 {
+		for _, event := range h.history {
+			if event.ID > lastID {
+				replay = append(replay, event)
+			}
+		}
+	}
+	ch := make(chan Event, h.buffer)
+	sub := &Subscription{C: ch, hub: h, ch: ch}
+	h.subs[sub] = struct{}{}
+	return sub, replay
+}
+
+func (h *Hub) remove(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
@@ -0,0 +1,70 @@
+package pubsub
+
+import (
+	"testing"
+)
+
+func TestSubscribeReplaysMissedEvents(t *testing.T) {
+	h := NewHub(3)
+	for i, kind := range []string{"a", "b", "c", "d"} {
+		h.Publish(Event{ID: uint64(i + 1), Type: kind})
+	}
+	sub, replay := h.Subscribe(1)
+	defer sub.Cancel()
+	var got []string
+	for _, event := range replay {
+		got = append(got, event.Type)
+	}
+	if len(got) != 3 || got[0] != "b" || got[2] != "d" {
+		t.Fatalf("replay = %v, want [b c d]", got)
+	}
+
+	h.Publish(Event{ID: 5, Type: "e", Topic: "topic", Data: []byte("x")})
+	event := <-sub.C
+	if event.ID != 5 || event.Type != "e" || event.Topic != "topic" || string(event.Data) != "x" {
+		t.Errorf("live event = %+v", event)
+	}
+}
+
+func TestReplayKeepsIDOrder(t *testing.T) {
+	h := NewHub(10)
+	// events from different instances can reach the bus out of order
+	for _, id := range []uint64{10, 12, 11, 13} {
+		h.Publish(Event{ID: id})
+	}
+	sub, replay := h.Subscribe(10)
+	defer sub.Cancel()
+	var got []uint64
+	for _, event := range replay {
+		got = append(got, event.ID)
+	}
+	if len(got) != 3 || got[0] != 11 || got[1] != 12 || got[2] != 13 {
+		t.Errorf("replay ids = %v, want [11 12 13]", got)
+	}
+}
+
+func TestSubscribeWithoutLastIDSkipsHistory(t *testing.T) {
+	h := NewHub(10)
+	h.Publish(Event{ID: 1, Type: "a"})
+	sub, replay := h.Subscribe(0)
+	defer sub.Cancel()
+	if len(replay) != 0 {
+		t.Errorf("replay = %v, want none", replay)
+	}
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	h := NewHub(10)
+	h.buffer = 1
+	sub, _ := h.Subscribe(0)
+	h.Publish(Event{ID: 1, Type: "a"})
+	h.Publish(Event{ID: 2, Type: "b"})
+	if event, ok := <-sub.C; !ok || event.Type != "a" {
+		t.Fatalf("first receive = %+v, %v", event, ok)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Fatal("subscription still open after overflow")
+	}
+	// cancelling a dropped subscription is harmless
+	sub.Cancel()
+}
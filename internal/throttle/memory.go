@@ -0,0 +1,64 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps counters in process. Counters are lost on restart and
+// not shared between instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]State{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[key], nil
+}
+
+func (s *MemoryStore) Increment(ctx context.Context, key string, at, since time.Time) (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.entries[key]
+	if state.LastFailure.Before(since) {
+		state = State{}
+	}
+	state.Failures++
+	state.LastFailure = at
+	s.entries[key] = state
+	return state, nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.entries[key]
+	state.LockedUntil = until
+	s.entries[key] = state
+	return nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) Prune(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, state := range s.entries {
+		if state.LastFailure.Before(before) && state.LockedUntil.Before(before) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
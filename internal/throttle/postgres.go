@@ -0,0 +1,61 @@
+package throttle
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/database"
+)
+
+// PostgresStore keeps counters in the login_failures table so lockouts
+// apply across every running instance.
+type PostgresStore struct {
+	Queries *database.Queries
+}
+
+func toState(row database.LoginFailure) State {
+	state := State{Failures: int(row.Failures), LastFailure: row.UpdatedAt}
+	if row.LockedUntil.Valid {
+		state.LockedUntil = row.LockedUntil.Time
+	}
+	return state
+}
+
+func (s PostgresStore) Get(ctx context.Context, key string) (State, error) {
+	row, err := s.Queries.GetLoginFailure(ctx, key)
+	if err == sql.ErrNoRows {
+		return State{}, nil
+	}
+	if err != nil {
+		return State{}, err
+	}
+	return toState(row), nil
+}
+
+func (s PostgresStore) Increment(ctx context.Context, key string, at, since time.Time) (State, error) {
+	row, err := s.Queries.IncrementLoginFailure(ctx, database.IncrementLoginFailureParams{
+		Key:      key,
+		FailedAt: at,
+		Since:    since,
+	})
+	if err != nil {
+		return State{}, err
+	}
+	return toState(row), nil
+}
+
+func (s PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	return s.Queries.SetLoginLockedUntil(ctx, database.SetLoginLockedUntilParams{
+		LockedUntil: sql.NullTime{Time: until, Valid: true},
+		Key:         key,
+	})
+}
+
+func (s PostgresStore) Reset(ctx context.Context, key string) error {
+	return s.Queries.DeleteLoginFailure(ctx, key)
+}
+
+func (s PostgresStore) Prune(ctx context.Context, before time.Time) error {
+	return s.Queries.DeleteStaleLoginFailures(ctx, before)
+}
@@ -0,0 +1,105 @@
+// Package throttle slows down repeated failures, such as wrong passwords,
+// with exponential backoff and temporary lockouts.
+package throttle
+
+import (
+	"context"
+	"time"
+)
+
+type State struct {
+	Failures    int
+	LockedUntil time.Time
+	LastFailure time.Time
+}
+
+// Store keeps failure counters. Use the Postgres store when more than one
+// instance serves traffic so every instance sees the same counters.
+type Store interface {
+	Get(ctx context.Context, key string) (State, error)
+	// Increment records a failure at at. If the previous failure was
+	// before since the count and any lockout start over.
+	Increment(ctx context.Context, key string, at, since time.Time) (State, error)
+	Lock(ctx context.Context, key string, until time.Time) error
+	Reset(ctx context.Context, key string) error
+	// Prune deletes counters whose last failure and lockout are both
+	// before before.
+	Prune(ctx context.Context, before time.Time) error
+}
+
+type Limiter struct {
+	Store Store
+	// Threshold is how many failures are allowed before lockouts start.
+	Threshold int
+	// BaseDelay is the first lockout, doubled on every further failure up
+	// to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// Window is how long failures are remembered after the last one, so
+	// a key that stops failing eventually starts from zero again. Zero
+	// remembers them until Reset.
+	Window time.Duration
+
+	Now func() time.Time
+}
+
+func (l *Limiter) now() time.Time {
+	if l.Now != nil {
+		return l.Now()
+	}
+	return time.Now()
+}
+
+// Check returns how long the caller must wait before trying key again, or
+// zero if an attempt is allowed now.
+func (l *Limiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	state, err := l.Store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return l.retryAfter(state), nil
+}
+
+// Fail records a failed attempt and returns the resulting lockout, if any.
+func (l *Limiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	now := l.now()
+	var since time.Time
+	if l.Window > 0 {
+		since = now.Add(-l.Window)
+	}
+	state, err := l.Store.Increment(ctx, key, now, since)
+	if err != nil {
+		return 0, err
+	}
+	if state.Failures < l.Threshold {
+		return 0, nil
+	}
+	delay := l.delay(state.Failures)
+	if err := l.Store.Lock(ctx, key, now.Add(delay)); err != nil {
+		return 0, err
+	}
+	return delay, nil
+}
+
+func (l *Limiter) Reset(ctx context.Context, key string) error {
+	return l.Store.Reset(ctx, key)
+}
+
+func (l *Limiter) delay(failures int) time.Duration {
+	delay := l.BaseDelay
+	for i := l.Threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= l.MaxDelay {
+			return l.MaxDelay
+		}
+	}
+	return min(delay, l.MaxDelay)
+}
+
+func (l *Limiter) retryAfter(state State) time.Duration {
+	wait := state.LockedUntil.Sub(l.now())
+	if wait <= 0 {
+		return 0
+	}
+	return wait
+}
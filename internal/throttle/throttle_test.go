@@ -0,0 +1,89 @@
+package throttle
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiterBackoff(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	l := &Limiter{
+		Store:     NewMemoryStore(),
+		Threshold: 3,
+		BaseDelay: time.Second,
+		MaxDelay:  5 * time.Second,
+		Now:       func() time.Time { return now },
+	}
+	want := []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		got, err := l.Fail(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != w {
+			t.Errorf("failure %d: lockout %v, want %v", i+1, got, w)
+		}
+	}
+	if wait, _ := l.Check(ctx, "k"); wait != 5*time.Second {
+		t.Errorf("Check = %v, want 5s", wait)
+	}
+	now = now.Add(6 * time.Second)
+	if wait, _ := l.Check(ctx, "k"); wait != 0 {
+		t.Errorf("Check after lockout = %v, want 0", wait)
+	}
+	l.Reset(ctx, "k")
+	if got, _ := l.Fail(ctx, "k"); got != 0 {
+		t.Errorf("after reset lockout = %v, want 0", got)
+	}
+}
+
+func TestLimiterWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	l := &Limiter{
+		Store:     NewMemoryStore(),
+		Threshold: 2,
+		BaseDelay: time.Second,
+		MaxDelay:  time.Minute,
+		Window:    time.Hour,
+		Now:       func() time.Time { return now },
+	}
+	l.Fail(ctx, "k")
+	l.Fail(ctx, "k")
+	if got, _ := l.Fail(ctx, "k"); got != 2*time.Second {
+		t.Fatalf("third failure lockout = %v, want 2s", got)
+	}
+
+	// failing again inside the window keeps counting
+	now = now.Add(30 * time.Minute)
+	if got, _ := l.Fail(ctx, "k"); got != 4*time.Second {
+		t.Errorf("failure inside window lockout = %v, want 4s", got)
+	}
+
+	// an hour without failures and the count starts over
+	now = now.Add(61 * time.Minute)
+	if got, _ := l.Fail(ctx, "k"); got != 0 {
+		t.Errorf("failure after window lockout = %v, want 0", got)
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	now := time.Unix(1000, 0)
+	store.Increment(ctx, "old", now.Add(-2*time.Hour), time.Time{})
+	store.Increment(ctx, "locked", now.Add(-2*time.Hour), time.Time{})
+	store.Lock(ctx, "locked", now.Add(time.Minute))
+	store.Increment(ctx, "recent", now, time.Time{})
+
+	if err := store.Prune(ctx, now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]int{"old": 0, "locked": 1, "recent": 1} {
+		if state, _ := store.Get(ctx, key); state.Failures != want {
+			t.Errorf("%s: failures = %d, want %d", key, state.Failures, want)
+		}
+	}
+}
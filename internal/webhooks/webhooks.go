@@ -0,0 +1,217 @@
+// Package webhooks signs and sends the events Chirpy delivers to endpoints
+// registered by its users.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SignatureHeader = "Chirpy-Signature"
+	EventHeader     = "Chirpy-Event"
+	DeliveryHeader  = "Chirpy-Delivery"
+
+	secretPrefix = "whsec_"
+	// maxDrain is how much of a response body is read, and thrown away, so
+	// the connection can be reused
+	maxDrain = 4096
+)
+
+// MakeSecret returns a new signing secret for an endpoint.
+func MakeSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + hex.EncodeToString(b), nil
+}
+
+// Sign computes the Chirpy-Signature header for body sent at timestamp:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". Signing the
+// timestamp along with the body lets receivers reject replayed requests.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac(secret, t, body))
+}
+
+func mac(secret, t string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(t))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Verify checks a Chirpy-Signature style header the way a receiver should,
+// rejecting signatures older than tolerance. The body is accepted if any of
+// secrets signed it, so a secret can be rotated without dropping events.
+func Verify(header string, body []byte, tolerance time.Duration, secrets ...string) error {
+	var t string
+	var sigs [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return errors.New("malformed signature header")
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return errors.New("signature timestamp outside tolerance")
+	}
+	for _, secret := range secrets {
+		expected := mac(secret, t, body)
+		for _, sig := range sigs {
+			if hmac.Equal(sig, expected) {
+				return nil
+			}
+		}
+	}
+	return errors.New("signature mismatch")
+}
+
+type Delivery struct {
+	ID     string
+	Event  string
+	URL    string
+	Secret string
+	Body   []byte
+}
+
+// Result is what came back from one attempt. StatusCode is 0 when no
+// response was received. The response body is never kept, so a delivery
+// can't be used to read what an endpoint serves.
+type Result struct {
+	StatusCode int
+}
+
+// ErrForbiddenAddress is returned for endpoints that resolve to an address
+// Chirpy won't send to.
+var ErrForbiddenAddress = errors.New("webhooks: endpoint address is not public")
+
+// nonPublic lists ranges that aren't reachable on the internet but that
+// the standard library's checks below don't cover.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// IsPublicAddr reports whether webhooks may be sent to addr. Loopback,
+// private, link-local (which includes cloud metadata services), multicast
+// and reserved addresses are refused.
+func IsPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+type Sender struct {
+	Client *http.Client
+}
+
+// NewSender returns a Sender that only connects to public addresses. The
+// check runs on the address actually dialled, after DNS resolution, so a
+// hostname that later starts resolving somewhere internal is still caught.
+func NewSender() *Sender {
+	return newSender(IsPublicAddr)
+}
+
+func newSender(allow func(netip.Addr) bool) *Sender {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !allow(addrPort.Addr()) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+	return &Sender{Client: &http.Client{
+		Timeout: 10 * time.Second,
+		// no Proxy: going through one would dial the proxy rather than the
+		// endpoint and skip the address check
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// a redirect would resend the signed body somewhere the owner didn't
+		// register
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// Send makes one delivery attempt. Any 2xx response is a success; other
+// statuses come back as an error alongside the result.
+func (s *Sender) Send(ctx context.Context, d Delivery) (Result, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, d.ID)
+	req.Header.Set(SignatureHeader, Sign(d.Secret, time.Now(), d.Body))
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrain))
+	result := Result{StatusCode: resp.StatusCode}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return result, fmt.Errorf("endpoint responded %d", resp.StatusCode)
+	}
+	return result, nil
+}
+
+// Backoff is how long to wait after the given number of failed attempts:
+// 30s doubling each time, capped at six hours.
+func Backoff(attempts int) time.Duration {
+	const base, max = 30 * time.Second, 6 * time.Hour
+	if attempts < 1 {
+		return base
+	}
+	if attempts > 20 {
+		return max
+	}
+	return min(base<<(attempts-1), max)
+}
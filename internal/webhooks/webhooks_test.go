@@ -0,0 +1,176 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"type":"chirp.created"}`)
+	header := Sign("whsec_test", time.Now(), body)
+	if err := Verify(header, body, 5*time.Minute, "whsec_test"); err != nil {
+		t.Fatalf("expected valid signature, got %v", err)
+	}
+	if err := Verify(header, body, 5*time.Minute, "whsec_other"); err == nil {
+		t.Error("expected wrong secret to fail")
+	}
+	if err := Verify(header, []byte(`{"type":"chirp.deleted"}`), 5*time.Minute, "whsec_test"); err == nil {
+		t.Error("expected tampered body to fail")
+	}
+	old := Sign("whsec_test", time.Now().Add(-time.Hour), body)
+	if err := Verify(old, body, 5*time.Minute, "whsec_test"); err == nil {
+		t.Error("expected stale signature to fail")
+	}
+	future := Sign("whsec_test", time.Now().Add(time.Hour), body)
+	if err := Verify(future, body, 5*time.Minute, "whsec_test"); err == nil {
+		t.Error("expected future signature to fail")
+	}
+	if err := Verify("v1=abc", body, 5*time.Minute, "whsec_test"); err == nil {
+		t.Error("expected malformed header to fail")
+	}
+	if err := Verify(header, body, 5*time.Minute); err == nil {
+		t.Error("expected no secrets to fail")
+	}
+}
+
+func TestVerifyRotation(t *testing.T) {
+	body := []byte(`{"event":"user.upgraded"}`)
+	header := Sign("old", time.Now(), body)
+	if err := Verify(header, body, time.Minute, "new", "old"); err != nil {
+		t.Errorf("expected previous secret to verify, got %v", err)
+	}
+	header = Sign("new", time.Now(), body)
+	if err := Verify(header, body, time.Minute, "new", "old"); err != nil {
+		t.Errorf("expected current secret to verify, got %v", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.WriteHeader(204)
+	}))
+	defer srv.Close()
+
+	d := Delivery{ID: "d1", Event: "chirp.created", URL: srv.URL, Secret: "whsec_test", Body: []byte(`{"id":"e1"}`)}
+	result, err := testSender().Send(context.Background(), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StatusCode != 204 {
+		t.Errorf("expected 204, got %d", result.StatusCode)
+	}
+	if got.Header.Get(EventHeader) != "chirp.created" || got.Header.Get(DeliveryHeader) != "d1" {
+		t.Errorf("unexpected headers: %v", got.Header)
+	}
+	if err := Verify(got.Header.Get(SignatureHeader), gotBody, time.Minute, "whsec_test"); err != nil {
+		t.Errorf("receiver couldn't verify signature: %v", err)
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+		io.WriteString(w, strings.Repeat("x", 2*maxDrain))
+	}))
+	defer srv.Close()
+
+	result, err := testSender().Send(context.Background(), Delivery{URL: srv.URL, Secret: "s"})
+	if err == nil {
+		t.Fatal("expected error for 500 response")
+	}
+	if result.StatusCode != 500 {
+		t.Errorf("unexpected status %d", result.StatusCode)
+	}
+}
+
+// testSender can reach the loopback test servers that NewSender refuses.
+func testSender() *Sender {
+	return newSender(func(netip.Addr) bool { return true })
+}
+
+func TestSendRefusesNonPublicAddresses(t *testing.T) {
+	reached := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	defer srv.Close()
+
+	for _, url := range []string{srv.URL, "http://169.254.169.254/latest/meta-data/", "http://[::1]:9/", "http://10.0.0.1:9/"} {
+		_, err := NewSender().Send(context.Background(), Delivery{URL: url, Secret: "s"})
+		if !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("%s: err = %v, want ErrForbiddenAddress", url, err)
+		}
+	}
+	if reached {
+		t.Error("loopback endpoint was reached")
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.215.14":        true,
+		"2606:2800:21f:cb07::": true,
+		"127.0.0.1":            false,
+		"::1":                  false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"fe80::1":              false,
+		"fd00::1":              false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"224.0.0.1":            false,
+		"::ffff:127.0.0.1":     false,
+		"64:ff9b::a00:1":       false,
+	}
+	for raw, want := range cases {
+		if got := IsPublicAddr(netip.MustParseAddr(raw)); got != want {
+			t.Errorf("IsPublicAddr(%s) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestSendDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		followed = true
+	}))
+	defer target.Close()
+	srv := httptest.NewServer(http.RedirectHandler(target.URL, 307))
+	defer srv.Close()
+
+	if _, err := testSender().Send(context.Background(), Delivery{URL: srv.URL, Secret: "s"}); err == nil {
+		t.Error("expected redirect to count as a failure")
+	}
+	if followed {
+		t.Error("redirect was followed")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		0:  30 * time.Second,
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		12: 6 * time.Hour,
+		99: 6 * time.Hour,
+	}
+	for attempts, want := range cases {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
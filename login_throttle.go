@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// mfaThrottleKey counts wrong second factors. Six digit codes are guessable
+// too, so they share the account limiter.
+func mfaThrottleKey(userId uuid.UUID) string {
+	return "mfa:" + userId.String()
+}
+
+// checkLoginThrottle responds with 429 and returns false when either the
+// account or the client IP is locked out.
+func (cfg *apiConfig) checkLoginThrottle(w http.ResponseWriter, r *http.Request, accountKey string) bool {
+	wait, err := cfg.accountLimiter.Check(r.Context(), accountKey)
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return false
+	}
+	ipWait, err := cfg.ipLimiter.Check(r.Context(), ipThrottleKey(clientIP(r)))
+	if err != nil {
+		responsdWithError(w, 500, "Internal Server Error")
+		return false
+	}
+	wait = max(wait, ipWait)
+	if wait > 0 {
+		respondTooManyAttempts(w, wait)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) recordLoginFailure(ctx context.Context, accountKey, ip string) {
+	if _, err := cfg.accountLimiter.Fail(ctx, accountKey); err != nil {
+		log.Printf("Error recording login failure: %v", err)
+	}
+	if _, err := cfg.ipLimiter.Fail(ctx, ipThrottleKey(ip)); err != nil {
+		log.Printf("Error recording login failure: %v", err)
+	}
+}
+
+// recordLoginSuccess clears the account's counter. The client IP's is left
+// to decay through its window: resetting it would let an attacker log into
+// their own account between guesses at someone else's.
+func (cfg *apiConfig) recordLoginSuccess(ctx context.Context, accountKey string) {
+	if err := cfg.accountLimiter.Reset(ctx, accountKey); err != nil {
+		log.Printf("Error resetting login failures: %v", err)
+	}
+}
+
+// checkCurrentPassword asks for the caller's password again before a
+// sensitive change. A wrong one counts against the same account and IP
+// limits as a failed login, so it can't be used to guess the password at
+// leisure. It responds itself and returns false when the check fails.
+func (cfg *apiConfig) checkCurrentPassword(w http.ResponseWriter, r *http.Request, user database.User, password string) bool {
+	accountKey := accountThrottleKey(user.Email)
+	if !cfg.checkLoginThrottle(w, r, accountKey) {
+		return false
+	}
+	match, err := auth.CheckPasswordHash(password, user.HashedPassword)
+	if err != nil || !match {
+		cfg.recordLoginFailure(r.Context(), accountKey, clientIP(r))
+		responsdWithError(w, 401, "Incorrect password")
+		return false
+	}
+	cfg.recordLoginSuccess(r.Context(), accountKey)
+	return true
+}
+
+// pruneLoginFailures deletes counters that have decayed. Both limiters
+// share a store, so it waits out the longer of their windows. It runs until
+// ctx is cancelled.
+func (cfg *apiConfig) pruneLoginFailures(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		window := max(cfg.accountLimiter.Window, cfg.ipLimiter.Window)
+		if err := cfg.accountLimiter.Store.Prune(ctx, time.Now().Add(-window)); err != nil {
+			log.Printf("Error pruning login failures: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func respondTooManyAttempts(w http.ResponseWriter, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprint(seconds))
+	responsdWithError(w, 429, fmt.Sprintf("Too many failed attempts, try again in %d seconds", seconds))
+}
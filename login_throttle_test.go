@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func login(t *testing.T, cfg *apiConfig, email, password string) int {
+	t.Helper()
+	return do(t, cfg.handleUserLogin, "POST", "/api/login", "", map[string]string{
+		"email":    email,
+		"password": password,
+	}).Code
+}
+
+func TestLoginSuccessResetsOnlyAccountCounter(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	ctx := context.Background()
+
+	for range 3 {
+		if code := login(t, cfg, user.Email, "wrong password"); code != 401 {
+			t.Fatalf("status = %d, want 401", code)
+		}
+	}
+	if code := login(t, cfg, user.Email, "correct horse battery"); code != 200 {
+		t.Fatalf("status = %d, want 200", code)
+	}
+	if state, _ := cfg.accountLimiter.Store.Get(ctx, accountThrottleKey(user.Email)); state.Failures != 0 {
+		t.Errorf("account failures = %d after a successful login", state.Failures)
+	}
+	// httptest requests come from 192.0.2.1
+	if state, _ := cfg.ipLimiter.Store.Get(ctx, ipThrottleKey("192.0.2.1")); state.Failures != 3 {
+		t.Errorf("ip failures = %d, want the 3 to stand", state.Failures)
+	}
+}
+
+func TestLoginSuccessKeepsIPDelayForOtherAccounts(t *testing.T) {
+	cfg := testDBConfig(t)
+	own, _ := createTestUser(t, cfg)
+	victim, _ := createTestUser(t, cfg)
+	ctx := context.Background()
+	ipKey := ipThrottleKey("192.0.2.1")
+
+	// guesses at other accounts, one short of the IP lockout
+	for range cfg.ipLimiter.Threshold - 1 {
+		cfg.ipLimiter.Fail(ctx, ipKey)
+	}
+	if code := login(t, cfg, own.Email, "correct horse battery"); code != 200 {
+		t.Fatalf("own login: status = %d, want 200", code)
+	}
+	if code := login(t, cfg, victim.Email, "wrong password"); code != 401 {
+		t.Fatalf("guess: status = %d, want 401", code)
+	}
+	if wait, _ := cfg.ipLimiter.Check(ctx, ipKey); wait == 0 {
+		t.Error("logging into one account cleared the IP delay built up against another")
+	}
+	if code := login(t, cfg, victim.Email, "wrong password"); code != 429 {
+		t.Errorf("next guess: status = %d, want 429", code)
+	}
+}
+
+func TestUnlockClearsMFACounter(t *testing.T) {
+	cfg := testDBConfig(t)
+	user, _ := createTestUser(t, cfg)
+	ctx := context.Background()
+	key := mfaThrottleKey(user.ID)
+	for range cfg.accountLimiter.Threshold {
+		cfg.accountLimiter.Fail(ctx, key)
+	}
+	if wait, _ := cfg.accountLimiter.Check(ctx, key); wait == 0 {
+		t.Fatal("expected the mfa key to be locked")
+	}
+
+	rec := do(t, cfg.handleUnlockAccount, "POST", "/admin/unlock", "", map[string]string{"email": user.Email})
+	if rec.Code != 204 {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if wait, _ := cfg.accountLimiter.Check(ctx, key); wait != 0 {
+		t.Errorf("mfa key still locked for %v", wait)
+	}
+}
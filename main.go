@@ -1,23 +1,58 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/Moee1149/chirpy/internal/auth"
 	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/eventbus"
+	"github.com/Moee1149/chirpy/internal/mailer"
+	"github.com/Moee1149/chirpy/internal/oidc"
+	"github.com/Moee1149/chirpy/internal/password"
+	"github.com/Moee1149/chirpy/internal/pubsub"
+	"github.com/Moee1149/chirpy/internal/throttle"
+	"github.com/Moee1149/chirpy/internal/webhooks"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
 	fileServerHits atomic.Int32
+	db             *sql.DB
 	dbQueries      *database.Queries
 	jwtKey         string
-	polkaKey       string
+	mailer         mailer.Mailer
+	// requireVerifiedEmail blocks chirp creation until the author's email
+	// address has been confirmed
+	requireVerifiedEmail bool
+	accountLimiter       *throttle.Limiter
+	ipLimiter            *throttle.Limiter
+	passwordPolicy       password.Policy
+	oidcClient           *oidc.Client
+	oidcProvider         string
+	deletionGracePeriod  time.Duration
+	exportDir            string
+	chirpHub             *pubsub.Hub
+	userHub              *pubsub.Hub
+	bus                  eventbus.Bus
+	webhookSender        *webhooks.Sender
+	webhookWake          chan struct{}
+	impressions          chan impression
+	// polkaSecrets sign Polka webhooks, the current one first and the one
+	// being rotated out second
+	polkaSecrets []string
+	// polkaKey is the static ApiKey Polka sent before it signed webhooks,
+	// only set when POLKA_ALLOW_LEGACY_KEY opts back into it
+	polkaKey string
 }
 
 func (cfg *apiConfig) middlewareMetrics(next http.Handler) http.Handler {
@@ -41,16 +76,109 @@ func main() {
 	dbUrl := os.Getenv("DB_URL")
 	key := os.Getenv("JWT_KEY")
 	platform := os.Getenv("PLATFORM")
-	polka_key := os.Getenv("POLKA_KEY")
+	var polkaSecrets []string
+	for _, name := range []string{"POLKA_WEBHOOK_SECRET", "POLKA_WEBHOOK_SECRET_PREVIOUS"} {
+		if secret := os.Getenv(name); secret != "" {
+			polkaSecrets = append(polkaSecrets, secret)
+		}
+	}
+	// the ApiKey has no signature, timestamp or replay protection, so it is
+	// only accepted when asked for explicitly
+	var polka_key string
+	if os.Getenv("POLKA_WEBHOOK_SECRET") == "" {
+		polka_key = os.Getenv("POLKA_KEY")
+		if os.Getenv("POLKA_ALLOW_LEGACY_KEY") != "true" || polka_key == "" {
+			log.Fatal("POLKA_WEBHOOK_SECRET must be set")
+		}
+		log.Print("Warning: POLKA_WEBHOOK_SECRET is not set, accepting unsigned Polka webhooks with POLKA_KEY")
+	}
+	argonParams, err := argon2ParamsFromEnv()
+	if err != nil {
+		log.Fatalf("Error reading argon2 config: %v", err)
+	}
+	auth.SetPasswordParams(argonParams)
 	db, err := sql.Open("postgres", dbUrl)
 	if err != nil {
 		log.Fatalf("Error Connection Database: %v", err)
 	}
 	dbQueries := database.New(db)
+	var mail mailer.Mailer = mailer.LogMailer{}
+	if mailDir := os.Getenv("MAIL_DIR"); mailDir != "" {
+		mail, err = mailer.NewFileMailer(mailDir)
+		if err != nil {
+			log.Fatalf("Error creating mail dir: %v", err)
+		}
+	}
+	var throttleStore throttle.Store = throttle.PostgresStore{Queries: dbQueries}
+	if os.Getenv("LOGIN_THROTTLE_STORE") == "memory" {
+		throttleStore = throttle.NewMemoryStore()
+	}
+	// EVENT_BUS=memory keeps events inside this process, fine when only one
+	// instance runs
+	var bus eventbus.Bus = eventbus.NewLocal()
+	if os.Getenv("EVENT_BUS") != "memory" {
+		bus = eventbus.NewPostgres(db, dbUrl)
+	}
+	passwordPolicy := password.DefaultPolicy
+	if breachedDir := os.Getenv("BREACHED_PASSWORDS_DIR"); breachedDir != "" {
+		passwordPolicy.Breached = password.RangeDir{Dir: breachedDir}
+	}
 	apiConfig := apiConfig{
+		db:        db,
 		dbQueries: dbQueries,
 		jwtKey:    key,
 		polkaKey:  polka_key,
+		mailer:    mail,
+
+		requireVerifiedEmail: os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true",
+		polkaSecrets:         polkaSecrets,
+		accountLimiter: &throttle.Limiter{
+			Store:     throttleStore,
+			Threshold: 5,
+			BaseDelay: 30 * time.Second,
+			MaxDelay:  15 * time.Minute,
+			Window:    24 * time.Hour,
+		},
+		ipLimiter: &throttle.Limiter{
+			Store:     throttleStore,
+			Threshold: 20,
+			BaseDelay: 30 * time.Second,
+			MaxDelay:  time.Hour,
+			Window:    6 * time.Hour,
+		},
+		passwordPolicy: passwordPolicy,
+
+		deletionGracePeriod: 30 * 24 * time.Hour,
+		exportDir:           filepath.Join(os.TempDir(), "chirpy-exports"),
+		chirpHub:            pubsub.NewHub(streamHistory),
+		userHub:             pubsub.NewHub(streamHistory),
+		bus:                 bus,
+		webhookSender:       webhooks.NewSender(),
+		webhookWake:         make(chan struct{}, 1),
+		impressions:         make(chan impression, impressionQueueSize),
+	}
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		apiConfig.exportDir = dir
+	}
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		apiConfig.oidcProvider = os.Getenv("OIDC_PROVIDER_NAME")
+		if apiConfig.oidcProvider == "" {
+			apiConfig.oidcProvider = "oidc"
+		}
+		apiConfig.oidcClient = &oidc.Client{
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       []string{"email"},
+		}
+		// OIDC_ISSUER=dev runs a stand-in provider in process, only in dev
+		// builds
+		if issuer == "dev" && strings.ToLower(platform) == "dev" {
+			if err := startDevOIDCProvider(apiConfig.oidcClient); err != nil {
+				log.Fatalf("Error starting dev OIDC provider: %v", err)
+			}
+		}
 	}
 	mux := http.NewServeMux()
 	server := &http.Server{
@@ -60,23 +188,100 @@ func main() {
 
 	mux.Handle("/app/", http.StripPrefix("/app", apiConfig.middlewareMetrics(http.FileServer(http.Dir(".")))))
 	mux.HandleFunc("GET /api/healthz", handleHealthz)
-	mux.HandleFunc("GET /admin/metrics", apiConfig.handleMetrics)
-	mux.HandleFunc("POST /admin/reset", apiConfig.handleReset(platform))
+	admin := func(h http.HandlerFunc) http.Handler {
+		return apiConfig.middlewareRequireRole(auth.RoleAdmin, h)
+	}
+	mux.Handle("GET /admin/metrics", admin(apiConfig.handleMetrics))
+	mux.Handle("POST /admin/reset", admin(apiConfig.handleReset(platform)))
+	mux.Handle("POST /admin/unlock", admin(apiConfig.handleUnlockAccount))
+	mux.Handle("PUT /admin/users/{userID}/role", admin(apiConfig.handleSetUserRole))
+	mux.Handle("GET /admin/webhook-events", admin(apiConfig.handleListWebhookEvents))
+	mux.Handle("POST /admin/webhook-events/{eventID}/replay", admin(apiConfig.handleReplayWebhookEvent))
 	mux.HandleFunc("POST /api/refresh", apiConfig.handleRefreshToken)
 	mux.HandleFunc("POST /api/revoke", apiConfig.hanldeRevokeToken)
 
 	mux.HandleFunc("POST /api/users", apiConfig.handleCreateUser)
 	mux.HandleFunc("PUT /api/users", apiConfig.handleUpdateUserInfo)
 	mux.HandleFunc("POST /api/login", apiConfig.handleUserLogin)
+	mux.HandleFunc("POST /api/login/mfa", apiConfig.handleLoginMFA)
+	if apiConfig.oidcClient != nil {
+		mux.HandleFunc("GET /api/auth/oidc/login", apiConfig.handleOIDCLogin)
+		mux.HandleFunc("GET /api/auth/oidc/callback", apiConfig.handleOIDCCallback)
+	}
+	mux.HandleFunc("POST /api/password-reset", apiConfig.handleRequestPasswordReset)
+	mux.HandleFunc("POST /api/password-reset/confirm", apiConfig.handleConfirmPasswordReset)
+	mux.HandleFunc("POST /api/email-verification", apiConfig.handleResendEmailVerification)
+	mux.HandleFunc("POST /api/email-verification/confirm", apiConfig.handleConfirmEmailVerification)
+
+	mux.HandleFunc("POST /api/users/me/mfa/totp", apiConfig.handleEnrollTOTP)
+	mux.HandleFunc("POST /api/users/me/mfa/totp/confirm", apiConfig.handleConfirmTOTP)
+
+	mux.HandleFunc("GET /api/users/me", apiConfig.handleGetMe)
+	mux.HandleFunc("GET /api/users/me/subscription", apiConfig.handleGetSubscription)
+	mux.HandleFunc("GET /api/users/me/stats", apiConfig.handleGetMyStats)
+	mux.HandleFunc("PATCH /api/users/me", apiConfig.handleUpdateProfile)
+	mux.HandleFunc("GET /api/users/{handleOrID}", apiConfig.handleGetPublicProfile)
+	mux.HandleFunc("DELETE /api/users/me", apiConfig.handleDeleteAccount)
+	mux.HandleFunc("POST /api/users/me/export", apiConfig.handleCreateDataExport)
+	mux.HandleFunc("GET /api/users/me/exports/{exportID}", apiConfig.handleGetDataExport)
+	mux.HandleFunc("GET /api/users/me/exports/{exportID}/download", apiConfig.handleDownloadDataExport)
+
+	mux.HandleFunc("POST /api/users/me/keys", apiConfig.handleCreateApiKey)
+	mux.HandleFunc("GET /api/users/me/keys", apiConfig.handleListApiKeys)
+	mux.HandleFunc("DELETE /api/users/me/keys/{keyID}", apiConfig.handleRevokeApiKey)
+
+	mux.HandleFunc("POST /api/users/me/blocks", apiConfig.handleBlockUser)
+	mux.HandleFunc("GET /api/users/me/blocks", apiConfig.handleListBlocks)
+	mux.HandleFunc("DELETE /api/users/me/blocks/{userID}", apiConfig.handleUnblockUser)
+	mux.HandleFunc("POST /api/users/me/mutes", apiConfig.handleMuteUser)
+	mux.HandleFunc("GET /api/users/me/mutes", apiConfig.handleListMutes)
+	mux.HandleFunc("DELETE /api/users/me/mutes/{userID}", apiConfig.handleUnmuteUser)
+
+	mux.HandleFunc("POST /api/conversations", apiConfig.handleCreateConversation)
+	mux.HandleFunc("GET /api/conversations", apiConfig.handleListConversations)
+	mux.HandleFunc("GET /api/conversations/{conversationID}", apiConfig.handleGetConversation)
+	mux.HandleFunc("POST /api/conversations/{conversationID}/messages", apiConfig.handleSendMessage)
+	mux.HandleFunc("GET /api/conversations/{conversationID}/messages", apiConfig.handleListMessages)
+	mux.HandleFunc("POST /api/conversations/{conversationID}/read", apiConfig.handleMarkConversationRead)
+
+	mux.HandleFunc("GET /api/notifications", apiConfig.handleListNotifications)
+	mux.HandleFunc("POST /api/notifications/{notificationID}/read", apiConfig.handleMarkNotificationRead)
+	mux.HandleFunc("POST /api/notifications/read", apiConfig.handleMarkAllNotificationsRead)
+	mux.HandleFunc("GET /api/notifications/preferences", apiConfig.handleGetNotificationPreferences)
+	mux.HandleFunc("PUT /api/notifications/preferences", apiConfig.handleUpdateNotificationPreferences)
 
 	mux.HandleFunc("POST /api/chirps", apiConfig.handleCreateChirps)
 	mux.HandleFunc("GET /api/chirps", apiConfig.handleGetChirps)
 	mux.HandleFunc("GET /api/chirps/{chirpID}", apiConfig.handleGetChirpsById)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/stats", apiConfig.handleGetChirpStats)
 	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiConfig.handleDeleteChirps)
+	mux.HandleFunc("GET /api/stream/chirps", apiConfig.handleStreamChirps)
+	mux.HandleFunc("GET /api/ws", apiConfig.handleWebSocket)
+
+	mux.HandleFunc("POST /api/oauth/clients", apiConfig.handleCreateOAuthClient)
+	mux.HandleFunc("GET /oauth/authorize", apiConfig.handleOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", apiConfig.handleOAuthConsent)
+	mux.HandleFunc("POST /oauth/token", apiConfig.handleOAuthToken)
+	mux.HandleFunc("POST /oauth/introspect", apiConfig.handleOAuthIntrospect)
+	mux.HandleFunc("POST /oauth/revoke", apiConfig.handleOAuthRevoke)
+
+	mux.HandleFunc("POST /api/webhooks", apiConfig.handleCreateWebhook)
+	mux.HandleFunc("GET /api/webhooks", apiConfig.handleListWebhooks)
+	mux.HandleFunc("DELETE /api/webhooks/{webhookID}", apiConfig.handleDeleteWebhook)
+	mux.HandleFunc("GET /api/webhooks/{webhookID}/deliveries", apiConfig.handleListWebhookDeliveries)
+	mux.HandleFunc("POST /api/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", apiConfig.handleRedeliverWebhook)
 
 	//webhooks
 	mux.HandleFunc("POST /api/polka/webhooks", apiConfig.handleUpdateUserToChirpyRed)
 
+	apiConfig.bus.Subscribe(apiConfig.routeEvent)
+	go apiConfig.purgeDeletedAccounts(context.Background(), time.Hour)
+	go apiConfig.pruneLoginFailures(context.Background(), time.Hour)
+	go apiConfig.deliverWebhooks(context.Background(), 5*time.Second)
+	go apiConfig.expireSubscriptions(context.Background(), time.Minute)
+	go apiConfig.writeImpressions(context.Background(), impressionFlushInterval)
+	go apiConfig.rollupImpressions(context.Background(), time.Minute)
+
 	fmt.Printf("Server running on port %v\n", server.Addr)
 
 	err = server.ListenAndServe()
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Moee1149/chirpy/internal/auth"
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/Moee1149/chirpy/internal/eventbus"
+	"github.com/Moee1149/chirpy/internal/mailer"
+	"github.com/Moee1149/chirpy/internal/password"
+	"github.com/Moee1149/chirpy/internal/pubsub"
+	"github.com/Moee1149/chirpy/internal/throttle"
+	"github.com/alexedwards/argon2id"
+	"github.com/google/uuid"
+)
+
+const testJWTKey = "test-jwt-key"
+
+func init() {
+	// keep password hashing cheap, tests hash plenty of them
+	auth.SetPasswordParams(&argon2id.Params{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+}
+
+// testMailer keeps what would have been sent and fails every send when err
+// is set.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+	err  error
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *testMailer) messages() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.sent...)
+}
+
+// waitForMessages waits for mail sent after the response, failing the test
+// if fewer than n messages arrive.
+func (m *testMailer) waitForMessages(t *testing.T, n int) []mailer.Message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sent := m.messages()
+		if len(sent) >= n {
+			return sent
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d messages, want %d", len(sent), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testConfig returns an apiConfig without a database, enough for handlers
+// that answer before they reach one.
+func testConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	store := throttle.NewMemoryStore()
+	return &apiConfig{
+		jwtKey:              testJWTKey,
+		mailer:              &testMailer{},
+		accountLimiter:      &throttle.Limiter{Store: store, Threshold: 5, BaseDelay: time.Second, MaxDelay: time.Minute},
+		ipLimiter:           &throttle.Limiter{Store: store, Threshold: 20, BaseDelay: time.Second, MaxDelay: time.Minute},
+		passwordPolicy:      password.DefaultPolicy,
+		deletionGracePeriod: time.Hour,
+		exportDir:           t.TempDir(),
+		chirpHub:            pubsub.NewHub(streamHistory),
+		userHub:             pubsub.NewHub(streamHistory),
+		bus:                 eventbus.NewLocal(),
+		webhookWake:         make(chan struct{}, 1),
+		impressions:         make(chan impression, impressionQueueSize),
+	}
+}
+
+// testDBConfig is testConfig backed by a fresh schema in the Postgres at
+// CHIRPY_TEST_DB_URL, migrated with sql/schema. Tests that need it are
+// skipped when the variable isn't set.
+func testDBConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dbUrl := os.Getenv("CHIRPY_TEST_DB_URL")
+	if dbUrl == "" {
+		t.Skip("CHIRPY_TEST_DB_URL not set")
+	}
+	admin, err := sql.Open("postgres", dbUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := "chirpy_test_" + randomHex(t, 6)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	u, err := url.Parse(dbUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrate(t, db)
+
+	cfg := testConfig(t)
+	cfg.db = db
+	cfg.dbQueries = database.New(db)
+	return cfg
+}
+
+// migrate applies the Up half of every goose migration in order.
+func migrate(t *testing.T, db *sql.DB) {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join("sql", "schema", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		up, _, _ := strings.Cut(string(content), "-- +goose Down")
+		up = strings.TrimPrefix(strings.TrimSpace(up), "-- +goose Up")
+		if _, err := db.Exec(up); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+	}
+}
+
+func randomHex(t *testing.T, n int) string {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// createTestUser makes a user with password "correct horse battery" and
+// returns them with a session access token.
+func createTestUser(t *testing.T, cfg *apiConfig) (database.User, string) {
+	t.Helper()
+	hash, err := auth.HashPassword("correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.dbQueries.CreateUser(context.Background(), database.CreateUserParams{
+		Email:          randomHex(t, 6) + "@example.com",
+		HashedPassword: hash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, sessionToken(t, cfg, user.ID, auth.AllScopes)
+}
+
+func sessionToken(t *testing.T, cfg *apiConfig, userId uuid.UUID, scopes []string) string {
+	t.Helper()
+	token, err := auth.MakeScopedJWT(userId, auth.RoleUser, cfg.jwtKey, time.Hour, scopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// createTestAPIKey stores a personal API key for the user and returns the
+// raw key.
+func createTestAPIKey(t *testing.T, cfg *apiConfig, userId uuid.UUID, scopes []string) string {
+	t.Helper()
+	raw, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cfg.dbQueries.CreateApiKey(context.Background(), database.CreateApiKeyParams{
+		Name:      "test",
+		HashedKey: auth.HashToken(raw),
+		Scopes:    scopes,
+		UserID:    userId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// newRequest builds a request with an optional bearer token and JSON body.
+func newRequest(t *testing.T, method, target, token string, body any) *http.Request {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(data))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// do is newRequest and serve for handlers that read no path values.
+func do(t *testing.T, handler http.HandlerFunc, method, target, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	return serve(handler, newRequest(t, method, target, token, body))
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func decodeBody(t *testing.T, resp *http.Response, v any) {
+	t.Helper()
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+}
+
+// mailedToken pulls the token out of a mail body, where it sits on a line of
+// its own.
+func mailedToken(t *testing.T, msg mailer.Message) string {
+	t.Helper()
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if len(line) == 64 && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+	t.Fatalf("no token in %q", msg.Body)
+	return ""
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"slices"
+
+	"github.com/Moee1149/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Notification types. New event sources add a constant here and to
+// notificationTypes so users can opt out of them.
+const (
+	notifyChirpyRedUpgraded = "chirpy_red_upgraded"
+	notifyChirpyRedEnded    = "chirpy_red_ended"
+	notifyChirpDeleted      = "chirp_deleted"
+	notifyPasswordChanged   = "password_changed"
+	notifyEmailChanged      = "email_changed"
+	notifyNewLogin          = "new_login"
+	notifyMFAEnabled        = "mfa_enabled"
+)
+
+var notificationTypes = []string{
+	notifyChirpyRedUpgraded,
+	notifyChirpyRedEnded,
+	notifyChirpDeleted,
+	notifyPasswordChanged,
+	notifyEmailChanged,
+	notifyNewLogin,
+	notifyMFAEnabled,
+}
+
+// mandatoryNotificationTypes are the security notifications: they tell a
+// user someone else may have got into their account, so they can't be
+// turned off.
+var mandatoryNotificationTypes = []string{
+	notifyPasswordChanged,
+	notifyEmailChanged,
+	notifyNewLogin,
+	notifyMFAEnabled,
+}
+
+func isValidNotificationType(kind string) bool {
+	return slices.Contains(notificationTypes, kind)
+}
+
+func isMandatoryNotificationType(kind string) bool {
+	return slices.Contains(mandatoryNotificationTypes, kind)
+}
+
+// notify records a notification for userId unless they've turned that type
+// off, which they can't for mandatory types, and pushes it to their live connections. Failures are logged rather
+// than returned so a notification never breaks the action that caused it;
+// call it after any transaction commits.
+func (cfg *apiConfig) notify(ctx context.Context, userId uuid.UUID, kind string, payload any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error encoding %s notification: %v", kind, err)
+		return
+	}
+	notification, err := cfg.dbQueries.CreateNotification(ctx, database.CreateNotificationParams{
+		UserID:    userId,
+		Type:      kind,
+		Payload:   body,
+		Mandatory: isMandatoryNotificationType(kind),
+	})
+	if err == sql.ErrNoRows {
+		// the user turned this type off
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating %s notification: %v", kind, err)
+		return
+	}
+	cfg.publishUserEvent(ctx, eventNotification, userId, toNotificationSchema(notification))
+}
@@ -0,0 +1,26 @@
+//go:build dev
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/Moee1149/chirpy/internal/oidc"
+	"github.com/Moee1149/chirpy/internal/oidc/oidctest"
+)
+
+// startDevOIDCProvider points client at a stand-in provider running in
+// process that logs everyone in as the same test user. It only exists in
+// binaries built with -tags dev.
+func startDevOIDCProvider(client *oidc.Client) error {
+	provider := oidctest.NewProvider("chirpy-dev", "chirpy-dev-secret")
+	provider.SetUser(oidctest.User{Subject: "dev-user", Email: "dev@chirpy.local", EmailVerified: true})
+	client.Issuer = provider.Issuer()
+	client.ClientID = provider.ClientID
+	client.ClientSecret = provider.ClientSecret
+	if client.RedirectURL == "" {
+		client.RedirectURL = "http://localhost:8080/api/auth/oidc/callback"
+	}
+	fmt.Printf("Dev OIDC provider running at %v\n", provider.Issuer())
+	return nil
+}
@@ -0,0 +1,13 @@
+//go:build !dev
+
+package main
+
+import (
+	"errors"
+
+	"github.com/Moee1149/chirpy/internal/oidc"
+)
+
+func startDevOIDCProvider(client *oidc.Client) error {
+	return errors.New("OIDC_ISSUER=dev needs a binary built with -tags dev")
+}
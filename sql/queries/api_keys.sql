@@ -0,0 +1,20 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys (id, created_at, updated_at, name, hashed_key, scopes, expires_at, user_id)
+VALUES (gen_random_uuid(), now(), now(), $1, $2, $3, $4, $5) RETURNING *;
+
+-- name: GetApiKeyByHash :one
+SELECT * FROM api_keys WHERE hashed_key = $1;
+
+-- name: GetApiKeysByUserId :many
+SELECT * FROM api_keys WHERE user_id = $1 ORDER BY created_at ASC;
+
+-- name: TouchApiKey :exec
+UPDATE api_keys SET last_used_at = now() WHERE id = $1;
+
+-- name: RevokeApiKey :execresult
+UPDATE api_keys SET revoked_at = now(), updated_at = now()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeAllUserApiKeys :exec
+UPDATE api_keys SET revoked_at = now(), updated_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
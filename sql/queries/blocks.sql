@@ -0,0 +1,33 @@
+-- name: BlockUser :exec
+INSERT INTO user_blocks (blocker_id, blocked_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: UnblockUser :execresult
+DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2;
+
+-- name: ListBlockedUsers :many
+SELECT * FROM user_blocks WHERE blocker_id = $1 ORDER BY created_at DESC;
+
+-- name: IsBlockedEitherWay :one
+SELECT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE (blocker_id = $1 AND blocked_id = $2)
+       OR (blocker_id = $2 AND blocked_id = $1)
+);
+
+-- name: MuteUser :exec
+INSERT INTO user_mutes (muter_id, muted_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING;
+
+-- name: UnmuteUser :execresult
+DELETE FROM user_mutes WHERE muter_id = $1 AND muted_id = $2;
+
+-- name: ListMutedUsers :many
+SELECT * FROM user_mutes WHERE muter_id = $1 ORDER BY created_at DESC;
+
+-- name: ListBlockRelatedUserIds :many
+SELECT blocked_id FROM user_blocks WHERE user_blocks.blocker_id = $1
+UNION
+SELECT blocker_id FROM user_blocks WHERE user_blocks.blocked_id = $1;
@@ -5,14 +5,71 @@ VALUES (gen_random_uuid(), now(), now(), $1, $2) RETURNING *;
 -- name: DropChirpsTable :exec
 DELETE FROM chirps;
 
--- name: GetAllChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
-
 -- name: GetChirpsByAuthorId :many
-SELECT * FROM chirps WHERE user_id = $1 ORDER BY created_at ASC;
+SELECT * FROM chirps
+WHERE user_id = sqlc.arg('user_id') AND user_id NOT IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+    AND NOT EXISTS (
+        SELECT 1 FROM user_blocks b
+        WHERE (b.blocker_id = chirps.user_id AND b.blocked_id = sqlc.narg('viewer_id')::uuid)
+           OR (b.blocker_id = sqlc.narg('viewer_id')::uuid AND b.blocked_id = chirps.user_id)
+    )
+ORDER BY created_at ASC;
 
 -- name: GetChirpsById :one
-SELECT * FROM chirps WHERE id = $1;
+SELECT * FROM chirps
+WHERE id = sqlc.arg('id') AND user_id NOT IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+    AND NOT EXISTS (
+        SELECT 1 FROM user_blocks b
+        WHERE (b.blocker_id = chirps.user_id AND b.blocked_id = sqlc.narg('viewer_id')::uuid)
+           OR (b.blocker_id = sqlc.narg('viewer_id')::uuid AND b.blocked_id = chirps.user_id)
+    );
 
 -- name: DeleteChirpById :execresult
 DELETE FROM chirps WHERE id = $1 AND user_id=$2;
+
+-- name: DeleteChirpByIdAsModerator :execresult
+DELETE FROM chirps WHERE id = $1;
+
+-- name: CountChirpsByAuthorId :one
+SELECT count(*) FROM chirps WHERE user_id = $1;
+
+-- name: CountChirpsSince :one
+SELECT count(*) FROM chirps WHERE user_id = $1 AND created_at > $2;
+
+-- name: LockChirpQuota :exec
+-- Held until the transaction ends, so one user's concurrent posts take
+-- turns counting against their quota and inserting.
+SELECT pg_advisory_xact_lock(hashtext('chirp_quota:' || sqlc.arg('user_id')::text));
+
+-- name: ListChirps :many
+-- One page of chirps, oldest first unless descending, continuing after the
+-- chirp with id after. Mutes only hide chirps from the unfiltered list.
+SELECT * FROM chirps
+WHERE (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+    AND user_id NOT IN (SELECT id FROM users WHERE deleted_at IS NOT NULL)
+    AND NOT EXISTS (
+        SELECT 1 FROM user_blocks b
+        WHERE (b.blocker_id = chirps.user_id AND b.blocked_id = sqlc.narg('viewer_id')::uuid)
+           OR (b.blocker_id = sqlc.narg('viewer_id')::uuid AND b.blocked_id = chirps.user_id)
+    )
+    AND (
+        sqlc.narg('author_id')::uuid IS NOT NULL
+        OR NOT EXISTS (
+            SELECT 1 FROM user_mutes m
+            WHERE m.muter_id = sqlc.narg('viewer_id')::uuid AND m.muted_id = chirps.user_id
+        )
+    )
+    AND (
+        sqlc.narg('after')::uuid IS NULL
+        OR (sqlc.arg('descending')::bool AND (created_at, id) < (
+            SELECT a.created_at, a.id FROM chirps a WHERE a.id = sqlc.narg('after')
+        ))
+        OR (NOT sqlc.arg('descending')::bool AND (created_at, id) > (
+            SELECT a.created_at, a.id FROM chirps a WHERE a.id = sqlc.narg('after')
+        ))
+    )
+ORDER BY
+    CASE WHEN sqlc.arg('descending')::bool THEN created_at END DESC,
+    CASE WHEN sqlc.arg('descending')::bool THEN id END DESC,
+    created_at ASC, id ASC
+LIMIT sqlc.arg('limit');
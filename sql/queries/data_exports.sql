@@ -0,0 +1,9 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, created_at, updated_at, status, user_id)
+VALUES (gen_random_uuid(), now(), now(), 'pending', $1) RETURNING *;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports WHERE id = $1 AND user_id = $2;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports SET status = $1, file_path = $2, error = $3, updated_at = now() WHERE id = $4;
@@ -0,0 +1,12 @@
+-- name: CreateEmailVerificationToken :one
+INSERT INTO email_verification_tokens (hashed_token, created_at, expires_at, email, user_id)
+VALUES ($1, now(), $2, $3, $4) RETURNING *;
+
+-- name: InvalidateEmailVerificationTokens :exec
+UPDATE email_verification_tokens SET used_at = now()
+WHERE user_id = $1 AND used_at IS NULL;
+
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens SET used_at = now()
+WHERE hashed_token = $1 AND used_at IS NULL AND expires_at > now()
+RETURNING *;
@@ -0,0 +1,36 @@
+-- name: RecordInboundWebhookEvent :one
+INSERT INTO inbound_webhook_events (id, source, event_id, type, payload)
+VALUES (gen_random_uuid(), $1, $2, $3, $4)
+ON CONFLICT (source, event_id) DO UPDATE
+SET deliveries = inbound_webhook_events.deliveries + 1, last_received_at = now()
+RETURNING *;
+
+-- name: GetInboundWebhookEvent :one
+SELECT * FROM inbound_webhook_events WHERE id = $1;
+
+-- name: LockInboundWebhookEvent :one
+SELECT * FROM inbound_webhook_events WHERE id = $1 FOR UPDATE;
+
+-- name: CompleteInboundWebhookEvent :one
+UPDATE inbound_webhook_events
+SET status = $2, attempts = attempts + 1, processed_at = now(), last_error = NULL
+WHERE id = $1
+RETURNING *;
+
+-- name: FailInboundWebhookEvent :exec
+UPDATE inbound_webhook_events
+SET status = 'failed', attempts = attempts + 1, last_error = $2
+WHERE id = $1;
+
+-- name: ListInboundWebhookEvents :many
+SELECT * FROM inbound_webhook_events
+WHERE (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status'))
+    AND (
+        sqlc.narg('before')::uuid IS NULL
+        OR (received_at, id) < (
+            SELECT b.received_at, b.id FROM inbound_webhook_events b
+            WHERE b.id = sqlc.narg('before')
+        )
+    )
+ORDER BY received_at DESC, id DESC
+LIMIT sqlc.arg('limit');
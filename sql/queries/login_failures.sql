@@ -0,0 +1,23 @@
+-- name: GetLoginFailure :one
+SELECT * FROM login_failures WHERE key = $1;
+
+-- name: IncrementLoginFailure :one
+-- updated_at is the last failure. When that is older than since the
+-- earlier failures have decayed and counting starts over.
+INSERT INTO login_failures (key, failures, updated_at)
+VALUES (sqlc.arg('key'), 1, sqlc.arg('failed_at'))
+ON CONFLICT (key) DO UPDATE
+SET failures = CASE WHEN login_failures.updated_at < sqlc.arg('since') THEN 1 ELSE login_failures.failures + 1 END,
+    locked_until = CASE WHEN login_failures.updated_at < sqlc.arg('since') THEN NULL ELSE login_failures.locked_until END,
+    updated_at = sqlc.arg('failed_at')
+RETURNING *;
+
+-- name: SetLoginLockedUntil :exec
+UPDATE login_failures SET locked_until = $1 WHERE key = $2;
+
+-- name: DeleteLoginFailure :exec
+DELETE FROM login_failures WHERE key = $1;
+
+-- name: DeleteStaleLoginFailures :exec
+DELETE FROM login_failures
+WHERE updated_at < $1 AND (locked_until IS NULL OR locked_until < $1);
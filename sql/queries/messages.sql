@@ -0,0 +1,77 @@
+-- name: CreateConversation :one
+-- No row comes back when a one-to-one conversation with the same
+-- direct_key already exists.
+INSERT INTO conversations (id, created_by, direct_key)
+VALUES (gen_random_uuid(), $1, $2)
+ON CONFLICT (direct_key) DO NOTHING
+RETURNING *;
+
+-- name: GetConversationById :one
+SELECT * FROM conversations WHERE id = $1;
+
+-- name: AddConversationParticipant :exec
+INSERT INTO conversation_participants (conversation_id, user_id)
+VALUES ($1, $2);
+
+-- name: GetConversationParticipants :many
+SELECT * FROM conversation_participants
+WHERE conversation_id = $1
+ORDER BY joined_at ASC;
+
+-- name: GetConversationParticipant :one
+SELECT * FROM conversation_participants
+WHERE conversation_id = $1 AND user_id = $2;
+
+-- name: FindDirectConversation :one
+SELECT * FROM conversations WHERE direct_key = $1;
+
+-- name: ListConversationsForUser :many
+SELECT c.id, c.created_at, c.updated_at, c.created_by, c.direct_key,
+    (
+        SELECT count(*) FROM messages m
+        WHERE m.conversation_id = c.id
+            AND m.sender_id <> p.user_id
+            AND (p.last_read_at IS NULL OR m.created_at > p.last_read_at)
+    ) AS unread_count
+FROM conversations c
+JOIN conversation_participants p ON p.conversation_id = c.id
+WHERE p.user_id = $1
+ORDER BY c.updated_at DESC;
+
+-- name: TouchConversation :exec
+UPDATE conversations SET updated_at = now() WHERE id = $1;
+
+-- name: HasBlockInConversation :one
+SELECT EXISTS (
+    SELECT 1 FROM conversation_participants p
+    JOIN user_blocks b
+        ON (b.blocker_id = p.user_id AND b.blocked_id = sqlc.arg('user_id'))
+        OR (b.blocker_id = sqlc.arg('user_id') AND b.blocked_id = p.user_id)
+    WHERE p.conversation_id = sqlc.arg('conversation_id') AND p.user_id <> sqlc.arg('user_id')
+);
+
+-- name: CreateMessage :one
+INSERT INTO messages (id, conversation_id, sender_id, body)
+VALUES (gen_random_uuid(), $1, $2, $3)
+RETURNING *;
+
+-- name: GetMessageById :one
+SELECT * FROM messages WHERE id = $1 AND conversation_id = $2;
+
+-- name: ListMessages :many
+SELECT * FROM messages
+WHERE conversation_id = sqlc.arg('conversation_id')
+    AND (
+        sqlc.narg('before')::uuid IS NULL
+        OR (created_at, id) < (
+            SELECT b.created_at, b.id FROM messages b
+            WHERE b.id = sqlc.narg('before') AND b.conversation_id = sqlc.arg('conversation_id')
+        )
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: MarkConversationRead :exec
+UPDATE conversation_participants
+SET last_read_at = GREATEST(last_read_at, COALESCE(sqlc.narg('read_at')::timestamp, now()))
+WHERE conversation_id = sqlc.arg('conversation_id') AND user_id = sqlc.arg('user_id');
@@ -0,0 +1,28 @@
+-- name: UpsertUserTotp :one
+INSERT INTO user_totp (user_id, created_at, updated_at, secret)
+VALUES ($1, now(), now(), $2)
+ON CONFLICT (user_id) DO UPDATE
+SET secret = EXCLUDED.secret, confirmed_at = NULL, last_used_step = 0, updated_at = now()
+RETURNING *;
+
+-- name: GetUserTotp :one
+SELECT * FROM user_totp WHERE user_id = $1;
+
+-- name: ConfirmUserTotp :one
+UPDATE user_totp SET confirmed_at = now(), last_used_step = $1, updated_at = now()
+WHERE user_id = $2 RETURNING *;
+
+-- name: UpdateTotpLastUsedStep :execresult
+UPDATE user_totp SET last_used_step = $1, updated_at = now()
+WHERE user_id = $2 AND last_used_step < $1;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id = $1;
+
+-- name: InsertRecoveryCode :exec
+INSERT INTO recovery_codes (id, created_at, hashed_code, user_id)
+VALUES (gen_random_uuid(), now(), $1, $2);
+
+-- name: UseRecoveryCode :execresult
+UPDATE recovery_codes SET used_at = now()
+WHERE user_id = $1 AND hashed_code = $2 AND used_at IS NULL;
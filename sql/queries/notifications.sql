@@ -0,0 +1,40 @@
+-- name: CreateNotification :one
+-- Mandatory notifications go out whatever the preferences say, including
+-- ones saved before the type became mandatory.
+INSERT INTO notifications (id, user_id, type, payload)
+SELECT gen_random_uuid(), sqlc.arg('user_id')::uuid, sqlc.arg('type')::text, sqlc.arg('payload')::jsonb
+WHERE sqlc.arg('mandatory')::boolean OR NOT EXISTS (
+    SELECT 1 FROM notification_preferences
+    WHERE user_id = sqlc.arg('user_id')::uuid AND type = sqlc.arg('type')::text AND NOT enabled
+)
+RETURNING *;
+
+-- name: ListNotifications :many
+SELECT * FROM notifications
+WHERE user_id = sqlc.arg('user_id')
+    AND (NOT sqlc.arg('unread_only')::boolean OR read_at IS NULL)
+    AND (
+        sqlc.narg('before')::uuid IS NULL
+        OR (created_at, id) < (
+            SELECT b.created_at, b.id FROM notifications b
+            WHERE b.id = sqlc.narg('before') AND b.user_id = sqlc.arg('user_id')
+        )
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: MarkNotificationRead :execresult
+UPDATE notifications SET read_at = COALESCE(read_at, now())
+WHERE id = $1 AND user_id = $2;
+
+-- name: MarkAllNotificationsRead :execrows
+UPDATE notifications SET read_at = now()
+WHERE user_id = $1 AND read_at IS NULL;
+
+-- name: GetNotificationPreferences :many
+SELECT * FROM notification_preferences WHERE user_id = $1;
+
+-- name: SetNotificationPreference :exec
+INSERT INTO notification_preferences (user_id, type, enabled)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled, updated_at = now();
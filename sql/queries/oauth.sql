@@ -0,0 +1,38 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, updated_at, client_id, hashed_secret, name, redirect_uris, scopes, owner_id)
+VALUES (gen_random_uuid(), now(), now(), $1, $2, $3, $4, $5, $6) RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE client_id = $1;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (hashed_code, created_at, expires_at, client_id, redirect_uri, scopes, code_challenge, user_id)
+VALUES ($1, now(), $2, $3, $4, $5, $6, $7);
+
+-- name: UseOAuthAuthorizationCode :one
+UPDATE oauth_authorization_codes SET used_at = now()
+WHERE hashed_code = $1 AND used_at IS NULL AND expires_at > now()
+RETURNING *;
+
+-- name: CreateOAuthToken :exec
+INSERT INTO oauth_tokens (hashed_token, created_at, kind, expires_at, client_id, scopes, user_id)
+VALUES ($1, now(), $2, $3, $4, $5, $6);
+
+-- name: GetOAuthToken :one
+SELECT * FROM oauth_tokens WHERE hashed_token = $1;
+
+-- name: RevokeOAuthToken :exec
+UPDATE oauth_tokens SET revoked_at = now()
+WHERE hashed_token = $1 AND client_id = $2 AND revoked_at IS NULL;
+
+-- name: UseOAuthRefreshToken :one
+-- Rotation revokes the refresh token as it is used, so of two requests
+-- replaying the same token only one gets a row back.
+UPDATE oauth_tokens SET revoked_at = now()
+WHERE hashed_token = $1 AND client_id = $2 AND kind = 'refresh'
+    AND revoked_at IS NULL AND expires_at > now()
+RETURNING *;
+
+-- name: RevokeAllUserOAuthTokens :exec
+UPDATE oauth_tokens SET revoked_at = now()
+WHERE user_id = $1 AND revoked_at IS NULL;
@@ -0,0 +1,8 @@
+-- name: CreatePasswordResetToken :one
+INSERT INTO password_reset_tokens (hashed_token, created_at, expires_at, user_id)
+VALUES ($1, now(), $2, $3) RETURNING *;
+
+-- name: UsePasswordResetToken :one
+UPDATE password_reset_tokens SET used_at = now()
+WHERE hashed_token = $1 AND used_at IS NULL AND expires_at > now()
+RETURNING *;
@@ -0,0 +1,57 @@
+-- name: RecordImpressions :exec
+-- Writes a batch from many requests at once. Impressions of chirps deleted
+-- since they were seen are skipped rather than failing the batch.
+INSERT INTO chirp_impressions (chirp_id, author_id, viewer_key, bucket)
+SELECT i.chirp_id, i.author_id, i.viewer_key, date_trunc('hour', now())
+FROM unnest(sqlc.arg('chirp_ids')::uuid[], sqlc.arg('author_ids')::uuid[], sqlc.arg('viewer_keys')::text[])
+    AS i (chirp_id, author_id, viewer_key)
+WHERE EXISTS (SELECT 1 FROM chirps c WHERE c.id = i.chirp_id)
+ON CONFLICT DO NOTHING;
+
+-- name: RollupImpressions :exec
+-- Claiming, counting and adding up happen in one statement, so instances
+-- rolling up at the same time never count a row twice.
+WITH claimed AS (
+    UPDATE chirp_impressions SET rolled_up = true
+    WHERE NOT rolled_up
+    RETURNING chirp_id, author_id, bucket
+), per_chirp AS (
+    INSERT INTO chirp_stats_hourly (chirp_id, author_id, bucket, impressions)
+    SELECT chirp_id, author_id, bucket, count(*) FROM claimed
+    WHERE EXISTS (SELECT 1 FROM chirps c WHERE c.id = claimed.chirp_id)
+    GROUP BY chirp_id, author_id, bucket
+    ON CONFLICT (chirp_id, bucket) DO UPDATE
+    SET impressions = chirp_stats_hourly.impressions + EXCLUDED.impressions
+)
+INSERT INTO author_stats_hourly (author_id, bucket, impressions)
+SELECT author_id, bucket, count(*) FROM claimed
+WHERE EXISTS (SELECT 1 FROM users u WHERE u.id = claimed.author_id)
+GROUP BY author_id, bucket
+ON CONFLICT (author_id, bucket) DO UPDATE
+SET impressions = author_stats_hourly.impressions + EXCLUDED.impressions;
+
+-- name: PruneImpressions :execrows
+DELETE FROM chirp_impressions
+WHERE rolled_up AND bucket < date_trunc('hour', now());
+
+-- name: GetChirpStats :many
+SELECT date_trunc(sqlc.arg('granularity')::text, bucket)::timestamp AS period, sum(impressions)::bigint AS impressions
+FROM chirp_stats_hourly
+WHERE chirp_id = sqlc.arg('chirp_id') AND bucket >= sqlc.arg('since')
+GROUP BY period
+ORDER BY period;
+
+-- name: GetAuthorStats :many
+SELECT date_trunc(sqlc.arg('granularity')::text, bucket)::timestamp AS period, sum(impressions)::bigint AS impressions
+FROM author_stats_hourly
+WHERE author_id = sqlc.arg('author_id') AND bucket >= sqlc.arg('since')
+GROUP BY period
+ORDER BY period;
+
+-- name: GetTopChirpsByImpressions :many
+SELECT chirp_id, sum(impressions)::bigint AS impressions
+FROM chirp_stats_hourly
+WHERE author_id = sqlc.arg('author_id') AND bucket >= sqlc.arg('since')
+GROUP BY chirp_id
+ORDER BY impressions DESC, chirp_id
+LIMIT sqlc.arg('limit');
@@ -0,0 +1,23 @@
+-- name: GetSubscriptionByUserId :one
+SELECT * FROM subscriptions WHERE user_id = $1;
+
+-- name: UpsertSubscription :one
+INSERT INTO subscriptions (id, user_id, plan, status, current_period_end, cancelled_at, last_event_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6)
+ON CONFLICT (user_id) DO UPDATE
+SET plan = EXCLUDED.plan, status = EXCLUDED.status, current_period_end = EXCLUDED.current_period_end,
+    cancelled_at = EXCLUDED.cancelled_at, last_event_at = EXCLUDED.last_event_at, updated_at = now()
+RETURNING *;
+
+-- name: ExpireSubscriptions :many
+-- Expiring and downgrading happen in one statement so a crash can't leave
+-- a user with Chirpy Red and no subscription.
+WITH expired AS (
+    UPDATE subscriptions SET status = 'expired', updated_at = now()
+    WHERE status IN ('active', 'past_due', 'cancelled') AND current_period_end <= now()
+    RETURNING user_id
+)
+UPDATE users SET is_chirpy_red = false, version = users.version + 1
+FROM expired
+WHERE users.id = expired.user_id AND users.is_chirpy_red
+RETURNING users.*;
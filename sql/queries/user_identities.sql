@@ -0,0 +1,13 @@
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (id, created_at, provider, subject, email, user_id)
+VALUES (gen_random_uuid(), now(), $1, $2, $3, $4) RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities WHERE provider = $1 AND subject = $2;
+
+-- name: CreateOidcLoginState :exec
+INSERT INTO oidc_login_states (state, created_at, expires_at, code_verifier, nonce)
+VALUES ($1, now(), $2, $3, $4);
+
+-- name: ConsumeOidcLoginState :one
+DELETE FROM oidc_login_states WHERE state = $1 AND expires_at > now() RETURNING *;
@@ -9,7 +9,69 @@ DELETE FROM users;
 SELECT * FROM users WHERE email = $1;
 
 -- name: UpdateUserById :one
-UPDATE users SET email=$1, hashed_password=$2, updated_at=now() WHERE id = $3 RETURNING *;
+UPDATE users SET email=$1, hashed_password=$2, updated_at=now(), version=version+1 WHERE id = $3 RETURNING *;
 
--- name: UpdateUserToChirpyRed :one
-UPDATE users SET is_chirpy_red=$1 WHERE id=$2 RETURNING *;
+-- name: SyncUserChirpyRed :one
+-- is_chirpy_red is only ever written here, from the user's subscription.
+-- No row comes back when it was already right.
+UPDATE users SET is_chirpy_red = NOT is_chirpy_red, version = version + 1
+WHERE id = $1 AND is_chirpy_red <> EXISTS (
+    SELECT 1 FROM subscriptions s
+    WHERE s.user_id = users.id
+        AND s.status IN ('active', 'past_due', 'cancelled')
+        AND s.current_period_end > now()
+)
+RETURNING *;
+
+-- name: GetUserById :one
+SELECT * FROM users WHERE id = $1;
+
+-- name: UpdateUserPassword :one
+UPDATE users SET hashed_password=$1, updated_at=now(), version=version+1 WHERE id = $2 RETURNING *;
+
+-- name: SetUserVerifiedEmail :one
+UPDATE users SET email=$1, email_verified_at=now(), updated_at=now(), version=version+1 WHERE id = $2 RETURNING *;
+
+-- name: SetUserRole :one
+UPDATE users SET role=$1, updated_at=now(), version=version+1 WHERE id = $2 RETURNING *;
+
+-- name: MarkUserDeleted :one
+UPDATE users SET deleted_at=now(), updated_at=now(), version=version+1 WHERE id = $1 RETURNING *;
+
+-- name: RestoreUser :one
+UPDATE users SET deleted_at=NULL, updated_at=now(), version=version+1 WHERE id = $1 RETURNING *;
+
+-- name: PurgeDeletedUsers :many
+-- The users' export rows cascade away with them, so their archive paths
+-- come back to remove the files too. Users without exports get one row
+-- with an empty path.
+WITH purged AS (
+    DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING id
+)
+SELECT purged.id AS user_id, COALESCE(data_exports.file_path, '')::text AS file_path
+FROM purged LEFT JOIN data_exports ON data_exports.user_id = purged.id;
+
+-- name: IsUserActive :one
+SELECT deleted_at IS NULL FROM users WHERE id = $1;
+
+-- name: GetUserAccess :one
+-- The role is read on every request rather than trusted from the token, so
+-- a demotion takes effect straight away.
+SELECT deleted_at IS NULL AS active, role FROM users WHERE id = $1;
+
+-- name: GetUserByUsername :one
+SELECT * FROM users WHERE lower(username) = lower($1);
+
+-- name: UpdateUserProfile :one
+UPDATE users SET
+    username = COALESCE(sqlc.narg('username'), username),
+    display_name = COALESCE(sqlc.narg('display_name'), display_name),
+    bio = COALESCE(sqlc.narg('bio'), bio),
+    avatar_url = COALESCE(sqlc.narg('avatar_url'), avatar_url),
+    hashed_password = COALESCE(sqlc.narg('hashed_password'), hashed_password),
+    accept_messages = COALESCE(sqlc.narg('accept_messages'), accept_messages),
+    updated_at = now(),
+    version = version + 1
+WHERE id = sqlc.arg('id')
+    AND (sqlc.narg('expected_version')::integer IS NULL OR version = sqlc.narg('expected_version'))
+RETURNING *;
@@ -0,0 +1,70 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, user_id, url, secret, event_types, global)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints WHERE user_id = $1
+ORDER BY created_at;
+
+-- name: GetWebhookEndpoint :one
+SELECT * FROM webhook_endpoints WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteWebhookEndpoint :execresult
+DELETE FROM webhook_endpoints WHERE id = $1 AND user_id = $2;
+
+-- name: EnqueueWebhookDeliveries :execrows
+-- Global endpoints only get other users' events while their owner is still
+-- an admin; the role is checked when the endpoint is created, but it can be
+-- taken away later.
+INSERT INTO webhook_deliveries (id, endpoint_id, event_id, event_type, payload)
+SELECT gen_random_uuid(), e.id, sqlc.arg('event_id')::uuid, sqlc.arg('event_type')::text, sqlc.arg('payload')::jsonb
+FROM webhook_endpoints e
+JOIN users u ON u.id = e.user_id
+WHERE sqlc.arg('event_type')::text = ANY(e.event_types)
+    AND (
+        e.user_id = sqlc.arg('user_id')::uuid
+        OR (e.global AND u.role = 'admin' AND u.deleted_at IS NULL)
+    );
+
+-- name: ClaimWebhookDeliveries :many
+WITH due AS (
+    SELECT id FROM webhook_deliveries
+    WHERE status = 'pending' AND next_attempt_at <= now()
+    ORDER BY next_attempt_at
+    LIMIT sqlc.arg('limit')
+    FOR UPDATE SKIP LOCKED
+)
+UPDATE webhook_deliveries d SET next_attempt_at = sqlc.arg('lease_until')
+FROM due, webhook_endpoints e, users u
+WHERE d.id = due.id AND e.id = d.endpoint_id AND u.id = e.user_id
+RETURNING d.id, d.event_type, d.payload, d.attempts, e.url, e.secret, e.global, u.role AS owner_role;
+
+-- name: RecordWebhookAttempt :exec
+UPDATE webhook_deliveries
+SET status = $2, attempts = attempts + 1, last_attempt_at = now(), next_attempt_at = $3,
+    response_status = $4, last_error = $5
+WHERE id = $1;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = sqlc.arg('endpoint_id')
+    AND (
+        sqlc.narg('before')::uuid IS NULL
+        OR (created_at, id) < (
+            SELECT b.created_at, b.id FROM webhook_deliveries b
+            WHERE b.id = sqlc.narg('before') AND b.endpoint_id = sqlc.arg('endpoint_id')
+        )
+    )
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries WHERE id = $1 AND endpoint_id = $2;
+
+-- name: RedeliverWebhook :one
+INSERT INTO webhook_deliveries (id, endpoint_id, event_id, event_type, payload)
+SELECT gen_random_uuid(), endpoint_id, event_id, event_type, payload
+FROM webhook_deliveries
+WHERE webhook_deliveries.id = $1 AND webhook_deliveries.endpoint_id = $2
+RETURNING *;
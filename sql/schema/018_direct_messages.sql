@@ -0,0 +1,40 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN accept_messages TEXT NOT NULL DEFAULT 'everyone'
+    CHECK (accept_messages IN ('everyone', 'nobody'));
+
+CREATE TABLE conversations (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP NOT NULL DEFAULT now(),
+    -- the creator leaving Chirpy doesn't take everyone else's messages along
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    -- set on one-to-one conversations to the pair's ids in order, so there
+    -- is only ever one between two people
+    direct_key TEXT UNIQUE
+);
+
+CREATE TABLE conversation_participants (
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_read_at TIMESTAMP DEFAULT NULL,
+    PRIMARY KEY (conversation_id, user_id)
+);
+
+CREATE INDEX conversation_participants_user_id_idx ON conversation_participants (user_id);
+
+CREATE TABLE messages (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    body TEXT NOT NULL
+);
+
+CREATE INDEX messages_conversation_created_at_idx ON messages (conversation_id, created_at DESC, id DESC);
+
+-- +goose Down
+DROP TABLE messages;
+DROP TABLE conversation_participants;
+DROP TABLE conversations;
+ALTER TABLE users DROP COLUMN accept_messages;
@@ -0,0 +1,26 @@
+-- +goose Up
+CREATE TABLE inbound_webhook_events (
+    id UUID PRIMARY KEY,
+    source TEXT NOT NULL,
+    -- the sender's id for the event, retries of one event share it. NULL
+    -- when the delivery carried nothing that identifies it, and NULLs never
+    -- conflict, so such events are always stored and applied
+    event_id TEXT,
+    type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    -- deliveries counts how many times the sender sent it, attempts how
+    -- many times we tried to process it
+    deliveries INTEGER NOT NULL DEFAULT 1,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    received_at TIMESTAMP NOT NULL DEFAULT now(),
+    last_received_at TIMESTAMP NOT NULL DEFAULT now(),
+    processed_at TIMESTAMP DEFAULT NULL,
+    last_error TEXT DEFAULT NULL,
+    UNIQUE (source, event_id)
+);
+
+CREATE INDEX inbound_webhook_events_received_at_idx ON inbound_webhook_events (received_at DESC, id DESC);
+
+-- +goose Down
+DROP TABLE inbound_webhook_events;
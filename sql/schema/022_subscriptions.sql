@@ -0,0 +1,29 @@
+-- +goose Up
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    updated_at TIMESTAMP NOT NULL DEFAULT now(),
+    user_id UUID NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+    plan TEXT NOT NULL,
+    -- active, past_due and cancelled keep Chirpy Red until
+    -- current_period_end, expired doesn't
+    status TEXT NOT NULL,
+    current_period_end TIMESTAMP NOT NULL,
+    cancelled_at TIMESTAMP DEFAULT NULL,
+    -- when the newest event applied so far happened, by Polka's clock;
+    -- events older than it arrived late and are ignored
+    last_event_at TIMESTAMP DEFAULT NULL
+);
+
+CREATE INDEX subscriptions_period_end_idx ON subscriptions (current_period_end)
+    WHERE status IN ('active', 'past_due', 'cancelled');
+
+-- upgrades before this had no term, so they stay open-ended (the same far
+-- future end an upgrade without current_period_end gets) until Polka sends
+-- a renewal, cancellation or downgrade for them
+INSERT INTO subscriptions (id, user_id, plan, status, current_period_end)
+SELECT gen_random_uuid(), id, 'red', 'active', '9999-12-31'
+FROM users WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- daily chirp quotas count a user's recent chirps on every post
+CREATE INDEX chirps_user_created_at_idx ON chirps (user_id, created_at);
+
+-- +goose Down
+DROP INDEX chirps_user_created_at_idx;
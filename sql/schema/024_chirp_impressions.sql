@@ -0,0 +1,39 @@
+-- +goose Up
+-- raw impressions, one per viewer per chirp per hour. Rows are rolled up
+-- into the stats tables and pruned once their hour is over.
+CREATE TABLE chirp_impressions (
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    author_id UUID NOT NULL,
+    -- "user:<id>" for signed in viewers, a hash of the address (its /64 for
+    -- IPv6) for anonymous ones
+    viewer_key TEXT NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    rolled_up BOOLEAN NOT NULL DEFAULT false,
+    PRIMARY KEY (chirp_id, viewer_key, bucket)
+);
+
+CREATE INDEX chirp_impressions_pending_idx ON chirp_impressions (bucket) WHERE NOT rolled_up;
+
+CREATE TABLE chirp_stats_hourly (
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    author_id UUID NOT NULL,
+    bucket TIMESTAMP NOT NULL,
+    impressions BIGINT NOT NULL,
+    PRIMARY KEY (chirp_id, bucket)
+);
+
+CREATE INDEX chirp_stats_hourly_author_bucket_idx ON chirp_stats_hourly (author_id, bucket);
+
+-- kept apart from chirp_stats_hourly so an author's history survives
+-- deleting the chirps
+CREATE TABLE author_stats_hourly (
+    author_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    bucket TIMESTAMP NOT NULL,
+    impressions BIGINT NOT NULL,
+    PRIMARY KEY (author_id, bucket)
+);
+
+-- +goose Down
+DROP TABLE author_stats_hourly;
+DROP TABLE chirp_stats_hourly;
+DROP TABLE chirp_impressions;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- ids for events on the bus, shared by every instance so a live stream can
+-- resume on any of them
+CREATE SEQUENCE chirpy_event_ids;
+
+-- +goose Down
+DROP SEQUENCE chirpy_event_ids;
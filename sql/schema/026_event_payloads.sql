@@ -0,0 +1,11 @@
+-- +goose Up
+-- data of bus events too big for a NOTIFY payload; only the id goes over
+-- the channel and listeners load the rest from here
+CREATE TABLE event_payloads (
+    id BIGINT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT now(),
+    data BYTEA NOT NULL
+);
+
+-- +goose Down
+DROP TABLE event_payloads;